@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"github.com/tjfontaine/podspec2linuxkit/pkg/linuxkit"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+)
+
+// baseConfig is set by --platform and --base, and is what the generated configs are merged into
+var baseConfig *linuxkit.Moby
+
+// loadBase merges the profile of a platform, if one was chosen, and then the given files in order, like
+// base_image.yaml and then base_vmware.yaml
+func loadBase(platform string, platformDir string, files []string) error {
+	base := linuxkit.Moby{}
+	if platform != "" {
+		var err error
+		if base, err = loadPlatform(platform, platformDir); err != nil {
+			return err
+		}
+	}
+
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+
+		config, err := linuxkit.NewConfig(data)
+		if err != nil {
+			return fmt.Errorf("%s: %v", file, err)
+		}
+
+		if base, err = linuxkit.AppendConfig(base, config); err != nil {
+			return fmt.Errorf("%s: %v", file, err)
+		}
+	}
+
+	baseConfig = &base
+	return nil
+}
+
+// withBase merges a generated config into the base, if there is one, the way linuxkit build would have merged the
+// two files, so the result can be built on its own
+func withBase(config *linuxkit.Moby) (*linuxkit.Moby, error) {
+	if baseConfig == nil {
+		return config, nil
+	}
+
+	// a copy of the base, since the images of what is written out are rewritten and pinned in place
+	data, err := yaml.Marshal(baseConfig)
+	if err != nil {
+		return nil, err
+	}
+	base, err := linuxkit.NewConfig(data)
+	if err != nil {
+		return nil, err
+	}
+
+	merged, err := linuxkit.AppendConfig(base, *config)
+	if err != nil {
+		return nil, fmt.Errorf("conflicts with the base: %v", err)
+	}
+
+	if merged.Services == nil {
+		merged.Services = &[]*linuxkit.Image{}
+	}
+
+	return &merged, nil
+}
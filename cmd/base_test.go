@@ -0,0 +1,50 @@
+package main
+
+import (
+	"github.com/tjfontaine/podspec2linuxkit/pkg/linuxkit"
+	"strings"
+	"testing"
+)
+
+func TestWithBase(t *testing.T) {
+	defer func(base *linuxkit.Moby) { baseConfig = base }(baseConfig)
+
+	shim := entrypointShimFile()
+	pod := func() *linuxkit.Moby {
+		return &linuxkit.Moby{
+			Onboot:   &[]*linuxkit.Image{entrypointShimImage()},
+			Services: &[]*linuxkit.Image{{Name: "container-web", Image: "nginx:1.15"}},
+			Files:    &[]linuxkit.File{shim},
+		}
+	}
+
+	// a base generated by podspec2linuxkit for another pod, which already has the same shim
+	baseConfig = &linuxkit.Moby{
+		Onboot:   &[]*linuxkit.Image{entrypointShimImage()},
+		Services: &[]*linuxkit.Image{{Name: "container-api", Image: "nginx:1.15"}},
+		Files:    &[]linuxkit.File{shim},
+	}
+	merged, err := withBase(pod())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*merged.Onboot) != 1 || len(*merged.Services) != 2 || len(*merged.Files) != 1 {
+		t.Errorf("got %d onboot steps, %d services and %d files, want the shim once", len(*merged.Onboot), len(*merged.Services), len(*merged.Files))
+	}
+
+	// a base generated by an older podspec2linuxkit, whose shim differs
+	older := "#!/bin/sh\nexec \"$@\"\n"
+	baseConfig = &linuxkit.Moby{
+		Onboot: &[]*linuxkit.Image{entrypointShimImage()},
+		Files:  &[]linuxkit.File{{Path: shim.Path, Contents: &older, Mode: shim.Mode}},
+	}
+	if _, err := withBase(pod()); err == nil || !strings.Contains(err.Error(), "file "+shim.Path) {
+		t.Errorf("got %v, want a conflict on %s", err, shim.Path)
+	}
+
+	// a base with a service of the same name running something else
+	baseConfig = &linuxkit.Moby{Services: &[]*linuxkit.Image{{Name: "container-web", Image: "nginx:1.16"}}}
+	if _, err := withBase(pod()); err == nil || !strings.Contains(err.Error(), "services container-web") {
+		t.Errorf("got %v, want a conflict on services container-web", err)
+	}
+}
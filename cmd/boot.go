@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"github.com/tjfontaine/podspec2linuxkit/pkg/linuxkit"
+	"strings"
+)
+
+// the host's root filesystem as seen by bootScriptImage scripts
+const hostRoot = "/host"
+
+// bootScriptPrelude defines fail, which makes a problem impossible to miss: the reason goes to the console and the
+// VM powers off instead of carrying on to start services which depend on what was being set up
+var bootScriptPrelude = `fail() {
+  echo "podspec2linuxkit: $*" | tee /dev/console >&2
+  sync
+  poweroff -f
+  exit 1
+}
+`
+
+// bootScriptImage runs a shell script at boot with the host's root filesystem at hostRoot, and the ability to
+// power off the VM through fail
+func bootScriptImage(name string, script string) *linuxkit.Image {
+	return &linuxkit.Image{
+		Name:  name,
+		Image: "busybox:latest",
+		ImageConfig: linuxkit.ImageConfig{
+			Command:      &[]string{"/bin/sh", "-c", bootScriptPrelude + script},
+			Capabilities: &[]string{"CAP_SYS_BOOT", "CAP_DAC_OVERRIDE", "CAP_FOWNER", "CAP_CHOWN", "CAP_MKNOD"},
+			Binds:        &[]string{fmt.Sprintf("/:%s:rbind", hostRoot), "/dev/console:/dev/console"},
+			// poweroff only reaches the VM from the host pid namespace
+			Pid: "host",
+		},
+	}
+}
+
+// shellQuote makes a string safe to use as a single word in a generated script
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"github.com/tjfontaine/podspec2linuxkit/pkg/linuxkit"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// persistentVolumeClaimToLinuxKitMount gives a claim from a StatefulSet's volumeClaimTemplates a disk of its own.
+// On gcp and azure that is the disk named after the claim, like a dynamically provisioned one; elsewhere it's a
+// sparse file on the persistent /var/lib of the base image, kept across boots.
+func persistentVolumeClaimToLinuxKitMount(pod *podContext, volume *corev1.Volume) ([]*linuxkit.Image, error) {
+	source := volume.PersistentVolumeClaim
+	claim, ok := pod.claims[source.ClaimName]
+	if !ok {
+		return nil, fmt.Errorf("volume %s: persistentVolumeClaim %s isn't one of the StatefulSet's volumeClaimTemplates", volume.Name, source.ClaimName)
+	}
+
+	block := claim.Spec.VolumeMode != nil && *claim.Spec.VolumeMode == corev1.PersistentVolumeBlock
+	if pod.blockVolumes[volume.Name] && !block {
+		return nil, fmt.Errorf("volume %s: claim %s is used through volumeDevices, but its volumeMode isn't Block", volume.Name, source.ClaimName)
+	}
+	if block && !pod.blockVolumes[volume.Name] {
+		// like the kubelet, a block volume no container asks for through volumeDevices is left alone
+		log.Infof("volume %s: no container uses the block claim %s through volumeDevices, leaving it unattached", volume.Name, source.ClaimName)
+		return nil, nil
+	}
+
+	readOnly := source.ReadOnly
+	for _, mode := range claim.Spec.AccessModes {
+		if mode == corev1.ReadOnlyMany {
+			readOnly = true
+		}
+	}
+	if readOnly {
+		pod.readOnlyVolumes[volume.Name] = true
+	}
+
+	switch cloudProvider {
+	case "gcp":
+		return cloudDiskToLinuxKitMount(volume, gceDisk(&corev1.GCEPersistentDiskVolumeSource{PDName: source.ClaimName, ReadOnly: readOnly}), pod)
+	case "azure":
+		return cloudDiskToLinuxKitMount(volume, azureDisk(&corev1.AzureDiskVolumeSource{DiskName: source.ClaimName, ReadOnly: &readOnly}), pod)
+	case "aws":
+		log.Warnf("volume %s: EBS volumes can only be found by id, claim %s is kept on /var/lib instead", volume.Name, source.ClaimName)
+	}
+
+	storage, ok := claim.Spec.Resources.Requests[corev1.ResourceStorage]
+	if !ok {
+		return nil, fmt.Errorf("volume %s: claim %s needs a storage request to size it", volume.Name, source.ClaimName)
+	}
+
+	backing := fmt.Sprintf("/var/lib/volumes/.claims/%s.img", source.ClaimName)
+	return loopDiskToLinuxKitMount(pod, volume, backing, storage.Value(), true), nil
+}
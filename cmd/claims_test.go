@@ -0,0 +1,149 @@
+package main
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"testing"
+)
+
+func TestStatefulSetOrdinal(t *testing.T) {
+	statefulSet := &statefulSetSpec{
+		name:        "db",
+		replicas:    3,
+		serviceName: "db-headless",
+		volumeClaimTemplates: []corev1.PersistentVolumeClaim{
+			{ObjectMeta: metav1.ObjectMeta{Name: "data"}},
+			{ObjectMeta: metav1.ObjectMeta{Name: "logs"}},
+		},
+	}
+	template := corev1.PodTemplateSpec{
+		ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app": "db"}},
+		Spec: corev1.PodSpec{
+			Volumes: []corev1.Volume{
+				{Name: "data", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}},
+				{Name: "config", VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}},
+			},
+		},
+	}
+
+	for _, ordinal := range []int32{0, 2} {
+		pod, claims := statefulSetOrdinal(template, statefulSet, ordinal)
+		name := []string{"db-0", "", "db-2"}[ordinal]
+
+		if pod.Name != name || pod.Spec.Hostname != name || pod.Spec.Subdomain != "db-headless" {
+			t.Errorf("ordinal %d: got name %s, hostname %s and subdomain %s, want %s, %s and db-headless", ordinal, pod.Name, pod.Spec.Hostname, pod.Spec.Subdomain, name, name)
+		}
+		for key, value := range map[string]string{"app": "db", "statefulset.kubernetes.io/pod-name": name, "apps.kubernetes.io/pod-index": name[3:]} {
+			if pod.Labels[key] != value {
+				t.Errorf("ordinal %d: got label %s=%q, want %q", ordinal, key, pod.Labels[key], value)
+			}
+		}
+
+		if len(claims) != 2 || claims[0].Name != "data-"+name || claims[1].Name != "logs-"+name {
+			t.Errorf("ordinal %d: got claims %v, want data-%s and logs-%s", ordinal, claims, name, name)
+		}
+
+		// the claims replace the volumes of the same name, the others are kept
+		volumes := map[string]corev1.VolumeSource{}
+		for _, volume := range pod.Spec.Volumes {
+			volumes[volume.Name] = volume.VolumeSource
+		}
+		if len(volumes) != 3 || volumes["data"].PersistentVolumeClaim == nil || volumes["data"].PersistentVolumeClaim.ClaimName != "data-"+name ||
+			volumes["logs"].PersistentVolumeClaim == nil || volumes["config"].EmptyDir == nil {
+			t.Errorf("ordinal %d: got volumes %v", ordinal, pod.Spec.Volumes)
+		}
+	}
+
+	// the template itself is left alone
+	if template.Name != "" || len(template.Labels) != 1 || template.Spec.Volumes[0].EmptyDir == nil {
+		t.Errorf("the template was changed: %v", template)
+	}
+
+	// a hostname and subdomain of the pod's own are kept
+	template.Spec.Hostname = "primary"
+	template.Spec.Subdomain = "internal"
+	if pod, _ := statefulSetOrdinal(template, statefulSet, 1); pod.Spec.Hostname != "primary" || pod.Spec.Subdomain != "internal" {
+		t.Errorf("got hostname %s and subdomain %s, want primary and internal", pod.Spec.Hostname, pod.Spec.Subdomain)
+	}
+}
+
+func TestPersistentVolumeClaimToLinuxKitMount(t *testing.T) {
+	defer func(provider string) { cloudProvider = provider }(cloudProvider)
+
+	block := corev1.PersistentVolumeBlock
+	filesystem := corev1.PersistentVolumeFilesystem
+
+	for _, test := range []struct {
+		provider    string
+		mode        *corev1.PersistentVolumeMode
+		accessModes []corev1.PersistentVolumeAccessMode
+		readOnly    bool
+		devices     bool
+		// how many onboot steps there are, and whether the volume is read only
+		images         int
+		readOnlyVolume bool
+		err            bool
+	}{
+		{images: 1},
+		{mode: &filesystem, images: 1},
+		{mode: &block, devices: true, images: 1},
+		// a block claim nothing uses is left unattached
+		{mode: &block, images: 0},
+		{provider: "gcp", mode: &block, images: 0},
+		{devices: true, err: true},
+		{accessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadOnlyMany}, images: 1, readOnlyVolume: true},
+		{readOnly: true, images: 1, readOnlyVolume: true},
+		{provider: "gcp", images: 2},
+		{provider: "gcp", accessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadOnlyMany}, images: 2, readOnlyVolume: true},
+		{provider: "gcp", readOnly: true, images: 2, readOnlyVolume: true},
+		{provider: "azure", accessModes: []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce, corev1.ReadOnlyMany}, images: 2, readOnlyVolume: true},
+		{provider: "aws", images: 1},
+	} {
+		cloudProvider = test.provider
+
+		spec := corev1.PodSpec{Containers: []corev1.Container{{Name: "db"}}}
+		if test.devices {
+			spec.Containers[0].VolumeDevices = []corev1.VolumeDevice{{Name: "data", DevicePath: "/dev/xvdb"}}
+		}
+		claim := corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{Name: "data-db-0"},
+			Spec: corev1.PersistentVolumeClaimSpec{
+				VolumeMode:  test.mode,
+				AccessModes: test.accessModes,
+				Resources: corev1.ResourceRequirements{
+					Requests: corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("1Gi")},
+				},
+			},
+		}
+		pod := newPodContext(&corev1.PodTemplateSpec{Spec: spec}, nil, []corev1.PersistentVolumeClaim{claim})
+
+		images, err := persistentVolumeClaimToLinuxKitMount(pod, &corev1.Volume{
+			Name: "data",
+			VolumeSource: corev1.VolumeSource{
+				PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "data-db-0", ReadOnly: test.readOnly},
+			},
+		})
+		if test.err {
+			if err == nil {
+				t.Errorf("%+v: expected an error", test)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%+v: %v", test, err)
+			continue
+		}
+		if len(images) != test.images || pod.readOnlyVolumes["data"] != test.readOnlyVolume {
+			t.Errorf("%+v: got %d onboot steps and read only %v", test, len(images), pod.readOnlyVolumes["data"])
+		}
+	}
+
+	pod := newPodContext(&corev1.PodTemplateSpec{}, nil, nil)
+	if _, err := persistentVolumeClaimToLinuxKitMount(pod, &corev1.Volume{
+		Name:         "data",
+		VolumeSource: corev1.VolumeSource{PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "elsewhere"}},
+	}); err == nil {
+		t.Errorf("expected an error for a claim which isn't the StatefulSet's")
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"github.com/tjfontaine/podspec2linuxkit/pkg/cron"
+	"github.com/tjfontaine/podspec2linuxkit/pkg/linuxkit"
+	"time"
+)
+
+// SCHEDULER_IMAGE has podspec2linuxkit itself, and the time zone data it needs, see the Dockerfile
+var SCHEDULER_IMAGE = "tjfontaine/podspec2linuxkit:latest"
+
+// the history limits of a CronJob that doesn't set them
+const (
+	defaultSuccessfulJobsHistoryLimit = 3
+	defaultFailedJobsHistoryLimit     = 1
+)
+
+// cronSchedulerConfig is what the cron-scheduler service is told about the CronJob it runs
+type cronSchedulerConfig struct {
+	Name string `json:"name"`
+	// the containerd namespace the containers run in
+	Namespace                  string `json:"namespace,omitempty"`
+	Schedule                   string `json:"schedule"`
+	TimeZone                   string `json:"timeZone,omitempty"`
+	ConcurrencyPolicy          string `json:"concurrencyPolicy,omitempty"`
+	StartingDeadlineSeconds    *int64 `json:"startingDeadlineSeconds,omitempty"`
+	Suspend                    bool   `json:"suspend,omitempty"`
+	SuccessfulJobsHistoryLimit int32  `json:"successfulJobsHistoryLimit"`
+	FailedJobsHistoryLimit     int32  `json:"failedJobsHistoryLimit"`
+	BackoffLimit               int32  `json:"backoffLimit"`
+	ActiveDeadlineSeconds      *int64 `json:"activeDeadlineSeconds,omitempty"`
+	// the job template's containers, which are unpacked as onshutdown bundles of the same name
+	Containers []string `json:"containers"`
+}
+
+// parseSchedule parses the schedule the way the scheduler will, so mistakes are found when converting
+func (config *cronSchedulerConfig) parseSchedule() (*cron.Schedule, error) {
+	var location *time.Location
+	if config.TimeZone != "" {
+		var err error
+		if location, err = time.LoadLocation(config.TimeZone); err != nil {
+			return nil, fmt.Errorf("unknown timeZone %s", config.TimeZone)
+		}
+	}
+
+	return cron.ParseInLocation(config.Schedule, location)
+}
+
+func (config *cronSchedulerConfig) policy() cron.Policy {
+	policy := cron.Policy{
+		Concurrency: cron.ConcurrencyPolicy(config.ConcurrencyPolicy),
+		Suspend:     config.Suspend,
+	}
+
+	if config.StartingDeadlineSeconds != nil {
+		deadline := time.Duration(*config.StartingDeadlineSeconds) * time.Second
+		policy.StartingDeadline = &deadline
+	}
+
+	return policy
+}
+
+// cronJobToLinuxKit turns the converted job template into a CronJob: its containers are unpacked as onshutdown
+// bundles, which the scheduler service takes for itself at boot and runs through containerd on each tick
+func cronJobToLinuxKit(w *workload, namespace string, result *linuxkit.Moby) error {
+	config := cronSchedulerConfig{
+		Name:                       w.template.Name,
+		Namespace:                  namespace,
+		Schedule:                   w.cronJob.schedule,
+		TimeZone:                   w.cronJob.timeZone,
+		ConcurrencyPolicy:          w.cronJob.concurrencyPolicy,
+		StartingDeadlineSeconds:    w.cronJob.startingDeadlineSeconds,
+		Suspend:                    w.cronJob.suspend,
+		SuccessfulJobsHistoryLimit: defaultSuccessfulJobsHistoryLimit,
+		FailedJobsHistoryLimit:     defaultFailedJobsHistoryLimit,
+		BackoffLimit:               defaultBackoffLimit,
+		ActiveDeadlineSeconds:      w.job.ActiveDeadlineSeconds,
+	}
+
+	if w.cronJob.successfulJobsHistoryLimit != nil {
+		config.SuccessfulJobsHistoryLimit = *w.cronJob.successfulJobsHistoryLimit
+	}
+
+	if w.cronJob.failedJobsHistoryLimit != nil {
+		config.FailedJobsHistoryLimit = *w.cronJob.failedJobsHistoryLimit
+	}
+
+	if w.job.BackoffLimit != nil {
+		config.BackoffLimit = *w.job.BackoffLimit
+	}
+
+	switch cron.ConcurrencyPolicy(config.ConcurrencyPolicy) {
+	case "":
+		config.ConcurrencyPolicy = string(cron.AllowConcurrent)
+	case cron.AllowConcurrent, cron.ForbidConcurrent, cron.ReplaceConcurrent:
+	default:
+		return fmt.Errorf("CronJob %s: unknown concurrencyPolicy %s", config.Name, config.ConcurrencyPolicy)
+	}
+
+	if _, err := config.parseSchedule(); err != nil {
+		return fmt.Errorf("CronJob %s: %v", config.Name, err)
+	}
+
+	if len(w.template.Spec.InitContainers) > 0 {
+		log.Warnf("CronJob %s: init containers run once at boot, not before each job", config.Name)
+	}
+
+	if result.Onshutdown != nil {
+		return fmt.Errorf("CronJob %s: onshutdown is already in use", config.Name)
+	}
+
+	services := []*linuxkit.Image{}
+	if result.Services != nil {
+		services = *result.Services
+	}
+
+	for _, service := range services {
+		config.Containers = append(config.Containers, service.Name)
+	}
+
+	encoded, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+
+	result.Onshutdown = &services
+	result.Services = &[]*linuxkit.Image{
+		&linuxkit.Image{
+			Name:  fmt.Sprintf("cronjob-%s", config.Name),
+			Image: SCHEDULER_IMAGE,
+			ImageConfig: linuxkit.ImageConfig{
+				Command:      &[]string{"/usr/bin/podspec2linuxkit", "cron-scheduler", string(encoded)},
+				Capabilities: &[]string{"all"},
+				Binds:        &[]string{fmt.Sprintf("/:%s:rbind", hostRoot)},
+				Pid:          "host",
+			},
+		},
+	}
+
+	return nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"github.com/tjfontaine/podspec2linuxkit/pkg/linuxkit"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	corev1 "k8s.io/api/core/v1"
+	"text/template"
+)
+
+// csiDrivers maps a CSI driver name to the onboot image which mounts its volumes on the host, loaded from
+// --csi-drivers. Strings in command, binds and env are templates given a csiVolume.
+var csiDrivers = map[string]linuxkit.Image{}
+
+// csiVolume is what a CSI driver recipe can refer to
+type csiVolume struct {
+	// Name of the volume in the pod spec
+	Name string
+	// Target is where on the host the volume has to be mounted
+	Target     string
+	ReadOnly   bool
+	FSType     string
+	Attributes map[string]string
+}
+
+func loadCSIDrivers(file string) error {
+	contents, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	return yaml.UnmarshalStrict(contents, &csiDrivers)
+}
+
+func renderCSIStrings(driver string, strs *[]string, volume *csiVolume) (*[]string, error) {
+	if strs == nil {
+		return nil, nil
+	}
+
+	result := []string{}
+	for _, str := range *strs {
+		tmpl, err := template.New(driver).Option("missingkey=error").Parse(str)
+		if err != nil {
+			return nil, err
+		}
+
+		var rendered bytes.Buffer
+		if err := tmpl.Execute(&rendered, volume); err != nil {
+			return nil, err
+		}
+
+		result = append(result, rendered.String())
+	}
+
+	return &result, nil
+}
+
+// csiVolumeToLinuxKitMount mounts an inline CSI volume with the recipe configured for its driver
+func csiVolumeToLinuxKitMount(pod *podContext, volume *corev1.Volume, source map[string]interface{}) ([]*linuxkit.Image, error) {
+	driver, _ := source["driver"].(string)
+
+	recipe, ok := csiDrivers[driver]
+	if !ok {
+		return nil, fmt.Errorf("csi volume %s uses driver %q, which has no recipe in --csi-drivers", volume.Name, driver)
+	}
+
+	if _, ok := source["nodePublishSecretRef"]; ok {
+		log.Warnf("csi volume %s: nodePublishSecretRef not implemented", volume.Name)
+	}
+
+	csi := &csiVolume{
+		Name:       volume.Name,
+		Target:     fmt.Sprintf("/var/lib/volumes/%s", volume.Name),
+		Attributes: map[string]string{},
+	}
+	csi.ReadOnly, _ = source["readOnly"].(bool)
+	csi.FSType, _ = source["fsType"].(string)
+	if attributes, ok := source["volumeAttributes"].(map[string]interface{}); ok {
+		for key, value := range attributes {
+			csi.Attributes[key] = fmt.Sprint(value)
+		}
+	}
+
+	pod.volumeMap[volume.Name] = csi.Target
+	if csi.ReadOnly {
+		pod.readOnlyVolumes[volume.Name] = true
+	}
+
+	// a copy of the recipe, which is shared by every volume of the driver and whose fields are mostly pointers
+	data, err := yaml.Marshal(recipe)
+	if err != nil {
+		return nil, err
+	}
+	image := linuxkit.Image{}
+	if err := yaml.Unmarshal(data, &image); err != nil {
+		return nil, err
+	}
+	image.Name = fmt.Sprintf("mount-volume-%s", volume.Name)
+
+	if image.Command, err = renderCSIStrings(driver, recipe.Command, csi); err != nil {
+		return nil, fmt.Errorf("csi volume %s: %v", volume.Name, err)
+	}
+	if image.Binds, err = renderCSIStrings(driver, recipe.Binds, csi); err != nil {
+		return nil, fmt.Errorf("csi volume %s: %v", volume.Name, err)
+	}
+	if image.Env, err = renderCSIStrings(driver, recipe.Env, csi); err != nil {
+		return nil, fmt.Errorf("csi volume %s: %v", volume.Name, err)
+	}
+
+	// whatever the recipe does, the mount has to be made on the host and the target has to exist
+	if image.Binds == nil {
+		image.Binds = &[]string{"/dev:/dev", "/var:/var:rshared,rbind"}
+	}
+	if image.RootfsPropagation == nil {
+		propagation := "shared"
+		image.RootfsPropagation = &propagation
+	}
+	runtime := linuxkit.Runtime{}
+	if image.Runtime != nil {
+		runtime = *image.Runtime
+	}
+	mkdir := []string{csi.Target}
+	if runtime.Mkdir != nil {
+		mkdir = append(mkdir, *runtime.Mkdir...)
+	}
+	runtime.Mkdir = &mkdir
+	image.Runtime = &runtime
+
+	return []*linuxkit.Image{&image}, nil
+}
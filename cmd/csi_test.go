@@ -0,0 +1,127 @@
+package main
+
+import (
+	"github.com/tjfontaine/podspec2linuxkit/pkg/linuxkit"
+	corev1 "k8s.io/api/core/v1"
+	"strings"
+	"testing"
+)
+
+func TestRenderCSIStrings(t *testing.T) {
+	volume := &csiVolume{
+		Name:       "shared",
+		Target:     "/var/lib/volumes/shared",
+		ReadOnly:   true,
+		FSType:     "nfs4",
+		Attributes: map[string]string{"server": "nfs.internal", "share": "/exports/shared"},
+	}
+
+	for _, test := range []struct {
+		strs     []string
+		rendered string
+		err      bool
+	}{
+		{strs: []string{"mount", "-t", "{{ .FSType }}"}, rendered: "mount -t nfs4"},
+		{strs: []string{"nolock{{ if .ReadOnly }},ro{{ end }}", "{{ .Attributes.server }}:{{ .Attributes.share }}", "{{ .Target }}"}, rendered: "nolock,ro nfs.internal:/exports/shared /var/lib/volumes/shared"},
+		{strs: []string{"VOLUME={{ .Name }}"}, rendered: "VOLUME=shared"},
+		// an attribute the volume doesn't have
+		{strs: []string{"{{ .Attributes.path }}"}, err: true},
+		{strs: []string{"{{ .Size }}"}, err: true},
+		{strs: []string{"{{ .Target "}, err: true},
+	} {
+		strs := test.strs
+		rendered, err := renderCSIStrings("nfs.csi.k8s.io", &strs, volume)
+		if test.err {
+			if err == nil {
+				t.Errorf("%q: got %q, expected an error", test.strs, *rendered)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", test.strs, err)
+			continue
+		}
+		if strings.Join(*rendered, " ") != test.rendered {
+			t.Errorf("%q: got %q, want %q", test.strs, strings.Join(*rendered, " "), test.rendered)
+		}
+	}
+
+	if rendered, err := renderCSIStrings("nfs.csi.k8s.io", nil, volume); rendered != nil || err != nil {
+		t.Errorf("got %v (%v) for nothing to render", rendered, err)
+	}
+}
+
+func TestCSIVolumeToLinuxKitMount(t *testing.T) {
+	defer func() { csiDrivers = map[string]linuxkit.Image{} }()
+
+	if err := loadCSIDrivers("../examples/csi-drivers.yaml"); err != nil {
+		t.Fatal(err)
+	}
+	mkdir := []string{"/var/lib/iscsi"}
+	csiDrivers["iscsi.csi.k8s.io"] = linuxkit.Image{
+		Image: "linuxkit/open-iscsi:v0.6",
+		ImageConfig: linuxkit.ImageConfig{
+			Command:      &[]string{"/mount.sh", "{{ .Attributes.portal }}", "{{ .Target }}"},
+			Capabilities: &[]string{"all"},
+			Runtime:      &linuxkit.Runtime{Mkdir: &mkdir},
+		},
+	}
+
+	pod := newPodContext(&corev1.PodTemplateSpec{}, nil, nil)
+	mount := func(name string, source map[string]interface{}) *linuxkit.Image {
+		images, err := csiVolumeToLinuxKitMount(pod, &corev1.Volume{Name: name}, source)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if len(images) != 1 {
+			t.Fatalf("%s: got %d images", name, len(images))
+		}
+		return images[0]
+	}
+
+	shared := mount("shared", map[string]interface{}{
+		"driver":           "nfs.csi.k8s.io",
+		"readOnly":         true,
+		"volumeAttributes": map[string]interface{}{"server": "nfs.internal", "share": "/exports/shared"},
+	})
+	if shared.Name != "mount-volume-shared" || strings.Join(*shared.Command, " ") != "mount -t nfs -o nolock,ro nfs.internal:/exports/shared /var/lib/volumes/shared" {
+		t.Errorf("got %s running %v", shared.Name, *shared.Command)
+	}
+	// the recipe has no binds, so the volume is mounted where the host and the containers see it
+	if shared.Binds == nil || strings.Join(*shared.Binds, " ") != "/dev:/dev /var:/var:rshared,rbind" || *shared.RootfsPropagation != "shared" ||
+		strings.Join(*shared.Runtime.Mkdir, " ") != "/var/lib/volumes/shared" {
+		t.Errorf("got binds %v, propagation %v and runtime %+v", shared.Binds, shared.RootfsPropagation, shared.Runtime)
+	}
+	if pod.volumeMap["shared"] != "/var/lib/volumes/shared" || !pod.readOnlyVolumes["shared"] {
+		t.Errorf("got volume %q, read only %t", pod.volumeMap["shared"], pod.readOnlyVolumes["shared"])
+	}
+
+	// volumes of the same driver don't share anything of its recipe, or each other's
+	first := mount("first", map[string]interface{}{"driver": "iscsi.csi.k8s.io", "volumeAttributes": map[string]interface{}{"portal": "10.0.0.1:3260"}})
+	(*first.Capabilities)[0] = "CAP_SYS_ADMIN"
+	*first.RootfsPropagation = "private"
+	second := mount("second", map[string]interface{}{"driver": "iscsi.csi.k8s.io", "volumeAttributes": map[string]interface{}{"portal": "10.0.0.2:3260"}})
+	if strings.Join(*second.Command, " ") != "/mount.sh 10.0.0.2:3260 /var/lib/volumes/second" || strings.Join(*first.Command, " ") != "/mount.sh 10.0.0.1:3260 /var/lib/volumes/first" {
+		t.Errorf("got commands %v and %v", *first.Command, *second.Command)
+	}
+	if (*second.Capabilities)[0] != "all" || (*csiDrivers["iscsi.csi.k8s.io"].Capabilities)[0] != "all" || *second.RootfsPropagation != "shared" {
+		t.Errorf("got capabilities %v and propagation %s, changed through another volume", *second.Capabilities, *second.RootfsPropagation)
+	}
+	if strings.Join(*second.Runtime.Mkdir, " ") != "/var/lib/volumes/second /var/lib/iscsi" || len(mkdir) != 1 {
+		t.Errorf("got mkdir %v, and %v in the recipe", *second.Runtime.Mkdir, mkdir)
+	}
+	if pod.readOnlyVolumes["second"] {
+		t.Errorf("second is read only")
+	}
+
+	for _, source := range []map[string]interface{}{
+		{"driver": "ebs.csi.aws.com"},
+		{},
+		// the recipe's template needs attributes the volume doesn't have
+		{"driver": "nfs.csi.k8s.io", "volumeAttributes": map[string]interface{}{"server": "nfs.internal"}},
+	} {
+		if _, err := csiVolumeToLinuxKitMount(pod, &corev1.Volume{Name: "broken"}, source); err == nil {
+			t.Errorf("%v: expected an error", source)
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"github.com/tjfontaine/podspec2linuxkit/pkg/linuxkit"
+	corev1 "k8s.io/api/core/v1"
+	"path"
+	"regexp"
+	"strconv"
+)
+
+// blockDevice is how a volume used through volumeDevices shows up on the host
+type blockDevice struct {
+	path string
+	// the device cgroup rules which let a container use it
+	rules []linuxkit.LinuxDeviceCgroup
+}
+
+func deviceRule(major int64, minor *int64) linuxkit.LinuxDeviceCgroup {
+	rule := linuxkit.LinuxDeviceCgroup{
+		Allow:  true,
+		Type:   "b",
+		Access: "rw",
+	}
+
+	if major >= 0 {
+		rule.Major = &major
+	}
+
+	rule.Minor = minor
+
+	return rule
+}
+
+// disks whose device numbers follow from their name, with the major and how many minors each disk gets
+var staticDiskNames = []struct {
+	pattern *regexp.Regexp
+	major   int64
+	minors  int64
+}{
+	{regexp.MustCompile(`^sd([a-p])([0-9]*)$`), 8, 16},
+	{regexp.MustCompile(`^xvd([a-z])([0-9]*)$`), 202, 16},
+}
+
+var loopName = regexp.MustCompile(`^loop([0-9]+)$`)
+
+var nvmeName = regexp.MustCompile(`^nvme[0-9]+n[0-9]+(p[0-9]+)?$`)
+
+// deviceRulesForPath returns the rules which allow the block device at devicePath, as precisely as its name allows
+func deviceRulesForPath(devicePath string) []linuxkit.LinuxDeviceCgroup {
+	name := path.Base(devicePath)
+
+	for _, disk := range staticDiskNames {
+		if match := disk.pattern.FindStringSubmatch(name); match != nil {
+			minor := int64(match[1][0]-'a') * disk.minors
+			if match[2] != "" {
+				partition, _ := strconv.ParseInt(match[2], 10, 64)
+				minor += partition
+			}
+			return []linuxkit.LinuxDeviceCgroup{deviceRule(disk.major, &minor)}
+		}
+	}
+
+	if match := loopName.FindStringSubmatch(name); match != nil {
+		minor, _ := strconv.ParseInt(match[1], 10, 64)
+		return []linuxkit.LinuxDeviceCgroup{deviceRule(7, &minor)}
+	}
+
+	// NVMe namespaces and partitions are always blkext, but with dynamic minors
+	if nvmeName.MatchString(name) {
+		return []linuxkit.LinuxDeviceCgroup{deviceRule(259, nil)}
+	}
+
+	log.Warnf("device numbers of %s are only known at boot, allowing access to all block devices", devicePath)
+	return []linuxkit.LinuxDeviceCgroup{deviceRule(-1, nil)}
+}
+
+// the block device majors each provider's disks can show up with
+var cloudDiskMajors = map[string][]int64{
+	// NVMe on nitro, xen block devices before that
+	"aws":   []int64{259, 202},
+	"gcp":   []int64{8, 259},
+	"azure": []int64{8},
+}
+
+func cloudDiskRules(provider string) []linuxkit.LinuxDeviceCgroup {
+	rules := []linuxkit.LinuxDeviceCgroup{}
+	for _, major := range cloudDiskMajors[provider] {
+		rules = append(rules, deviceRule(major, nil))
+	}
+	return rules
+}
+
+// volumeDevicesToLinuxKit binds the block devices a container asked for at their devicePath, and returns the device
+// cgroup rules that let the container use just those devices
+func volumeDevicesToLinuxKit(pod *podContext, container *corev1.Container) ([]string, []linuxkit.LinuxDeviceCgroup, error) {
+	binds := []string{}
+	rules := []linuxkit.LinuxDeviceCgroup{
+		// like the runtime-spec default, deny everything not explicitly allowed
+		{Allow: false, Access: "rwm"},
+	}
+
+	for _, volumeDevice := range container.VolumeDevices {
+		device, ok := pod.devices[volumeDevice.Name]
+		if !ok {
+			return nil, nil, fmt.Errorf("volume device %s of container %s isn't a block device volume", volumeDevice.Name, container.Name)
+		}
+
+		if !path.IsAbs(volumeDevice.DevicePath) {
+			return nil, nil, fmt.Errorf("volume device %s of container %s needs an absolute devicePath", volumeDevice.Name, container.Name)
+		}
+
+		binds = append(binds, fmt.Sprintf("%s:%s", device.path, volumeDevice.DevicePath))
+		rules = append(rules, device.rules...)
+	}
+
+	return binds, rules, nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"github.com/tjfontaine/podspec2linuxkit/pkg/linuxkit"
+	corev1 "k8s.io/api/core/v1"
+	"testing"
+)
+
+func formatDeviceRule(rule linuxkit.LinuxDeviceCgroup) string {
+	number := func(n *int64) string {
+		if n == nil {
+			return "*"
+		}
+		return fmt.Sprint(*n)
+	}
+	return fmt.Sprintf("%v %s %s:%s %s", rule.Allow, rule.Type, number(rule.Major), number(rule.Minor), rule.Access)
+}
+
+func TestDeviceRulesForPath(t *testing.T) {
+	for _, test := range []struct {
+		path string
+		rule string
+	}{
+		{"/dev/sda", "true b 8:0 rw"},
+		{"/dev/sdb", "true b 8:16 rw"},
+		{"/dev/sdb2", "true b 8:18 rw"},
+		{"/dev/sdp15", "true b 8:255 rw"},
+		{"/dev/xvdf", "true b 202:80 rw"},
+		{"/dev/xvda1", "true b 202:1 rw"},
+		{"/dev/loop3", "true b 7:3 rw"},
+		{"/dev/nvme0n1", "true b 259:* rw"},
+		{"/dev/nvme1n1p2", "true b 259:* rw"},
+		// sdq and later have dynamic majors
+		{"/dev/sdq", "true b *:* rw"},
+		{"/dev/disk/by-id/google-data", "true b *:* rw"},
+		{"/dev/mapper/vg-data", "true b *:* rw"},
+	} {
+		rules := deviceRulesForPath(test.path)
+		if len(rules) != 1 || formatDeviceRule(rules[0]) != test.rule {
+			got := []string{}
+			for _, rule := range rules {
+				got = append(got, formatDeviceRule(rule))
+			}
+			t.Errorf("%s: got %v, want %s", test.path, got, test.rule)
+		}
+	}
+}
+
+func TestVolumeDevicesToLinuxKit(t *testing.T) {
+	pod := newPodContext(&corev1.PodTemplateSpec{}, nil, nil)
+	pod.devices["data"] = &blockDevice{path: "/dev/disk/by-volume/data", rules: cloudDiskRules("aws")}
+
+	binds, rules, err := volumeDevicesToLinuxKit(pod, &corev1.Container{
+		Name:          "db",
+		VolumeDevices: []corev1.VolumeDevice{{Name: "data", DevicePath: "/dev/xvdz"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(binds) != 1 || binds[0] != "/dev/disk/by-volume/data:/dev/xvdz" {
+		t.Errorf("got binds %v, want /dev/disk/by-volume/data:/dev/xvdz", binds)
+	}
+	want := []string{"false  *:* rwm", "true b 259:* rw", "true b 202:* rw"}
+	if len(rules) != len(want) {
+		t.Fatalf("got %d rules, want %d", len(rules), len(want))
+	}
+	for idx, rule := range rules {
+		if formatDeviceRule(rule) != want[idx] {
+			t.Errorf("rule %d: got %s, want %s", idx, formatDeviceRule(rule), want[idx])
+		}
+	}
+
+	for _, device := range []corev1.VolumeDevice{{Name: "logs", DevicePath: "/dev/xvdz"}, {Name: "data", DevicePath: "xvdz"}} {
+		if _, _, err := volumeDevicesToLinuxKit(pod, &corev1.Container{Name: "db", VolumeDevices: []corev1.VolumeDevice{device}}); err == nil {
+			t.Errorf("%s at %s: expected an error", device.Name, device.DevicePath)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"fmt"
+	"github.com/tjfontaine/podspec2linuxkit/pkg/linuxkit"
+	corev1 "k8s.io/api/core/v1"
+	"strings"
+)
+
+// cloudProvider is set by --provider and decides how cloud disk volumes are found on the instance
+var cloudProvider string
+
+// DISK_HELPER_IMAGE needs a shell, blkid, mount, losetup and the mkfs.* tools for the filesystems we're asked to
+// create, which the linuxkit package for formatting disks has for ext4, xfs and btrfs, at the release the templates use
+var DISK_HELPER_IMAGE = "linuxkit/format:v0.6"
+
+// how long (in seconds) to wait for an attached disk to show up before failing boot
+const diskWaitSeconds = 300
+
+// cloudDisk is the provider agnostic description of a disk volume source
+type cloudDisk struct {
+	provider  string
+	fsType    string
+	partition int32
+	readOnly  bool
+	// locate is a shell snippet which sets $device to the disk once it has been attached
+	locate string
+}
+
+func awsDisk(source *corev1.AWSElasticBlockStoreVolumeSource) *cloudDisk {
+	// volumeID is either vol-xxx or aws://<zone>/vol-xxx
+	volumeID := source.VolumeID[strings.LastIndex(source.VolumeID, "/")+1:]
+
+	// Nitro instances expose EBS as NVMe, with the volume id (sans dash) as the controller serial
+	serial := strings.Replace(volumeID, "-", "", 1)
+
+	return &cloudDisk{
+		provider:  "aws",
+		fsType:    source.FSType,
+		partition: source.Partition,
+		readOnly:  source.ReadOnly,
+		locate: fmt.Sprintf(`for d in /sys/block/nvme*; do
+  [ "$(cat $d/device/serial 2>/dev/null | tr -d ' ')" = %q ] && device=/dev/${d##*/}
+done
+[ -z "$device" ] && [ -e /dev/disk/by-id/nvme-Amazon_Elastic_Block_Store_%s ] && device=/dev/disk/by-id/nvme-Amazon_Elastic_Block_Store_%s`, serial, serial, serial),
+	}
+}
+
+func gceDisk(source *corev1.GCEPersistentDiskVolumeSource) *cloudDisk {
+	// without udev there is no by-id symlink, but the device name is the SCSI unit serial (VPD page 0x80)
+	return &cloudDisk{
+		provider:  "gcp",
+		fsType:    source.FSType,
+		partition: source.Partition,
+		readOnly:  source.ReadOnly,
+		locate: fmt.Sprintf(`if [ -e /dev/disk/by-id/google-%s ]; then device=/dev/disk/by-id/google-%s; else
+  for d in /sys/block/sd*; do
+    [ "$(tail -c +5 $d/device/vpd_pg80 2>/dev/null | tr -d '\0 ')" = %q ] && device=/dev/${d##*/}
+  done
+fi`, source.PDName, source.PDName, source.PDName),
+	}
+}
+
+func azureDisk(source *corev1.AzureDiskVolumeSource) *cloudDisk {
+	disk := &cloudDisk{
+		provider: "azure",
+		// ask the instance metadata service which LUN the disk was attached at, then find that LUN on a
+		// SCSI host other than the one holding the OS disk
+		locate: fmt.Sprintf(`imds=http://169.254.169.254/metadata/instance/compute/storageProfile/dataDisks
+i=0
+while name=$(wget -q -O - --header Metadata:true "$imds/$i/name?api-version=2019-06-01&format=text"); do
+  if [ "$name" = %q ]; then
+    lun=$(wget -q -O - --header Metadata:true "$imds/$i/lun?api-version=2019-06-01&format=text")
+    break
+  fi
+  i=$((i+1))
+done
+if [ -n "$lun" ]; then
+  if [ -e /dev/disk/azure/scsi1/lun$lun ]; then device=/dev/disk/azure/scsi1/lun$lun; else
+    oshost=$(ls -d /sys/block/sda/device/scsi_disk/* 2>/dev/null | sed 's,.*/,,; s,:.*,,')
+    for d in /sys/class/scsi_disk/*:0:0:$lun; do
+      [ "$(basename $d | cut -d: -f1)" = "$oshost" ] && continue
+      [ -d $d/device/block ] && device=/dev/$(ls $d/device/block)
+    done
+  fi
+fi`, source.DiskName),
+	}
+
+	if source.FSType != nil {
+		disk.fsType = *source.FSType
+	}
+
+	if source.ReadOnly != nil {
+		disk.readOnly = *source.ReadOnly
+	}
+
+	return disk
+}
+
+// cloudDiskToLinuxKitMount returns the onboot steps which wait for the disk to be attached, format it if it has
+// no filesystem yet, and mount it where the containers will bind it from
+func cloudDiskToLinuxKitMount(volume *corev1.Volume, disk *cloudDisk, pod *podContext) ([]*linuxkit.Image, error) {
+	if cloudProvider != disk.provider {
+		return nil, fmt.Errorf("volume %s needs --provider %s, not %q", volume.Name, disk.provider, cloudProvider)
+	}
+
+	fsType := disk.fsType
+	if fsType == "" {
+		fsType = "ext4"
+	}
+
+	// the located device is published as a symlink so the mount step doesn't need to know how it was found
+	link := fmt.Sprintf("/dev/disk/by-volume/%s", volume.Name)
+
+	locate := fmt.Sprintf(`device=
+i=0
+while [ $i -lt %d ]; do
+%s
+  [ -n "$device" ] && [ -b "$device" ] && break
+  device=
+  sleep 1
+  i=$((i+1))
+done
+if [ -z "$device" ]; then
+  echo "%s disk for volume %s was not attached" >&2
+  exit 1
+fi
+device=$(readlink -f $device)
+`, diskWaitSeconds, indent(disk.locate, "  "), disk.provider, volume.Name)
+
+	if disk.partition > 0 {
+		// nvme0n1 -> nvme0n1p1, sdb -> sdb1
+		locate += fmt.Sprintf(`case "$device" in
+  *[0-9]) device=${device}p%d ;;
+  *) device=${device}%d ;;
+esac
+`, disk.partition, disk.partition)
+	}
+
+	locate += fmt.Sprintf("mkdir -p %s\nln -sf $device %s\n", link[:strings.LastIndex(link, "/")], link)
+
+	locateImage := &linuxkit.Image{
+		Name:  fmt.Sprintf("locate-volume-%s", volume.Name),
+		Image: "busybox:latest",
+		ImageConfig: linuxkit.ImageConfig{
+			Command:      &[]string{"/bin/sh", "-c", locate},
+			Capabilities: &[]string{"all"},
+			Binds:        &[]string{"/dev:/dev", "/sys:/sys"},
+			// azure needs to reach the instance metadata service
+			Net: "host",
+		},
+	}
+
+	if pod.blockVolumes[volume.Name] {
+		// containers get the raw device, so there is nothing to format or mount
+		pod.devices[volume.Name] = &blockDevice{path: link, rules: cloudDiskRules(disk.provider)}
+		return []*linuxkit.Image{locateImage}, nil
+	}
+
+	path := fmt.Sprintf("/var/lib/volumes/%s", volume.Name)
+	pod.volumeMap[volume.Name] = path
+
+	mountOpts := "rw"
+	format := fmt.Sprintf(`if ! blkid %s >/dev/null 2>&1; then
+  mkfs.%s %s
+fi
+`, link, fsType, link)
+	if disk.readOnly {
+		// never format a disk we were told is read only
+		mountOpts = "ro"
+		format = ""
+	}
+
+	mount := fmt.Sprintf(`set -e
+%smkdir -p %s
+mount -t %s -o %s %s %s
+`, format, path, fsType, mountOpts, link, path)
+
+	propagation := "shared"
+
+	return []*linuxkit.Image{
+		locateImage,
+		&linuxkit.Image{
+			Name:  fmt.Sprintf("mount-volume-%s", volume.Name),
+			Image: DISK_HELPER_IMAGE,
+			ImageConfig: linuxkit.ImageConfig{
+				Command:           &[]string{"/bin/sh", "-c", mount},
+				Capabilities:      &[]string{"all"},
+				Binds:             &[]string{"/dev:/dev", "/var:/var:rshared,rbind"},
+				RootfsPropagation: &propagation,
+			},
+		},
+	}, nil
+}
+
+func indent(s string, prefix string) string {
+	return prefix + strings.Replace(s, "\n", "\n"+prefix, -1)
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"io/ioutil"
+	corev1 "k8s.io/api/core/v1"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestCloudDisks(t *testing.T) {
+	ext4 := "ext4"
+	readOnly := true
+
+	for _, test := range []struct {
+		name string
+		disk *cloudDisk
+		// what the provider is, and what the disk's source said
+		provider  string
+		fsType    string
+		partition int32
+		readOnly  bool
+		// the sysfs and /dev the locate snippet looks at, and the device it finds, if run
+		files  map[string]string
+		device string
+	}{
+		{
+			name:     "aws, by the NVMe controller serial",
+			disk:     awsDisk(&corev1.AWSElasticBlockStoreVolumeSource{VolumeID: "aws://us-east-1a/vol-0123456789abcdef0", FSType: "xfs", Partition: 1}),
+			provider: "aws", fsType: "xfs", partition: 1,
+			files:  map[string]string{"sys/block/nvme0n1/device/serial": "vol0aaaaaaaaaaaaaaaa0 ", "sys/block/nvme1n1/device/serial": "vol0123456789abcdef0 "},
+			device: "/dev/nvme1n1",
+		},
+		{
+			name:     "aws, by the udev symlink",
+			disk:     awsDisk(&corev1.AWSElasticBlockStoreVolumeSource{VolumeID: "vol-0123456789abcdef0", ReadOnly: true}),
+			provider: "aws", readOnly: true,
+			files:  map[string]string{"dev/disk/by-id/nvme-Amazon_Elastic_Block_Store_vol0123456789abcdef0": ""},
+			device: "/dev/disk/by-id/nvme-Amazon_Elastic_Block_Store_vol0123456789abcdef0",
+		},
+		{
+			name:     "aws, not attached",
+			disk:     awsDisk(&corev1.AWSElasticBlockStoreVolumeSource{VolumeID: "vol-0123456789abcdef0"}),
+			provider: "aws",
+			files:    map[string]string{"sys/block/nvme0n1/device/serial": "vol0aaaaaaaaaaaaaaaa0"},
+		},
+		{
+			name:     "gcp, by the SCSI unit serial",
+			disk:     gceDisk(&corev1.GCEPersistentDiskVolumeSource{PDName: "data", FSType: "ext4", Partition: 2}),
+			provider: "gcp", fsType: "ext4", partition: 2,
+			files:  map[string]string{"sys/block/sda/device/vpd_pg80": "\x00\x80\x00\x0cpersistent-disk-0", "sys/block/sdb/device/vpd_pg80": "\x00\x80\x00\x04data"},
+			device: "/dev/sdb",
+		},
+		{
+			name:     "gcp, by the udev symlink",
+			disk:     gceDisk(&corev1.GCEPersistentDiskVolumeSource{PDName: "data", ReadOnly: true}),
+			provider: "gcp", readOnly: true,
+			files:  map[string]string{"dev/disk/by-id/google-data": ""},
+			device: "/dev/disk/by-id/google-data",
+		},
+		{
+			name:     "azure",
+			disk:     azureDisk(&corev1.AzureDiskVolumeSource{DiskName: "data", FSType: &ext4, ReadOnly: &readOnly}),
+			provider: "azure", fsType: "ext4", readOnly: true,
+		},
+		{
+			name:     "azure, without fsType and readOnly",
+			disk:     azureDisk(&corev1.AzureDiskVolumeSource{DiskName: "data"}),
+			provider: "azure",
+		},
+	} {
+		disk := test.disk
+		if disk.provider != test.provider || disk.fsType != test.fsType || disk.partition != test.partition || disk.readOnly != test.readOnly {
+			t.Errorf("%s: got %+v", test.name, disk)
+			continue
+		}
+
+		if test.files == nil {
+			// the instance metadata service is asked for the disk's LUN
+			if !strings.Contains(disk.locate, `[ "$name" = "data" ]`) || !strings.Contains(disk.locate, "/dev/disk/azure/scsi1/lun$lun") {
+				t.Errorf("%s: got locate:\n%s", test.name, disk.locate)
+			}
+			continue
+		}
+
+		root, err := ioutil.TempDir("", "disks")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(root)
+		for file, contents := range test.files {
+			os.MkdirAll(path.Dir(path.Join(root, file)), 0755)
+			if err := ioutil.WriteFile(path.Join(root, file), []byte(contents), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// everything but /dev/null is looked for in the fake root
+		locate := strings.NewReplacer("/dev/null", "/dev/null", "/sys/", root+"/sys/", "/dev/", root+"/dev/").Replace(disk.locate)
+		out, err := exec.Command("/bin/sh", "-c", "device=\n"+locate+"\necho \"$device\"").CombinedOutput()
+		device := strings.TrimPrefix(strings.TrimSpace(string(out)), root)
+		if err != nil && test.device != "" || device != test.device {
+			t.Errorf("%s: located %q (%v), want %q", test.name, device, err, test.device)
+		}
+	}
+}
+
+func TestCloudDiskToLinuxKitMount(t *testing.T) {
+	defer func(provider string) { cloudProvider = provider }(cloudProvider)
+
+	for _, test := range []struct {
+		name     string
+		provider string
+		disk     *cloudDisk
+		block    bool
+		// partition suffixes of the devices the disk could be located as
+		partitions map[string]string
+		// what the mount step runs, and doesn't, if there is one
+		mount, skips []string
+		err          string
+	}{
+		{
+			name:     "formats a disk without a filesystem, as ext4 by default",
+			provider: "aws",
+			disk:     &cloudDisk{provider: "aws"},
+			mount:    []string{"if ! blkid /dev/disk/by-volume/data >/dev/null 2>&1; then\n  mkfs.ext4 /dev/disk/by-volume/data\nfi", "mount -t ext4 -o rw /dev/disk/by-volume/data /var/lib/volumes/data"},
+		},
+		{
+			name:     "fsType",
+			provider: "gcp",
+			disk:     &cloudDisk{provider: "gcp", fsType: "xfs"},
+			mount:    []string{"mkfs.xfs /dev/disk/by-volume/data", "mount -t xfs -o rw /dev/disk/by-volume/data /var/lib/volumes/data"},
+		},
+		{
+			name:     "readOnly is never formatted",
+			provider: "azure",
+			disk:     &cloudDisk{provider: "azure", fsType: "btrfs", readOnly: true},
+			mount:    []string{"mount -t btrfs -o ro /dev/disk/by-volume/data /var/lib/volumes/data"},
+			skips:    []string{"blkid", "mkfs"},
+		},
+		{
+			name:       "partition",
+			provider:   "aws",
+			disk:       &cloudDisk{provider: "aws", partition: 2},
+			partitions: map[string]string{"/dev/nvme1n1": "/dev/nvme1n1p2", "/dev/sdb": "/dev/sdb2", "/dev/xvdf": "/dev/xvdf2"},
+			mount:      []string{"mount -t ext4 -o rw /dev/disk/by-volume/data /var/lib/volumes/data"},
+		},
+		{
+			name:     "a block volume is neither formatted nor mounted",
+			provider: "gcp",
+			disk:     &cloudDisk{provider: "gcp"},
+			block:    true,
+		},
+		{
+			name:     "another provider's disk",
+			provider: "gcp",
+			disk:     &cloudDisk{provider: "aws"},
+			err:      `volume data needs --provider aws, not "gcp"`,
+		},
+		{
+			name:     "no provider",
+			provider: "",
+			disk:     &cloudDisk{provider: "azure"},
+			err:      `volume data needs --provider azure, not ""`,
+		},
+	} {
+		cloudProvider = test.provider
+		template := &corev1.PodTemplateSpec{}
+		if test.block {
+			template.Spec.Containers = []corev1.Container{{Name: "db", VolumeDevices: []corev1.VolumeDevice{{Name: "data", DevicePath: "/dev/xvdz"}}}}
+		}
+		pod := newPodContext(template, nil, nil)
+
+		images, err := cloudDiskToLinuxKitMount(&corev1.Volume{Name: "data"}, test.disk, pod)
+		if test.err != "" {
+			if err == nil || err.Error() != test.err {
+				t.Errorf("%s: got %v, want %s", test.name, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+
+		locate := (*images[0].Command)[2]
+		if images[0].Name != "locate-volume-data" || !strings.Contains(locate, "ln -sf $device /dev/disk/by-volume/data") {
+			t.Errorf("%s: got %s running:\n%s", test.name, images[0].Name, locate)
+		}
+
+		start := strings.Index(locate, `case "$device" in`)
+		if (start >= 0) != (test.partitions != nil) {
+			t.Errorf("%s: got locate:\n%s", test.name, locate)
+		}
+		for device, partition := range test.partitions {
+			suffix := locate[start : strings.Index(locate, "esac\n")+len("esac\n")]
+			out, err := exec.Command("/bin/sh", "-c", "device="+device+"\n"+suffix+"echo $device").CombinedOutput()
+			if err != nil || strings.TrimSpace(string(out)) != partition {
+				t.Errorf("%s: got %s (%v) for %s, want %s", test.name, out, err, device, partition)
+			}
+		}
+
+		if test.block {
+			if len(images) != 1 || pod.devices["data"] == nil || pod.devices["data"].path != "/dev/disk/by-volume/data" || pod.volumeMap["data"] != "" {
+				t.Errorf("%s: got %d images, device %v and volume %q", test.name, len(images), pod.devices["data"], pod.volumeMap["data"])
+			}
+			continue
+		}
+
+		if len(images) != 2 || images[1].Name != "mount-volume-data" || images[1].Image != DISK_HELPER_IMAGE || pod.volumeMap["data"] != "/var/lib/volumes/data" {
+			t.Errorf("%s: got %d images and volume %q", test.name, len(images), pod.volumeMap["data"])
+			continue
+		}
+		mount := (*images[1].Command)[2]
+		for _, want := range test.mount {
+			if !strings.Contains(mount, want) {
+				t.Errorf("%s: got mount:\n%s\nwant %q", test.name, mount, want)
+			}
+		}
+		for _, unwanted := range test.skips {
+			if strings.Contains(mount, unwanted) {
+				t.Errorf("%s: got mount:\n%s\ndidn't want %q", test.name, mount, unwanted)
+			}
+		}
+	}
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"github.com/tjfontaine/podspec2linuxkit/pkg/linuxkit"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/types"
+	"math"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// where generated files are placed in the image
+const generatedFilesDir = "etc/podspec2linuxkit"
+
+// the entrypoint shim is a static busybox copied out at boot, and a script which resolves the fields of the
+// downward API that only exist on a running VM before exec'ing the container's real command
+const (
+	entrypointShimHostDir = "/run/podspec2linuxkit"
+	entrypointShimDir     = "/.podspec2linuxkit"
+)
+
+// runtimeFieldFunction defines field, which prints the value of a field of the downward API only known on a running
+// VM, running busybox's applets through $bb when it's set
+var runtimeFieldFunction = `field() {
+  case "$1" in
+    status.podIP|status.podIPs|status.hostIP)
+      $bb ip -4 route get 1.1.1.1 | $bb sed -n 's/.* src \([0-9.]*\).*/\1/p' ;;
+    spec.nodeName)
+      $bb hostname ;;
+    node.cpu:*)
+      divisor=${1#*:}
+      echo $(( ($($bb nproc) * 1000 + divisor - 1) / divisor )) ;;
+    node.memory:*)
+      divisor=${1#*:}
+      kb=$($bb awk '/^MemTotal:/ { print $2 }' /proc/meminfo)
+      echo $(( (kb * 1024 + divisor - 1) / divisor )) ;;
+  esac
+}
+`
+
+var entrypointShimScript = `#!/bin/sh
+# usage: entrypoint.sh NAME=field... -- command...
+bb=` + entrypointShimDir + `/busybox
+` + runtimeFieldFunction + `while [ $# -gt 0 ]; do
+  name=${1%%=*}
+  field=${1#*=}
+  case "$field" in
+    --) ;;
+    *) export "$name=$(field "$field")" ;;
+  esac
+  shift
+  [ "$field" = "--" ] && break
+done
+exec "$@"
+`
+
+func entrypointShimCommand() []string {
+	return []string{entrypointShimDir + "/busybox", "sh", entrypointShimDir + "/entrypoint.sh"}
+}
+
+func entrypointShimBinds() []string {
+	return []string{
+		fmt.Sprintf("%s/busybox:%s/busybox:ro", entrypointShimHostDir, entrypointShimDir),
+		fmt.Sprintf("/%s/entrypoint.sh:%s/entrypoint.sh:ro", generatedFilesDir, entrypointShimDir),
+	}
+}
+
+func entrypointShimImage() *linuxkit.Image {
+	return &linuxkit.Image{
+		Name:  "entrypoint-shim",
+		Image: "busybox:latest",
+		ImageConfig: linuxkit.ImageConfig{
+			Command: &[]string{"cp", "/bin/busybox", "/shim/busybox"},
+			Binds:   &[]string{fmt.Sprintf("%s:/shim", entrypointShimHostDir)},
+			Runtime: &linuxkit.Runtime{
+				Mkdir: &[]string{entrypointShimHostDir},
+			},
+		},
+	}
+}
+
+func entrypointShimFile() linuxkit.File {
+	return linuxkit.File{
+		Path:     path.Join(generatedFilesDir, "entrypoint.sh"),
+		Contents: &entrypointShimScript,
+		Mode:     "0755",
+	}
+}
+
+func (pod *podContext) useEntrypointShim() {
+	pod.entrypointShim = true
+}
+
+// a UUID namespace of podspec2linuxkit's own, which the UIDs of pods are derived in
+var podUIDNamespace = [16]byte{0x5c, 0x3e, 0x1f, 0x0a, 0x6b, 0x2d, 0x4e, 0x8f, 0x9a, 0x41, 0xd2, 0x07, 0xc8, 0x5b, 0x13, 0x6e}
+
+// podUID derives a UID, like the API server gives a pod when it's created, for pods which don't have one: a name
+// based (version 5) UUID of what identifies the pod, its kind, namespace, name and the prefix it is merged with, so
+// converting the same manifest always gives the same UID and different pods different ones
+func podUID(identity ...string) types.UID {
+	hash := sha1.New()
+	hash.Write(podUIDNamespace[:])
+	hash.Write([]byte(strings.Join(identity, "/")))
+	b := hash.Sum(nil)[:16]
+	// version 5, variant 10
+	b[6] = b[6]&0x0f | 0x50
+	b[8] = b[8]&0x3f | 0x80
+	return types.UID(fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]))
+}
+
+var fieldSubscript = regexp.MustCompile(`^(metadata\.(?:labels|annotations))\['(.+)'\]$`)
+
+// formatMap renders labels and annotations the way the kubelet does for downwardAPI volumes
+func formatMap(m map[string]string) string {
+	keys := []string{}
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var result strings.Builder
+	for _, key := range keys {
+		fmt.Fprintf(&result, "%s=%q\n", key, m[key])
+	}
+
+	return result.String()
+}
+
+// fieldRefValue returns the value of a fieldPath known when converting, or the field the entrypoint shim has to
+// resolve at boot
+func (pod *podContext) fieldRefValue(fieldPath string) (string, string, error) {
+	if match := fieldSubscript.FindStringSubmatch(fieldPath); match != nil {
+		switch match[1] {
+		case "metadata.labels":
+			return pod.meta.Labels[match[2]], "", nil
+		case "metadata.annotations":
+			return pod.meta.Annotations[match[2]], "", nil
+		}
+	}
+
+	switch fieldPath {
+	case "metadata.name":
+		return pod.meta.Name, "", nil
+	case "metadata.namespace":
+		return pod.meta.Namespace, "", nil
+	case "metadata.labels":
+		return formatMap(pod.meta.Labels), "", nil
+	case "metadata.annotations":
+		return formatMap(pod.meta.Annotations), "", nil
+	case "spec.serviceAccountName":
+		return pod.spec.ServiceAccountName, "", nil
+	case "metadata.uid":
+		// workloadToLinuxKit derives the UID of the pods it converts, this is for those converted on their own
+		if pod.meta.UID == "" {
+			pod.meta.UID = podUID("", pod.meta.Namespace, pod.meta.Name)
+		}
+		return string(pod.meta.UID), "", nil
+	case "spec.nodeName", "status.hostIP", "status.podIP", "status.podIPs":
+		return "", fieldPath, nil
+	}
+
+	return "", "", fmt.Errorf("unsupported fieldPath: %s", fieldPath)
+}
+
+// resourceFieldValue returns the value of a container's resource, or the node wide value the entrypoint shim has
+// to resolve at boot when the container has no limit
+func (pod *podContext) resourceFieldValue(container *corev1.Container, ref *corev1.ResourceFieldSelector) (string, string, error) {
+	if ref.ContainerName != "" && ref.ContainerName != container.Name {
+		container = nil
+		for idx := range pod.spec.Containers {
+			if pod.spec.Containers[idx].Name == ref.ContainerName {
+				container = &pod.spec.Containers[idx]
+			}
+		}
+		for idx := range pod.spec.InitContainers {
+			if pod.spec.InitContainers[idx].Name == ref.ContainerName {
+				container = &pod.spec.InitContainers[idx]
+			}
+		}
+		if container == nil {
+			return "", "", fmt.Errorf("no container named %s", ref.ContainerName)
+		}
+	}
+
+	divisor := ref.Divisor
+	if divisor.IsZero() {
+		divisor = resource.MustParse("1")
+	}
+
+	parts := strings.SplitN(ref.Resource, ".", 2)
+	if len(parts) != 2 || (parts[0] != "limits" && parts[0] != "requests") {
+		return "", "", fmt.Errorf("unsupported resource: %s", ref.Resource)
+	}
+	name := corev1.ResourceName(parts[1])
+
+	quantity, ok := container.Resources.Limits[name]
+	if parts[0] == "requests" {
+		// requests default to the limit, and without either there is nothing requested
+		if request, hasRequest := container.Resources.Requests[name]; hasRequest {
+			quantity, ok = request, true
+		} else if !ok {
+			quantity, ok = resource.Quantity{}, true
+		}
+	}
+
+	switch name {
+	case corev1.ResourceCPU:
+		if !ok {
+			return "", fmt.Sprintf("node.cpu:%d", divisor.MilliValue()), nil
+		}
+		return strconv.FormatInt(int64(math.Ceil(float64(quantity.MilliValue())/float64(divisor.MilliValue()))), 10), "", nil
+	case corev1.ResourceMemory:
+		if !ok {
+			return "", fmt.Sprintf("node.memory:%d", divisor.Value()), nil
+		}
+		return strconv.FormatInt(int64(math.Ceil(float64(quantity.Value())/float64(divisor.Value()))), 10), "", nil
+	case corev1.ResourceEphemeralStorage:
+		if !ok {
+			return "", "", fmt.Errorf("%s has no limit set and can't be determined at boot", ref.Resource)
+		}
+		return strconv.FormatInt(int64(math.Ceil(float64(quantity.Value())/float64(divisor.Value()))), 10), "", nil
+	}
+
+	return "", "", fmt.Errorf("unsupported resource: %s", ref.Resource)
+}
+
+// resolveEnvSource returns the value for an environment variable's valueFrom, or the field which has to be resolved
+// at boot, or neither when the source isn't something we can dereference
+func (pod *podContext) resolveEnvSource(container *corev1.Container, source *corev1.EnvVarSource) (*string, string, error) {
+	var value, runtimeField string
+	var err error
+
+	if source.FieldRef != nil {
+		if source.FieldRef.FieldPath == "metadata.labels" || source.FieldRef.FieldPath == "metadata.annotations" {
+			return nil, "", fmt.Errorf("%s is only supported in downwardAPI volumes", source.FieldRef.FieldPath)
+		}
+		value, runtimeField, err = pod.fieldRefValue(source.FieldRef.FieldPath)
+	} else if source.ResourceFieldRef != nil {
+		value, runtimeField, err = pod.resourceFieldValue(container, source.ResourceFieldRef)
+	} else {
+		return nil, "", nil
+	}
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	return &value, runtimeField, nil
+}
+
+// addDirectory adds a directory, and those above it, to the image unless it's already there
+func (pod *podContext) addDirectory(dir string) {
+	// everything we generate lives under generatedFilesDir, whose parent is part of any base image
+	if dir == path.Dir(generatedFilesDir) || dir == "." || dir == "" {
+		return
+	}
+
+	for _, file := range pod.files {
+		if file.Path == dir {
+			return
+		}
+	}
+
+	pod.addDirectory(path.Dir(dir))
+	pod.files = append(pod.files, linuxkit.File{Path: dir, Directory: true})
+}
+
+// downwardAPIVolume renders each item of a downwardAPI volume as a file in the image, which the containers bind.
+// Items only known on a running VM are written by an onboot step instead, before any container starts.
+func (pod *podContext) downwardAPIVolume(volume *corev1.Volume) ([]*linuxkit.Image, error) {
+	dir := path.Join(generatedFilesDir, "volumes", volume.Name)
+	pod.volumeMap[volume.Name] = "/" + dir
+	pod.readOnlyVolumes[volume.Name] = true
+	pod.addDirectory(dir)
+
+	defaultMode := corev1.DownwardAPIVolumeSourceDefaultMode
+	if volume.DownwardAPI.DefaultMode != nil {
+		defaultMode = *volume.DownwardAPI.DefaultMode
+	}
+
+	var script strings.Builder
+
+	for _, item := range volume.DownwardAPI.Items {
+		if path.IsAbs(item.Path) || strings.HasPrefix(path.Clean(item.Path), "..") {
+			return nil, fmt.Errorf("downwardAPI volume %s: path %s must be relative and may not contain '..'", volume.Name, item.Path)
+		}
+
+		var value, runtimeField string
+		var err error
+
+		if item.FieldRef != nil {
+			value, runtimeField, err = pod.fieldRefValue(item.FieldRef.FieldPath)
+		} else if item.ResourceFieldRef != nil {
+			if item.ResourceFieldRef.ContainerName == "" {
+				return nil, fmt.Errorf("downwardAPI volume %s: resourceFieldRef for %s needs a containerName", volume.Name, item.Path)
+			}
+			value, runtimeField, err = pod.resourceFieldValue(&corev1.Container{}, item.ResourceFieldRef)
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("downwardAPI volume %s: %v", volume.Name, err)
+		}
+
+		mode := defaultMode
+		if item.Mode != nil {
+			mode = *item.Mode
+		}
+
+		file := path.Join(dir, item.Path)
+		pod.addDirectory(path.Dir(file))
+
+		if runtimeField != "" {
+			fmt.Fprintf(&script, "write %s %s %#o\n", shellQuote(path.Join(hostRoot, file)), shellQuote(runtimeField), mode)
+			continue
+		}
+
+		pod.files = append(pod.files, linuxkit.File{
+			Path:     file,
+			Contents: &value,
+			Mode:     fmt.Sprintf("%#o", mode),
+		})
+	}
+
+	if script.Len() == 0 {
+		return nil, nil
+	}
+
+	return []*linuxkit.Image{downwardAPIRuntimeImage(volume.Name, script.String())}, nil
+}
+
+// downwardAPIRuntimeImage writes the items of a downwardAPI volume only known on a running VM, with write FILE FIELD
+// MODE for each. It runs in the host's network and uts namespaces to see the VM's address and hostname, after the
+// base's onboot steps have set them up.
+func downwardAPIRuntimeImage(volume string, writes string) *linuxkit.Image {
+	script := runtimeFieldFunction + fmt.Sprintf(`write() {
+  value=$(field "$2")
+  [ -n "$value" ] || fail downwardAPI volume %s: could not find $2 for $1
+  printf %%s "$value" > "$1" && chmod $3 "$1" || fail downwardAPI volume %s: could not write $1
+}
+`, volume, volume) + writes
+
+	image := bootScriptImage(fmt.Sprintf("downward-volume-%s", volume), script)
+	image.Net = "host"
+	image.Uts = "host"
+	return image
+}
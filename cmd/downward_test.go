@@ -0,0 +1,204 @@
+package main
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"strings"
+	"testing"
+)
+
+func testDownwardPod() *podContext {
+	return newPodContext(&corev1.PodTemplateSpec{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "web",
+			Labels:      map[string]string{"app": "web", "tier": "front"},
+			Annotations: map[string]string{"build": "42"},
+		},
+		Spec: corev1.PodSpec{
+			ServiceAccountName: "deployer",
+			InitContainers: []corev1.Container{{
+				Name: "setup",
+				Resources: corev1.ResourceRequirements{
+					Limits: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("64Mi")},
+				},
+			}},
+			Containers: []corev1.Container{{
+				Name: "web",
+				Resources: corev1.ResourceRequirements{
+					Limits:   corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1500m"), corev1.ResourceMemory: resource.MustParse("1Gi")},
+					Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("250m")},
+				},
+			}},
+		},
+	}, nil, nil)
+}
+
+func TestFieldRefValue(t *testing.T) {
+	pod := testDownwardPod()
+
+	for _, test := range []struct {
+		fieldPath    string
+		value        string
+		runtimeField string
+		err          bool
+	}{
+		{fieldPath: "metadata.name", value: "web"},
+		{fieldPath: "metadata.namespace", value: "default"},
+		{fieldPath: "metadata.labels", value: "app=\"web\"\ntier=\"front\"\n"},
+		{fieldPath: "metadata.labels['tier']", value: "front"},
+		{fieldPath: "metadata.annotations['build']", value: "42"},
+		{fieldPath: "metadata.annotations['missing']", value: ""},
+		{fieldPath: "spec.serviceAccountName", value: "deployer"},
+		{fieldPath: "status.podIP", runtimeField: "status.podIP"},
+		{fieldPath: "spec.nodeName", runtimeField: "spec.nodeName"},
+		{fieldPath: "status.phase", err: true},
+	} {
+		value, runtimeField, err := pod.fieldRefValue(test.fieldPath)
+		if test.err {
+			if err == nil {
+				t.Errorf("%s: expected an error", test.fieldPath)
+			}
+			continue
+		}
+		if err != nil || value != test.value || runtimeField != test.runtimeField {
+			t.Errorf("%s: got %q, %q (%v), want %q, %q", test.fieldPath, value, runtimeField, err, test.value, test.runtimeField)
+		}
+	}
+
+	// the UID is derived from the pod's identity, the same every time
+	uid, runtimeField, err := pod.fieldRefValue("metadata.uid")
+	if err != nil || runtimeField != "" || len(uid) != 36 || uid[14] != '5' {
+		t.Errorf("metadata.uid: got %q, %q (%v), want a version 5 UID known when converting", uid, runtimeField, err)
+	}
+	if again, _, _ := testDownwardPod().fieldRefValue("metadata.uid"); again != uid {
+		t.Errorf("metadata.uid: got %s then %s for the same pod, want the same UID", uid, again)
+	}
+	other := testDownwardPod()
+	other.meta.Name = "api"
+	if other, _, _ := other.fieldRefValue("metadata.uid"); other == uid {
+		t.Errorf("metadata.uid: got %s for two pods, want different UIDs", uid)
+	}
+
+	// and a UID of the pod's own is kept
+	pod.meta.UID = "6f1e2c34-0000-4000-8000-000000000000"
+	if own, _, _ := pod.fieldRefValue("metadata.uid"); own != "6f1e2c34-0000-4000-8000-000000000000" {
+		t.Errorf("metadata.uid: got %s, want the pod's own", own)
+	}
+}
+
+func TestPodUID(t *testing.T) {
+	for _, test := range []struct {
+		a, b []string
+		same bool
+	}{
+		{[]string{"Deployment", "default", "web", ""}, []string{"Deployment", "default", "web", ""}, true},
+		{[]string{"Deployment", "default", "web", ""}, []string{"DaemonSet", "default", "web", ""}, false},
+		{[]string{"Deployment", "default", "web", ""}, []string{"Deployment", "staging", "web", ""}, false},
+		{[]string{"StatefulSet", "default", "db-0", ""}, []string{"StatefulSet", "default", "db-1", ""}, false},
+		{[]string{"Deployment", "default", "web", ""}, []string{"Deployment", "default", "web", "web"}, false},
+	} {
+		if same := podUID(test.a...) == podUID(test.b...); same != test.same {
+			t.Errorf("%v and %v: got the same UID %v, want %v", test.a, test.b, same, test.same)
+		}
+	}
+}
+
+func TestResourceFieldValue(t *testing.T) {
+	pod := testDownwardPod()
+	web := &pod.spec.Containers[0]
+
+	for _, test := range []struct {
+		resource      string
+		containerName string
+		divisor       string
+		value         string
+		runtimeField  string
+		err           bool
+	}{
+		{resource: "limits.cpu", value: "2"},
+		{resource: "limits.cpu", divisor: "1m", value: "1500"},
+		{resource: "requests.cpu", divisor: "1m", value: "250"},
+		{resource: "limits.memory", divisor: "1Mi", value: "1024"},
+		// requests default to the limit
+		{resource: "requests.memory", divisor: "1Mi", value: "1024"},
+		{resource: "limits.memory", containerName: "setup", divisor: "1Mi", value: "64"},
+		// without a limit, the node's
+		{resource: "limits.cpu", containerName: "setup", divisor: "1m", runtimeField: "node.cpu:1"},
+		{resource: "requests.cpu", containerName: "setup", value: "0"},
+		{resource: "limits.ephemeral-storage", err: true},
+		{resource: "limits.cpu", containerName: "missing", err: true},
+		{resource: "cpu", err: true},
+		{resource: "limits.gpu", err: true},
+	} {
+		ref := &corev1.ResourceFieldSelector{Resource: test.resource, ContainerName: test.containerName}
+		if test.divisor != "" {
+			ref.Divisor = resource.MustParse(test.divisor)
+		}
+
+		name := test.resource + " of " + test.containerName
+		value, runtimeField, err := pod.resourceFieldValue(web, ref)
+		if test.err {
+			if err == nil {
+				t.Errorf("%s: expected an error", name)
+			}
+			continue
+		}
+		if err != nil || value != test.value || runtimeField != test.runtimeField {
+			t.Errorf("%s: got %q, %q (%v), want %q, %q", name, value, runtimeField, err, test.value, test.runtimeField)
+		}
+	}
+}
+
+func TestDownwardAPIVolume(t *testing.T) {
+	pod := testDownwardPod()
+	mode := int32(0600)
+
+	images, err := pod.downwardAPIVolume(&corev1.Volume{
+		Name: "info",
+		VolumeSource: corev1.VolumeSource{DownwardAPI: &corev1.DownwardAPIVolumeSource{
+			Items: []corev1.DownwardAPIVolumeFile{
+				{Path: "labels", FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.labels"}},
+				{Path: "net/ip", FieldRef: &corev1.ObjectFieldSelector{FieldPath: "status.podIP"}, Mode: &mode},
+				{Path: "mem", ResourceFieldRef: &corev1.ResourceFieldSelector{ContainerName: "setup", Resource: "limits.memory"}},
+			},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string]string{}
+	for _, file := range pod.files {
+		if !file.Directory {
+			files[file.Path] = *file.Contents
+		}
+	}
+	if len(files) != 2 || files["etc/podspec2linuxkit/volumes/info/labels"] == "" || files["etc/podspec2linuxkit/volumes/info/mem"] != "67108864" {
+		t.Errorf("got files %v, want labels and mem", files)
+	}
+
+	// what is only known at boot is written by an onboot step
+	if len(images) != 1 {
+		t.Fatalf("got %d onboot steps, want 1", len(images))
+	}
+	script := (*images[0].Command)[2]
+	if want := "write '/host/etc/podspec2linuxkit/volumes/info/net/ip' 'status.podIP' 0600\n"; !strings.HasSuffix(script, want) {
+		t.Errorf("got script:\n%s\nwant it to end with %q", script, want)
+	}
+	if images[0].Net != "host" {
+		t.Errorf("got net %q, want the host's to find the pod's IP", images[0].Net)
+	}
+
+	for _, path := range []string{"/etc/passwd", "../escape"} {
+		_, err := pod.downwardAPIVolume(&corev1.Volume{
+			Name: "bad",
+			VolumeSource: corev1.VolumeSource{DownwardAPI: &corev1.DownwardAPIVolumeSource{
+				Items: []corev1.DownwardAPIVolumeFile{{Path: path, FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.name"}}},
+			}},
+		})
+		if err == nil {
+			t.Errorf("%s: expected an error", path)
+		}
+	}
+}
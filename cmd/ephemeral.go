@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"github.com/tjfontaine/podspec2linuxkit/pkg/linuxkit"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"path"
+)
+
+// ephemeralVolumeToLinuxKitMount treats a generic ephemeral volume like a disk backed emptyDir: a fresh filesystem
+// each boot, on a sparse file the size of the claim template's storage request so it can't outgrow it
+func ephemeralVolumeToLinuxKitMount(pod *podContext, volume *corev1.Volume, source map[string]interface{}) ([]*linuxkit.Image, error) {
+	claim, ok, _ := unstructured.NestedMap(source, "volumeClaimTemplate", "spec")
+	if !ok {
+		return nil, fmt.Errorf("ephemeral volume %s needs a volumeClaimTemplate", volume.Name)
+	}
+
+	// decoding the claim keeps its storage request the quantity it was written as, however large
+	spec := corev1.PersistentVolumeClaimSpec{}
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(claim, &spec); err != nil {
+		return nil, fmt.Errorf("ephemeral volume %s: %v", volume.Name, err)
+	}
+
+	size, ok := spec.Resources.Requests[corev1.ResourceStorage]
+	if !ok {
+		return nil, fmt.Errorf("ephemeral volume %s needs a storage request to size it", volume.Name)
+	}
+
+	if pod.blockVolumes[volume.Name] {
+		if spec.VolumeMode == nil || *spec.VolumeMode != corev1.PersistentVolumeBlock {
+			return nil, fmt.Errorf("ephemeral volume %s is used through volumeDevices, but its volumeMode isn't Block", volume.Name)
+		}
+	}
+
+	backing := fmt.Sprintf("/var/lib/volumes/.ephemeral/%s.img", volume.Name)
+	return loopDiskToLinuxKitMount(pod, volume, backing, size.Value(), false), nil
+}
+
+// loopDiskToLinuxKitMount backs a volume with a sparse file of the given size on /var/lib, loop mounted, or attached
+// as a loop device for volumeDevices. A persistent one is kept, and only formatted the first time; otherwise it's
+// created afresh each boot.
+func loopDiskToLinuxKitMount(pod *podContext, volume *corev1.Volume, backing string, size int64, persistent bool) []*linuxkit.Image {
+	create := fmt.Sprintf("rm -f %s\ntruncate -s %d %s\n", backing, size, backing)
+	format := fmt.Sprintf("mkfs.ext4 -q -F %s\n", backing)
+	if persistent {
+		create = fmt.Sprintf("[ -e %s ] || truncate -s %d %s\n", backing, size, backing)
+		format = fmt.Sprintf("blkid %s >/dev/null 2>&1 || mkfs.ext4 -q -F %s\n", backing, backing)
+	}
+
+	script := fmt.Sprintf("set -e\nmkdir -p %s\n%s", path.Dir(backing), create)
+
+	if pod.blockVolumes[volume.Name] {
+		link := fmt.Sprintf("/dev/disk/by-volume/%s", volume.Name)
+		script += fmt.Sprintf("mkdir -p /dev/disk/by-volume\nln -sf $(losetup -f --show %s) %s\n", backing, link)
+		pod.devices[volume.Name] = &blockDevice{path: link, rules: []linuxkit.LinuxDeviceCgroup{deviceRule(7, nil)}}
+	} else {
+		path := fmt.Sprintf("/var/lib/volumes/%s", volume.Name)
+		pod.volumeMap[volume.Name] = path
+		script += fmt.Sprintf("%smkdir -p %s\nmount -o loop %s %s\n", format, path, backing, path)
+	}
+
+	propagation := "shared"
+
+	return []*linuxkit.Image{
+		&linuxkit.Image{
+			Name:  fmt.Sprintf("create-volume-%s", volume.Name),
+			Image: DISK_HELPER_IMAGE,
+			ImageConfig: linuxkit.ImageConfig{
+				Command:           &[]string{"/bin/sh", "-c", script},
+				Capabilities:      &[]string{"all"},
+				Binds:             &[]string{"/dev:/dev", "/var:/var:rshared,rbind"},
+				RootfsPropagation: &propagation,
+			},
+		},
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	corev1 "k8s.io/api/core/v1"
+	"strings"
+	"testing"
+)
+
+func TestEphemeralVolumeSize(t *testing.T) {
+	for _, test := range []struct {
+		storage interface{}
+		size    int64
+	}{
+		{"1Gi", 1 << 30},
+		{"500M", 500000000},
+		{"2Ti", 2 << 40},
+		// numbers are bytes, as decoded from yaml or json
+		{int64(10737418240), 10737418240},
+		{float64(10737418240), 10737418240},
+		{float64(1 << 50), 1 << 50},
+	} {
+		pod := newPodContext(&corev1.PodTemplateSpec{}, nil, nil)
+		images, err := ephemeralVolumeToLinuxKitMount(pod, &corev1.Volume{Name: "scratch"}, map[string]interface{}{
+			"volumeClaimTemplate": map[string]interface{}{
+				"spec": map[string]interface{}{
+					"resources": map[string]interface{}{"requests": map[string]interface{}{"storage": test.storage}},
+				},
+			},
+		})
+		if err != nil {
+			t.Errorf("%v: %v", test.storage, err)
+			continue
+		}
+
+		script := (*images[0].Command)[2]
+		if want := fmt.Sprintf("truncate -s %d ", test.size); !strings.Contains(script, want) {
+			t.Errorf("%v: got script:\n%s\nwant %q", test.storage, script, want)
+		}
+	}
+
+	for _, spec := range []map[string]interface{}{
+		{},
+		{"resources": map[string]interface{}{"requests": map[string]interface{}{"storage": "lots"}}},
+	} {
+		pod := newPodContext(&corev1.PodTemplateSpec{}, nil, nil)
+		_, err := ephemeralVolumeToLinuxKitMount(pod, &corev1.Volume{Name: "scratch"}, map[string]interface{}{
+			"volumeClaimTemplate": map[string]interface{}{"spec": spec},
+		})
+		if err == nil {
+			t.Errorf("%v: expected an error", spec)
+		}
+	}
+
+	// a volume used through volumeDevices has to be a block one
+	pod := newPodContext(&corev1.PodTemplateSpec{Spec: corev1.PodSpec{Containers: []corev1.Container{{
+		Name:          "db",
+		VolumeDevices: []corev1.VolumeDevice{{Name: "scratch", DevicePath: "/dev/xvdb"}},
+	}}}}, nil, nil)
+	for _, mode := range []string{"Filesystem", "Block"} {
+		_, err := ephemeralVolumeToLinuxKitMount(pod, &corev1.Volume{Name: "scratch"}, map[string]interface{}{
+			"volumeClaimTemplate": map[string]interface{}{
+				"spec": map[string]interface{}{
+					"volumeMode": mode,
+					"resources":  map[string]interface{}{"requests": map[string]interface{}{"storage": "1Gi"}},
+				},
+			},
+		})
+		if (err == nil) != (mode == "Block") {
+			t.Errorf("%s: got %v", mode, err)
+		}
+	}
+}
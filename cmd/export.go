@@ -0,0 +1,163 @@
+package main
+
+import (
+	"archive/tar"
+	"flag"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"github.com/tjfontaine/podspec2linuxkit/pkg/linuxkit"
+	"github.com/tjfontaine/podspec2linuxkit/pkg/resolver"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// newFetcher parses --from: registry or oci:<dir>
+func newFetcher(spec string, dockerConfig string) (resolver.Fetcher, error) {
+	parts := strings.SplitN(spec, ":", 2)
+	switch {
+	case spec == "registry":
+		registry := &resolver.Registry{}
+		if dockerConfig != "" {
+			auths, err := readDockerConfig(dockerConfig)
+			if err != nil {
+				return nil, err
+			}
+			registry.Credentials = registryCredentials(auths)
+		}
+		return registry, nil
+	case parts[0] == "oci" && len(parts) == 2:
+		return &resolver.OCILayout{Dir: parts[1]}, nil
+	}
+
+	return nil, fmt.Errorf("unknown image source %s, expected registry or oci:<dir>", spec)
+}
+
+// tarDirectory writes the files in dir to a tar, with paths relative to it
+func tarDirectory(dir string, file string) error {
+	out, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+
+	tw := tar.NewWriter(out)
+	err = filepath.Walk(dir, func(name string, info os.FileInfo, err error) error {
+		if err != nil || name == dir {
+			return err
+		}
+
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		if header.Name, err = filepath.Rel(dir, name); err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(header.Name)
+		if info.IsDir() {
+			header.Name += "/"
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		in, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+
+		_, err = io.Copy(tw, in)
+		return err
+	})
+	if err == nil {
+		err = tw.Close()
+	}
+	// closed once, whether the tar was written or not, without losing an error closing it
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// exportImagesMain copies every image a LinuxKit config uses into an OCI image layout, or a tar of one for
+// linuxkit cache import, so it can be built without access to the registries
+func exportImagesMain(args []string) error {
+	flags := flag.NewFlagSet("export-images", flag.ContinueOnError)
+	from := flags.String("from", "registry", "where to copy the images from, their registry or an OCI image layout (oci:<dir>)")
+	output := flags.String("output", "", "OCI image layout directory to write the images to, or a .tar file of one for linuxkit cache import")
+	arch := flags.String("arch", "", "comma separated architectures, like amd64, to copy of multi-platform images, all of them by default")
+	dockerConfig := flags.String("docker-config", "", "directory with a config.json holding the credentials for the registries, like --docker-config writes")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *output == "" || flags.NArg() > 0 {
+		return fmt.Errorf("usage: export-images --output <dir or file.tar> [--from registry|oci:<dir>] < linuxkit.yaml")
+	}
+
+	fetcher, err := newFetcher(*from, *dockerConfig)
+	if err != nil {
+		return err
+	}
+
+	platforms := []string{}
+	for _, arch := range splitList(*arch) {
+		platforms = append(platforms, "linux/"+arch)
+	}
+
+	rawYaml, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		return err
+	}
+
+	config, err := linuxkit.NewConfig(rawYaml)
+	if err != nil {
+		return err
+	}
+
+	dir := *output
+	if strings.HasSuffix(*output, ".tar") {
+		if dir, err = ioutil.TempDir("", "export-images"); err != nil {
+			return err
+		}
+		defer os.RemoveAll(dir)
+	}
+
+	writer, err := resolver.NewLayoutWriter(dir, platforms)
+	if err != nil {
+		return err
+	}
+
+	// busybox and docker.io/library/busybox:latest are the same image
+	exported := map[string]bool{}
+	for _, image := range config.ImageReferences() {
+		ref, err := linuxkit.ParseReference(image)
+		if err != nil {
+			return err
+		}
+		if exported[ref.String()] {
+			continue
+		}
+		exported[ref.String()] = true
+
+		log.Infof("exporting %s", ref.String())
+		if err := writer.Copy(fetcher, ref); err != nil {
+			return err
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		return err
+	}
+
+	if dir != *output {
+		return tarDirectory(dir, *output)
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"github.com/tjfontaine/podspec2linuxkit/pkg/linuxkit"
+	corev1 "k8s.io/api/core/v1"
+	"strings"
+)
+
+// IMAGE_EXPORT_IMAGE pulls and flattens an image at boot, for image volumes which are always pulled
+var IMAGE_EXPORT_IMAGE = "gcr.io/go-containerregistry/crane:debug"
+
+// defaultImagePullPolicy is what Kubernetes picks when pullPolicy isn't set
+func defaultImagePullPolicy(reference string) corev1.PullPolicy {
+	if strings.Contains(reference, "@") {
+		return corev1.PullIfNotPresent
+	}
+
+	name := reference[strings.LastIndex(reference, "/")+1:]
+	if !strings.Contains(name, ":") || strings.HasSuffix(name, ":latest") {
+		return corev1.PullAlways
+	}
+
+	return corev1.PullIfNotPresent
+}
+
+// imageVolumeToLinuxKitMount makes the contents of an OCI image available under /var/lib/volumes.
+//
+// Unless it has to be pulled at boot, the image is listed as an onboot container so that linuxkit build unpacks it
+// into the VM, and its rootfs is copied into the volume at boot.
+func imageVolumeToLinuxKitMount(pod *podContext, volume *corev1.Volume, source map[string]interface{}) ([]*linuxkit.Image, error) {
+	reference, _ := source["reference"].(string)
+	if reference == "" {
+		return nil, fmt.Errorf("image volume %s needs a reference", volume.Name)
+	}
+
+	pullPolicy := defaultImagePullPolicy(reference)
+	if policy, ok := source["pullPolicy"].(string); ok && policy != "" {
+		pullPolicy = corev1.PullPolicy(policy)
+	}
+
+	path := fmt.Sprintf("/var/lib/volumes/%s", volume.Name)
+	pod.volumeMap[volume.Name] = path
+	pod.readOnlyVolumes[volume.Name] = true
+
+	switch pullPolicy {
+	case corev1.PullAlways:
+		export := fmt.Sprintf("set -e\nrm -rf /volume/* /volume/.[!.]*\ncrane export %s - | tar -xf - -C /volume\n", shellQuote(reference))
+		return []*linuxkit.Image{
+			&linuxkit.Image{
+				Name:  fmt.Sprintf("pull-volume-%s", volume.Name),
+				Image: IMAGE_EXPORT_IMAGE,
+				ImageConfig: linuxkit.ImageConfig{
+					Command: &[]string{"/busybox/sh", "-c", export},
+					Binds:   &[]string{"/etc/resolv.conf:/etc/resolv.conf", fmt.Sprintf("%s:/volume", path)},
+					Net:     "host",
+					Runtime: &linuxkit.Runtime{
+						Mkdir: &[]string{path},
+					},
+				},
+			},
+		}, nil
+	case corev1.PullIfNotPresent, corev1.PullNever:
+		// the image only has to exist for linuxkit build to unpack it, so it runs the shim's busybox to do nothing
+		pod.useEntrypointShim()
+		readonly := true
+		name := fmt.Sprintf("image-volume-%s", volume.Name)
+		unpack := unpackImageVolumeScript(hostRoot, name, volume.Name, path)
+
+		return []*linuxkit.Image{
+			&linuxkit.Image{
+				Name:  name,
+				Image: reference,
+				ImageConfig: linuxkit.ImageConfig{
+					Command:  &[]string{entrypointShimDir + "/busybox", "true"},
+					Binds:    &[]string{entrypointShimBinds()[0]},
+					Readonly: &readonly,
+				},
+			},
+			bootScriptImage(fmt.Sprintf("unpack-volume-%s", volume.Name), unpack),
+		}, nil
+	}
+
+	return nil, fmt.Errorf("image volume %s has unknown pullPolicy %s", volume.Name, pullPolicy)
+}
+
+// unpackImageVolumeScript copies the rootfs linuxkit build unpacked for the onboot container name into path, both
+// under root. linuxkit build puts it in containers/onboot/<index>-<name>, and only the index is left to a glob so the
+// image of another volume whose name ends the same, e.g. from a merged pod, is never picked up instead.
+func unpackImageVolumeScript(root string, name string, volume string, path string) string {
+	target := shellQuote(root + path)
+	return fmt.Sprintf(`set -- %s/containers/onboot/[0-9][0-9][0-9]-%s/rootfs
+[ $# -eq 1 ] && [ -d "$1" ] || fail image for volume %s was not unpacked
+rootfs=$1
+rm -rf %s && mkdir -p %s
+cp -a "$rootfs"/. %s/ || fail could not copy image for volume %s
+rm -rf %s/%s
+`, shellQuote(root), shellQuote(name), volume, target, target, target, volume, target, strings.TrimPrefix(entrypointShimDir, "/"))
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	digest "github.com/opencontainers/go-digest"
+	"github.com/tjfontaine/podspec2linuxkit/pkg/linuxkit"
+	"github.com/tjfontaine/podspec2linuxkit/pkg/resolver"
+	"io"
+	"io/ioutil"
+	corev1 "k8s.io/api/core/v1"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"testing"
+)
+
+func TestDefaultImagePullPolicy(t *testing.T) {
+	for _, test := range []struct {
+		reference string
+		policy    corev1.PullPolicy
+	}{
+		{"nginx", corev1.PullAlways},
+		{"nginx:latest", corev1.PullAlways},
+		{"nginx:1.15", corev1.PullIfNotPresent},
+		{"localhost:5000/nginx", corev1.PullAlways},
+		{"localhost:5000/nginx:1.15", corev1.PullIfNotPresent},
+		{"nginx@sha256:" + digest.FromString("nginx").Hex(), corev1.PullIfNotPresent},
+	} {
+		if policy := defaultImagePullPolicy(test.reference); policy != test.policy {
+			t.Errorf("%s: got %s, want %s", test.reference, policy, test.policy)
+		}
+	}
+}
+
+func TestImageVolumeToLinuxKitMount(t *testing.T) {
+	for _, test := range []struct {
+		source map[string]interface{}
+		images []string
+		err    bool
+	}{
+		{source: map[string]interface{}{"reference": "models:v1"}, images: []string{"image-volume-models", "unpack-volume-models"}},
+		{source: map[string]interface{}{"reference": "models:v1", "pullPolicy": "Never"}, images: []string{"image-volume-models", "unpack-volume-models"}},
+		{source: map[string]interface{}{"reference": "models"}, images: []string{"pull-volume-models"}},
+		{source: map[string]interface{}{"reference": "models:v1", "pullPolicy": "Always"}, images: []string{"pull-volume-models"}},
+		{source: map[string]interface{}{"reference": "models:v1", "pullPolicy": "Sometimes"}, err: true},
+		{source: map[string]interface{}{}, err: true},
+	} {
+		pod := newPodContext(&corev1.PodTemplateSpec{}, nil, nil)
+		images, err := imageVolumeToLinuxKitMount(pod, &corev1.Volume{Name: "models"}, test.source)
+		if test.err {
+			if err == nil {
+				t.Errorf("%v: expected an error", test.source)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%v: %v", test.source, err)
+			continue
+		}
+
+		names := []string{}
+		for _, image := range images {
+			names = append(names, image.Name)
+		}
+		if len(names) != len(test.images) || names[0] != test.images[0] || names[len(names)-1] != test.images[len(test.images)-1] {
+			t.Errorf("%v: got %v, want %v", test.source, names, test.images)
+		}
+		if pod.volumeMap["models"] != "/var/lib/volumes/models" || !pod.readOnlyVolumes["models"] {
+			t.Errorf("%v: expected a read only volume at /var/lib/volumes/models", test.source)
+		}
+	}
+}
+
+// writeTestLayout writes an OCI image layout with a single layer image named ref, holding files
+func writeTestLayout(t *testing.T, dir string, ref string, files map[string]string) {
+	blob := func(data []byte) resolver.Descriptor {
+		dgst := digest.FromBytes(data)
+		if err := os.MkdirAll(path.Join(dir, "blobs", "sha256"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path.Join(dir, "blobs", "sha256", dgst.Hex()), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		return resolver.Descriptor{Digest: dgst, Size: int64(len(data))}
+	}
+	blobJSON := func(v interface{}) resolver.Descriptor {
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return blob(data)
+	}
+
+	layer := &bytes.Buffer{}
+	tw := tar.NewWriter(layer)
+	for name, contents := range files {
+		tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(contents)), Typeflag: tar.TypeReg})
+		tw.Write([]byte(contents))
+	}
+	tw.Close()
+
+	layerDesc := blob(layer.Bytes())
+	layerDesc.MediaType = "application/vnd.oci.image.layer.v1.tar"
+	configDesc := blob([]byte(`{"architecture":"amd64","os":"linux"}`))
+	configDesc.MediaType = "application/vnd.oci.image.config.v1+json"
+	manifest := blobJSON(&resolver.Manifest{
+		SchemaVersion: 2,
+		MediaType:     "application/vnd.oci.image.manifest.v1+json",
+		Config:        &configDesc,
+		Layers:        []resolver.Descriptor{layerDesc},
+	})
+	manifest.MediaType = "application/vnd.oci.image.manifest.v1+json"
+	manifest.Annotations = map[string]string{"org.opencontainers.image.ref.name": ref}
+
+	index, err := json.Marshal(&resolver.Manifest{SchemaVersion: 2, Manifests: []resolver.Descriptor{manifest}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path.Join(dir, "index.json"), index, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path.Join(dir, "oci-layout"), []byte(`{"imageLayoutVersion":"1.0.0"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// unpackTestImage unpacks the layers of an image from source into rootfs, the way linuxkit build does for onboot
+// containers
+func unpackTestImage(t *testing.T, source string, image string, rootfs string) {
+	fetcher, err := newFetcher(source, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref, err := linuxkit.ParseReference(image)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, manifest, err := resolver.Describe(fetcher, ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, layer := range manifest.Layers {
+		blob, err := fetcher.FetchBlob(ref, layer.Digest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tr := tar.NewReader(blob)
+		for {
+			header, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			name := filepath.Join(rootfs, header.Name)
+			if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+				t.Fatal(err)
+			}
+			data, err := ioutil.ReadAll(tr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := ioutil.WriteFile(name, data, os.FileMode(header.Mode)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		blob.Close()
+	}
+}
+
+func TestUnpackImageVolumeScript(t *testing.T) {
+	dir, err := ioutil.TempDir("", "imagevolume")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	layout := path.Join(dir, "layout")
+	writeTestLayout(t, layout, "registry.example.com/ml/models:v1", map[string]string{
+		"models/model.bin":          "weights",
+		".podspec2linuxkit/busybox": "shim",
+		"etc/os-release":            "ID=scratch",
+	})
+	writeTestLayout(t, path.Join(dir, "other"), "registry.example.com/ml/other:v1", map[string]string{
+		"models/model.bin": "other weights",
+	})
+
+	// another image volume whose onboot name ends the same comes first, e.g. from a merged pod
+	root := path.Join(dir, "host")
+	unpackTestImage(t, "oci:"+path.Join(dir, "other"), "registry.example.com/ml/other:v1", path.Join(root, "containers/onboot/002-web-image-volume-models/rootfs"))
+	unpackTestImage(t, "oci:"+layout, "registry.example.com/ml/models:v1", path.Join(root, "containers/onboot/005-image-volume-models/rootfs"))
+
+	// fail powers off the VM, which a test can't have
+	script := "fail() { echo \"$*\" >&2; exit 1; }\n" + unpackImageVolumeScript(root, "image-volume-models", "models", "/var/lib/volumes/models")
+	if out, err := exec.Command("/bin/sh", "-c", script).CombinedOutput(); err != nil {
+		t.Fatalf("%v: %s", err, out)
+	}
+
+	volume := path.Join(root, "var/lib/volumes/models")
+	if data, err := ioutil.ReadFile(path.Join(volume, "models/model.bin")); err != nil || string(data) != "weights" {
+		t.Errorf("models/model.bin: got %q (%v), want %q", data, err, "weights")
+	}
+	if _, err := os.Stat(path.Join(volume, ".podspec2linuxkit")); !os.IsNotExist(err) {
+		t.Errorf("expected the entrypoint shim directory to be removed from the volume")
+	}
+
+	// without the image's rootfs the script has to fail rather than leave an empty volume
+	script = "fail() { echo \"$*\" >&2; exit 1; }\n" + unpackImageVolumeScript(root, "image-volume-missing", "missing", "/var/lib/volumes/missing")
+	if err := exec.Command("/bin/sh", "-c", script).Run(); err == nil {
+		t.Errorf("expected the script to fail without the image of the volume")
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"strings"
+)
+
+// decodeObjects returns the objects in a stream of yaml documents, with Lists expanded into their items
+func decodeObjects(stream []byte) ([]map[string]interface{}, error) {
+	reader := utilyaml.NewYAMLReader(bufio.NewReader(bytes.NewReader(stream)))
+	objects := []map[string]interface{}{}
+
+	for {
+		doc, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		obj, err := decodeRaw(doc)
+		if err != nil {
+			return nil, err
+		}
+
+		// e.g. after a leading ---, or a document which is only comments
+		if len(obj) == 0 {
+			continue
+		}
+
+		items, err := expandList(obj)
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, items...)
+	}
+
+	return objects, nil
+}
+
+// expandList returns the items of a List, or of a typed list like DeploymentList, and otherwise just the object
+func expandList(obj map[string]interface{}) ([]map[string]interface{}, error) {
+	kind, _ := obj["kind"].(string)
+	if !strings.HasSuffix(kind, "List") {
+		return []map[string]interface{}{obj}, nil
+	}
+
+	rawItems, ok := obj["items"].([]interface{})
+	if !ok {
+		return []map[string]interface{}{obj}, nil
+	}
+
+	items := []map[string]interface{}{}
+	for idx, rawItem := range rawItems {
+		item, ok := rawItem.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("item %d of %s isn't an object", idx, kind)
+		}
+
+		// items of typed lists leave out what the list already says
+		if _, ok := item["apiVersion"]; !ok {
+			item["apiVersion"] = obj["apiVersion"]
+		}
+		if _, ok := item["kind"]; !ok && kind != "List" {
+			item["kind"] = strings.TrimSuffix(kind, "List")
+		}
+
+		expanded, err := expandList(item)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, expanded...)
+	}
+
+	return items, nil
+}
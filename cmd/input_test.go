@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func describeObjects(objects []map[string]interface{}) string {
+	described := []string{}
+	for _, obj := range objects {
+		name, _ := obj["metadata"].(map[string]interface{})["name"].(string)
+		described = append(described, fmt.Sprintf("%s %s/%s", obj["apiVersion"], obj["kind"], name))
+	}
+	return strings.Join(described, ", ")
+}
+
+func TestDecodeObjects(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		input   string
+		objects string
+		err     bool
+	}{
+		{
+			name: "single document",
+			input: `apiVersion: v1
+kind: Pod
+metadata: {name: web}
+`,
+			objects: "v1 Pod/web",
+		},
+		{
+			name: "documents, with a leading separator and one of comments only",
+			input: `---
+apiVersion: v1
+kind: Pod
+metadata: {name: web}
+---
+# nothing here
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata: {name: api}
+`,
+			objects: "v1 Pod/web, apps/v1 Deployment/api",
+		},
+		{
+			name: "List",
+			input: `apiVersion: v1
+kind: List
+items:
+- apiVersion: v1
+  kind: Pod
+  metadata: {name: web}
+- apiVersion: batch/v1
+  kind: Job
+  metadata: {name: migrate}
+`,
+			objects: "v1 Pod/web, batch/v1 Job/migrate",
+		},
+		{
+			name: "typed list, whose items leave out their kind",
+			input: `apiVersion: apps/v1
+kind: DeploymentList
+items:
+- metadata: {name: web}
+- metadata: {name: api}
+`,
+			objects: "apps/v1 Deployment/web, apps/v1 Deployment/api",
+		},
+		{
+			name: "nested List",
+			input: `apiVersion: v1
+kind: List
+items:
+- apiVersion: v1
+  kind: List
+  items:
+  - apiVersion: v1
+    kind: Pod
+    metadata: {name: web}
+`,
+			objects: "v1 Pod/web",
+		},
+		{
+			name: "List with an item which isn't an object",
+			input: `apiVersion: v1
+kind: List
+items:
+- web
+`,
+			err: true,
+		},
+		{
+			name:  "invalid yaml",
+			input: "kind: [Pod\n",
+			err:   true,
+		},
+	} {
+		objects, err := decodeObjects([]byte(test.input))
+		if test.err {
+			if err == nil {
+				t.Errorf("%s: expected an error", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if described := describeObjects(objects); described != test.objects {
+			t.Errorf("%s: got %s, want %s", test.name, described, test.objects)
+		}
+	}
+}
+
+func TestExpandList(t *testing.T) {
+	// a List without items is left as it is, like any other object
+	obj := map[string]interface{}{"apiVersion": "v1", "kind": "ConfigMapList", "metadata": map[string]interface{}{}}
+	items, err := expandList(obj)
+	if err != nil || len(items) != 1 || items[0]["kind"] != "ConfigMapList" {
+		t.Errorf("got %v (%v), want the list itself", items, err)
+	}
+
+	// an item's own apiVersion and kind win over the list's
+	items, err = expandList(map[string]interface{}{
+		"apiVersion": "apps/v1",
+		"kind":       "DeploymentList",
+		"items": []interface{}{
+			map[string]interface{}{"apiVersion": "apps/v1beta2", "kind": "Deployment", "metadata": map[string]interface{}{"name": "web"}},
+		},
+	})
+	if err != nil || describeObjects(items) != "apps/v1beta2 Deployment/web" {
+		t.Errorf("got %s (%v), want apps/v1beta2 Deployment/web", describeObjects(items), err)
+	}
+}
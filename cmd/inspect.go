@@ -0,0 +1,199 @@
+package main
+
+import (
+	"fmt"
+	"github.com/containerd/containerd/reference"
+	log "github.com/sirupsen/logrus"
+	"github.com/tjfontaine/podspec2linuxkit/pkg/linuxkit"
+	"github.com/tjfontaine/podspec2linuxkit/pkg/resolver"
+	corev1 "k8s.io/api/core/v1"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// imageSource is set by --inspect, and is where the configs of images are read from
+var imageSource resolver.Fetcher
+
+// imageInspector reads the configs of images from imageSource, through the cache in --inspect-cache
+var imageInspector *resolver.Inspector
+
+// the platform images are inspected for, what linuxkit build builds for by default
+const inspectPlatform = "linux/amd64"
+
+// the configs of the images inspected so far, by reference
+var imageConfigs = map[string]*resolver.ImageConfig{}
+
+// defaultInspectCache is where the configs of images are kept unless --inspect-cache says otherwise
+func defaultInspectCache() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "podspec2linuxkit", "images")
+}
+
+// setupInspection reads images from --inspect, resolving their tags the way they are pinned
+func setupInspection(source string, cacheDir string) error {
+	var err error
+	if imageSource, err = newFetcher(source, ""); err != nil {
+		return err
+	}
+
+	imageInspector = &resolver.Inspector{Source: imageSource, CacheDir: cacheDir, Platform: inspectPlatform}
+	if imageResolver != nil {
+		imageInspector.Resolver = imageResolver
+	}
+	return nil
+}
+
+// inspectImage returns the config of an image, nil when it couldn't be read
+func inspectImage(ref reference.Spec) *resolver.ImageConfig {
+	name := ref.String()
+	if config, ok := imageConfigs[name]; ok {
+		return config
+	}
+
+	config, err := imageInspector.Inspect(ref)
+	if err != nil {
+		log.Warnf("Failed to inspect %s: %v", name, err)
+	}
+	imageConfigs[name] = config
+
+	return config
+}
+
+// inspectContainerImage returns the config of a container's image, as it will be once it is rewritten, nil unless
+// images are inspected
+func inspectContainerImage(image string) (*resolver.ImageConfig, error) {
+	if imageInspector == nil {
+		return nil, nil
+	}
+
+	ref, err := linuxkit.ParseReference(image)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := rewriteReference(&ref); err != nil {
+		return nil, err
+	}
+
+	return inspectImage(ref), nil
+}
+
+// containerCommand is what a container runs, worked out from its command, args and the image's entrypoint and cmd
+// the way Kubernetes does, nil when it runs what the image would anyway. The image's command is given when required,
+// for wrapping it, if the image could be inspected.
+func containerCommand(container *corev1.Container, config *resolver.ImageConfig, required bool) []string {
+	if len(container.Command) > 0 {
+		return append(append([]string{}, container.Command...), container.Args...)
+	}
+
+	if config == nil {
+		if len(container.Args) > 0 {
+			log.Warnf("container %s only has args, which need the entrypoint of its image, see --inspect: args ignored", container.Name)
+		}
+		return nil
+	}
+
+	if len(container.Args) > 0 {
+		return append(append([]string{}, config.Config.Entrypoint...), container.Args...)
+	}
+	if required {
+		return append(append([]string{}, config.Config.Entrypoint...), config.Config.Cmd...)
+	}
+	return nil
+}
+
+// imageEnv is the env of the image, less what the container sets itself, which the container's env is added to
+func imageEnv(config *resolver.ImageConfig, containerEnv []corev1.EnvVar) []string {
+	if config == nil {
+		return nil
+	}
+
+	set := map[string]bool{}
+	for _, env := range containerEnv {
+		set[env.Name] = true
+	}
+
+	env := []string{}
+	for _, entry := range config.Config.Env {
+		if !set[strings.SplitN(entry, "=", 2)[0]] {
+			env = append(env, entry)
+		}
+	}
+	return env
+}
+
+// runAsNonRoot says whether a container has to run as another user than root, as the container or its pod says
+func runAsNonRoot(spec *corev1.PodSpec, container *corev1.Container) bool {
+	nonRoot := false
+	if spec.SecurityContext != nil && spec.SecurityContext.RunAsNonRoot != nil {
+		nonRoot = *spec.SecurityContext.RunAsNonRoot
+	}
+	if container.SecurityContext != nil && container.SecurityContext.RunAsNonRoot != nil {
+		nonRoot = *container.SecurityContext.RunAsNonRoot
+	}
+	return nonRoot
+}
+
+// applyImageUser runs a container as the user of its image unless it has one of its own, since linuxkit build only
+// uses the uid and gid in the yaml, and refuses to run it as root when it asks not to be, like the kubelet
+func applyImageUser(spec *corev1.PodSpec, container *corev1.Container, image *linuxkit.Image, config *resolver.ImageConfig) error {
+	nonRoot := runAsNonRoot(spec, container)
+
+	if image.UID == nil && spec.SecurityContext != nil && spec.SecurityContext.RunAsUser != nil {
+		var v interface{} = *spec.SecurityContext.RunAsUser
+		image.UID = &v
+	}
+
+	if image.UID != nil {
+		if nonRoot && *image.UID == interface{}(int64(0)) {
+			return fmt.Errorf("container %s has runAsNonRoot and runAsUser 0", container.Name)
+		}
+		return nil
+	}
+
+	if config == nil {
+		if nonRoot {
+			log.Warnf("container %s has runAsNonRoot, which can't be checked without the user of its image, see --inspect", container.Name)
+		}
+		return nil
+	}
+
+	user := config.Config.User
+	if user == "" {
+		if nonRoot {
+			return fmt.Errorf("container %s has runAsNonRoot and its image runs as root", container.Name)
+		}
+		return nil
+	}
+
+	parts := strings.SplitN(user, ":", 2)
+	uid, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		if nonRoot {
+			return fmt.Errorf("container %s has runAsNonRoot and its image has the non-numeric user %s, which can't be checked", container.Name, user)
+		}
+		log.Warnf("container %s: the user %s of its image isn't numeric, it runs as root", container.Name, user)
+		return nil
+	}
+	if nonRoot && uid == 0 {
+		return fmt.Errorf("container %s has runAsNonRoot and its image runs as root", container.Name)
+	}
+
+	var v interface{} = uid
+	image.UID = &v
+
+	if len(parts) == 2 && image.GID == nil {
+		if gid, err := strconv.ParseInt(parts[1], 10, 64); err == nil {
+			var v interface{} = gid
+			image.GID = &v
+		} else {
+			log.Warnf("container %s: the group %s of its image isn't numeric, it runs as group 0", container.Name, parts[1])
+		}
+	}
+
+	return nil
+}
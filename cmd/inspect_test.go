@@ -0,0 +1,312 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/containerd/containerd/reference"
+	digest "github.com/opencontainers/go-digest"
+	"github.com/tjfontaine/podspec2linuxkit/pkg/linuxkit"
+	"github.com/tjfontaine/podspec2linuxkit/pkg/resolver"
+	"io"
+	"io/ioutil"
+	corev1 "k8s.io/api/core/v1"
+	"strings"
+	"testing"
+)
+
+// stubImages is a Fetcher of images which only have a config, by reference
+type stubImages struct {
+	tags  map[string]digest.Digest
+	blobs map[digest.Digest][]byte
+}
+
+func newStubImages(t *testing.T, configs map[string]*resolver.ImageConfig) *stubImages {
+	images := &stubImages{tags: map[string]digest.Digest{}, blobs: map[digest.Digest][]byte{}}
+	blob := func(v interface{}) digest.Digest {
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		images.blobs[digest.FromBytes(data)] = data
+		return digest.FromBytes(data)
+	}
+
+	for ref, config := range configs {
+		configDigest := blob(config)
+		images.tags[ref] = blob(&resolver.Manifest{
+			SchemaVersion: 2,
+			MediaType:     "application/vnd.oci.image.manifest.v1+json",
+			Config:        &resolver.Descriptor{MediaType: "application/vnd.oci.image.config.v1+json", Digest: configDigest, Size: int64(len(images.blobs[configDigest]))},
+		})
+	}
+
+	return images
+}
+
+func (images *stubImages) Resolve(ref reference.Spec) (digest.Digest, error) {
+	if dgst, ok := images.tags[ref.String()]; ok {
+		return dgst, nil
+	}
+	return "", fmt.Errorf("%s not found", ref.String())
+}
+
+func (images *stubImages) FetchManifest(ref reference.Spec, dgst digest.Digest) ([]byte, string, error) {
+	if data, ok := images.blobs[dgst]; ok {
+		return data, "application/vnd.oci.image.manifest.v1+json", nil
+	}
+	return nil, "", fmt.Errorf("manifest %s not found", dgst)
+}
+
+func (images *stubImages) FetchBlob(ref reference.Spec, dgst digest.Digest) (io.ReadCloser, error) {
+	if data, ok := images.blobs[dgst]; ok {
+		return ioutil.NopCloser(bytes.NewReader(data)), nil
+	}
+	return nil, fmt.Errorf("blob %s not found", dgst)
+}
+
+// testImageConfig is the config of an image with a user, entrypoint, cmd, env and working directory
+func testImageConfig(user string) *resolver.ImageConfig {
+	config := &resolver.ImageConfig{Architecture: "amd64", OS: "linux"}
+	config.Config.User = user
+	config.Config.Env = []string{"PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin", "NGINX_VERSION=1.15.4"}
+	config.Config.Entrypoint = []string{"/docker-entrypoint.sh"}
+	config.Config.Cmd = []string{"nginx", "-g", "daemon off;"}
+	config.Config.WorkingDir = "/usr/share/nginx"
+	return config
+}
+
+func TestContainerCommand(t *testing.T) {
+	config := testImageConfig("")
+
+	for _, test := range []struct {
+		name      string
+		container corev1.Container
+		config    *resolver.ImageConfig
+		required  bool
+		command   string
+	}{
+		{name: "command replaces the entrypoint", container: corev1.Container{Command: []string{"nginx"}, Args: []string{"-t"}}, config: config, command: "nginx -t"},
+		{name: "command, not inspected", container: corev1.Container{Command: []string{"nginx"}}, command: "nginx"},
+		{name: "args replace the cmd", container: corev1.Container{Args: []string{"nginx-debug", "-g", "daemon off;"}}, config: config, command: "/docker-entrypoint.sh nginx-debug -g daemon off;"},
+		// without the entrypoint they can't be passed to, args are ignored
+		{name: "args, not inspected", container: corev1.Container{Args: []string{"nginx-debug"}}},
+		{name: "the image's own, required", config: config, required: true, command: "/docker-entrypoint.sh nginx -g daemon off;"},
+		{name: "the image's own", config: config},
+		{name: "the image's own, required but not inspected", required: true},
+	} {
+		test.container.Name = "web"
+		command := containerCommand(&test.container, test.config, test.required)
+		if strings.Join(command, " ") != test.command || (test.command == "") != (command == nil) {
+			t.Errorf("%s: got %q, want %q", test.name, command, test.command)
+		}
+	}
+
+	// the image's entrypoint isn't changed by the container's args
+	containerCommand(&corev1.Container{Args: []string{"-v"}}, config, false)
+	if strings.Join(config.Config.Entrypoint, " ") != "/docker-entrypoint.sh" {
+		t.Errorf("the image's entrypoint became %v", config.Config.Entrypoint)
+	}
+}
+
+func TestRunAsNonRoot(t *testing.T) {
+	yes, no := true, false
+	context := func(nonRoot *bool) *corev1.SecurityContext {
+		return &corev1.SecurityContext{RunAsNonRoot: nonRoot}
+	}
+
+	for _, test := range []struct {
+		pod, container *bool
+		nonRoot        bool
+	}{
+		{nil, nil, false},
+		{&yes, nil, true},
+		{nil, &yes, true},
+		// the container's overrides the pod's
+		{&yes, &no, false},
+		{&no, &yes, true},
+	} {
+		spec := &corev1.PodSpec{}
+		if test.pod != nil {
+			spec.SecurityContext = &corev1.PodSecurityContext{RunAsNonRoot: test.pod}
+		}
+		container := &corev1.Container{SecurityContext: context(test.container)}
+		if nonRoot := runAsNonRoot(spec, container); nonRoot != test.nonRoot {
+			t.Errorf("pod %v, container %v: got %t", test.pod, test.container, nonRoot)
+		}
+	}
+}
+
+func TestApplyImageUser(t *testing.T) {
+	yes := true
+	var root, user int64 = 0, 2000
+
+	for _, test := range []struct {
+		name      string
+		pod       *corev1.PodSecurityContext
+		container *corev1.SecurityContext
+		// the uid the container already runs as, from its securityContext
+		uid    interface{}
+		config *resolver.ImageConfig
+		// the uid and gid it runs as, or the error
+		runAs string
+		err   string
+	}{
+		{name: "the image's user and group", config: testImageConfig("101:101"), runAs: "101:101"},
+		{name: "the image's user", config: testImageConfig("101"), runAs: "101:-"},
+		{name: "a group by name", config: testImageConfig("101:nginx"), runAs: "101:-"},
+		{name: "a user by name", config: testImageConfig("nginx"), runAs: "-:-"},
+		{name: "an image running as root", config: testImageConfig(""), runAs: "-:-"},
+		{name: "the container's runAsUser", uid: int64(1000), config: testImageConfig("101:101"), runAs: "1000:-"},
+		{name: "the pod's runAsUser", pod: &corev1.PodSecurityContext{RunAsUser: &user}, config: testImageConfig("101:101"), runAs: "2000:-"},
+		{name: "not inspected", runAs: "-:-"},
+		{name: "runAsNonRoot with the image's user", pod: &corev1.PodSecurityContext{RunAsNonRoot: &yes}, config: testImageConfig("101"), runAs: "101:-"},
+		{name: "runAsNonRoot with a runAsUser", container: &corev1.SecurityContext{RunAsNonRoot: &yes}, uid: int64(1000), config: testImageConfig(""), runAs: "1000:-"},
+		// which can't be checked, and is left to the kubelet's equivalent, the warning
+		{name: "runAsNonRoot, not inspected", container: &corev1.SecurityContext{RunAsNonRoot: &yes}, runAs: "-:-"},
+		{
+			name:      "runAsNonRoot with an image running as root",
+			container: &corev1.SecurityContext{RunAsNonRoot: &yes},
+			config:    testImageConfig(""),
+			err:       "container web has runAsNonRoot and its image runs as root",
+		},
+		{
+			name:   "runAsNonRoot with an image running as uid 0",
+			pod:    &corev1.PodSecurityContext{RunAsNonRoot: &yes},
+			config: testImageConfig("0:0"),
+			err:    "container web has runAsNonRoot and its image runs as root",
+		},
+		{
+			name:   "runAsNonRoot with a user by name",
+			pod:    &corev1.PodSecurityContext{RunAsNonRoot: &yes},
+			config: testImageConfig("nginx"),
+			err:    "container web has runAsNonRoot and its image has the non-numeric user nginx, which can't be checked",
+		},
+		{
+			name:   "runAsNonRoot with runAsUser 0",
+			pod:    &corev1.PodSecurityContext{RunAsNonRoot: &yes, RunAsUser: &root},
+			config: testImageConfig("101"),
+			err:    "container web has runAsNonRoot and runAsUser 0",
+		},
+	} {
+		spec := &corev1.PodSpec{SecurityContext: test.pod}
+		container := &corev1.Container{Name: "web", SecurityContext: test.container}
+		image := &linuxkit.Image{Name: "container-web"}
+		if test.uid != nil {
+			uid := test.uid
+			image.UID = &uid
+		}
+
+		err := applyImageUser(spec, container, image, test.config)
+		if test.err != "" {
+			if err == nil || err.Error() != test.err {
+				t.Errorf("%s: got %v, want %s", test.name, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+
+		id := func(v *interface{}) string {
+			if v == nil {
+				return "-"
+			}
+			return fmt.Sprint(*v)
+		}
+		if runAs := id(image.UID) + ":" + id(image.GID); runAs != test.runAs {
+			t.Errorf("%s: runs as %s, want %s", test.name, runAs, test.runAs)
+		}
+	}
+}
+
+func TestInspectedContainer(t *testing.T) {
+	defer func() { imageInspector, imageConfigs = nil, map[string]*resolver.ImageConfig{} }()
+
+	imageInspector = &resolver.Inspector{
+		Source: newStubImages(t, map[string]*resolver.ImageConfig{
+			"docker.io/library/nginx:1.15.4":   testImageConfig("101:101"),
+			"docker.io/library/busybox:latest": testImageConfig(""),
+		}),
+		Platform: inspectPlatform,
+	}
+	imageConfigs = map[string]*resolver.ImageConfig{}
+
+	yes := true
+	var root int64
+	for _, test := range []struct {
+		name      string
+		container corev1.Container
+		// what the container runs, and how
+		command, env, cwd, runAs string
+		err                      string
+	}{
+		{
+			name:      "what the image says",
+			container: corev1.Container{Image: "nginx:1.15.4"},
+			env:       "PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin NGINX_VERSION=1.15.4",
+			cwd:       "/usr/share/nginx",
+			runAs:     "101:101",
+		},
+		{
+			name: "what the pod says",
+			container: corev1.Container{
+				Image:           "nginx:1.15.4",
+				Args:            []string{"nginx-debug", "-g", "daemon off;"},
+				Env:             []corev1.EnvVar{{Name: "NGINX_VERSION", Value: "1.15.5"}},
+				WorkingDir:      "/srv",
+				SecurityContext: &corev1.SecurityContext{RunAsUser: &root},
+			},
+			command: "/docker-entrypoint.sh nginx-debug -g daemon off;",
+			env:     "PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin NGINX_VERSION=1.15.5",
+			cwd:     "/srv",
+			runAs:   "0:-",
+		},
+		{
+			name:      "runAsNonRoot, with an image running as root",
+			container: corev1.Container{Image: "busybox", SecurityContext: &corev1.SecurityContext{RunAsNonRoot: &yes}},
+			err:       "container web has runAsNonRoot and its image runs as root",
+		},
+		{
+			name:      "runAsNonRoot, with an image of its own user",
+			container: corev1.Container{Image: "nginx:1.15.4", SecurityContext: &corev1.SecurityContext{RunAsNonRoot: &yes}},
+			env:       "PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin NGINX_VERSION=1.15.4",
+			cwd:       "/usr/share/nginx",
+			runAs:     "101:101",
+		},
+	} {
+		test.container.Name = "web"
+		pod := newPodContext(&corev1.PodTemplateSpec{Spec: corev1.PodSpec{Containers: []corev1.Container{test.container}}}, nil, nil)
+
+		image, err := containerToLinuxKitImage(pod, test.container)
+		if test.err != "" {
+			if err == nil || err.Error() != test.err {
+				t.Errorf("%s: got %v, want %s", test.name, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+
+		command, env := "", ""
+		if image.Command != nil {
+			command = strings.Join(*image.Command, " ")
+		}
+		if image.Env != nil {
+			env = strings.Join(*image.Env, " ")
+		}
+		runAs := "-:-"
+		if image.UID != nil {
+			runAs = fmt.Sprint(*image.UID) + ":-"
+			if image.GID != nil {
+				runAs = fmt.Sprintf("%v:%v", *image.UID, *image.GID)
+			}
+		}
+		if command != test.command || env != test.env || image.Cwd != test.cwd || runAs != test.runAs {
+			t.Errorf("%s: runs %q with env %q in %q as %s, want %q with %q in %q as %s", test.name, command, env, image.Cwd, runAs, test.command, test.env, test.cwd, test.runAs)
+		}
+	}
+}
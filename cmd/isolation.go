@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"github.com/tjfontaine/podspec2linuxkit/pkg/linuxkit"
+	corev1 "k8s.io/api/core/v1"
+	"path"
+	"strings"
+)
+
+// where each merged pod's sandbox binds the namespaces its containers join
+const podNamespacesDir = "/run/podspec2linuxkit/pods"
+
+// the cgroup merged pods are created under, each pod's containers nested in its own
+const podCgroupsParent = "/podspec2linuxkit"
+
+// podNamespace is the containerd namespace a merged pod's services run in
+func podNamespace(pod string) string {
+	return fmt.Sprintf("pod-%s", pod)
+}
+
+// podCgroupScript limits the pod cgroup to the sum of its containers' limits, when each of them has one
+func podCgroupScript(members []*linuxkit.Image, cgroup string) string {
+	var memory int64
+	var shares uint64
+	memoryLimited := true
+	for _, member := range members {
+		resources := member.Resources
+		if resources == nil || resources.Memory == nil || resources.Memory.Limit == nil {
+			memoryLimited = false
+		} else {
+			memory += *resources.Memory.Limit
+		}
+
+		if resources != nil && resources.CPU != nil && resources.CPU.Shares != nil {
+			shares += *resources.CPU.Shares
+		}
+	}
+
+	var script strings.Builder
+	limit := func(controller string, file string, value interface{}) {
+		dir := shellQuote(path.Join(hostRoot, "sys/fs/cgroup", controller, cgroup))
+		fmt.Fprintf(&script, "mkdir -p %s && echo %v > %s/%s || fail could not set %s of %s\n", dir, value, dir, file, file, cgroup)
+	}
+	if memoryLimited {
+		limit("memory", "memory.limit_in_bytes", memory)
+	}
+	if shares > 0 {
+		limit("cpu", "cpu.shares", shares)
+	}
+
+	return script.String()
+}
+
+// isolatePod keeps a pod which shares its image with others to itself: its containers run in their own containerd
+// namespace, nested in a pod cgroup, and share ipc and uts namespaces only among themselves. Their network is the
+// VM's, shared with every other pod, so they can reach each other and have to listen on different ports.
+//
+// LinuxKit leaves the order services start in undefined, so what the pod's containers join is set up by the returned
+// onboot step, which runs before any of them: the namespaces, bound under podNamespacesDir so they outlive it, with
+// the pod's hostname, and the limits of the pod cgroup. A shared pid namespace would need a process to keep it alive
+// until the containers join it, which nothing can start before them.
+func isolatePod(members []*linuxkit.Image, pod string, spec *corev1.PodSpec) (*linuxkit.Image, error) {
+	if !spec.HostPID && spec.ShareProcessNamespace != nil && *spec.ShareProcessNamespace {
+		return nil, fmt.Errorf("pod %s has shareProcessNamespace, which a pod merged with others can't have", pod)
+	}
+
+	namespace := podNamespace(pod)
+	dir := path.Join(podNamespacesDir, pod)
+	cgroup := path.Join(podCgroupsParent, pod)
+	ipc, uts := path.Join(dir, "ipc"), path.Join(dir, "uts")
+
+	for _, member := range members {
+		if member.Runtime == nil {
+			member.Runtime = &linuxkit.Runtime{}
+		}
+		member.Runtime.Namespace = &namespace
+		memberCgroup := path.Join(cgroup, member.Name)
+		member.CgroupsPath = &memberCgroup
+		if !spec.HostIPC {
+			member.Ipc = ipc
+		}
+		// the hostname is the pod's, through the uts namespace
+		member.Uts = uts
+		member.Hostname = ""
+	}
+
+	hostname := pod
+	if spec.Hostname != "" {
+		hostname = spec.Hostname
+		if spec.Subdomain != "" {
+			hostname = fmt.Sprintf("%s.%s", spec.Hostname, spec.Subdomain)
+		}
+	}
+
+	image := bootScriptImage(fmt.Sprintf("pod-%s", pod), podCgroupScript(members, cgroup))
+	image.Ipc = "new"
+	image.Uts = "new"
+	image.Hostname = hostname
+	image.Runtime = &linuxkit.Runtime{
+		Mkdir: &[]string{dir},
+		BindNS: linuxkit.Namespaces{
+			Ipc: &ipc,
+			Uts: &uts,
+		},
+	}
+
+	return image, nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"github.com/tjfontaine/podspec2linuxkit/pkg/linuxkit"
+	corev1 "k8s.io/api/core/v1"
+	"strings"
+	"testing"
+)
+
+func TestIsolatePod(t *testing.T) {
+	memory := int64(64 << 20)
+	shares := uint64(512)
+	members := []*linuxkit.Image{
+		{Name: "container-web_nginx"},
+		{Name: "container-web_sidecar"},
+	}
+	for _, member := range members {
+		member.Hostname = "web"
+		member.Resources = &linuxkit.LinuxResources{
+			Memory: &linuxkit.LinuxMemory{Limit: &memory},
+			CPU:    &linuxkit.LinuxCPU{Shares: &shares},
+		}
+	}
+
+	sandbox, err := isolatePod(members, "web", &corev1.PodSpec{Hostname: "www", Subdomain: "frontend"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// an onboot step, which runs before every service, sets up what they join
+	if sandbox.Name != "pod-web" || sandbox.Ipc != "new" || sandbox.Uts != "new" || sandbox.Hostname != "www.frontend" {
+		t.Errorf("got sandbox %s with ipc %q, uts %q and hostname %q", sandbox.Name, sandbox.Ipc, sandbox.Uts, sandbox.Hostname)
+	}
+	ns := sandbox.Runtime.BindNS
+	if ns.Ipc == nil || *ns.Ipc != "/run/podspec2linuxkit/pods/web/ipc" || ns.Uts == nil || *ns.Uts != "/run/podspec2linuxkit/pods/web/uts" || ns.Pid != nil || ns.Net != nil {
+		t.Errorf("got bound namespaces %+v, want ipc and uts only", ns)
+	}
+	script := (*sandbox.Command)[2]
+	for _, want := range []string{
+		"echo 134217728 > '/host/sys/fs/cgroup/memory/podspec2linuxkit/web'/memory.limit_in_bytes",
+		"echo 1024 > '/host/sys/fs/cgroup/cpu/podspec2linuxkit/web'/cpu.shares",
+	} {
+		if !strings.Contains(script, want) {
+			t.Errorf("got script:\n%s\nwant %q", script, want)
+		}
+	}
+
+	for _, member := range members {
+		if *member.Runtime.Namespace != "pod-web" || *member.CgroupsPath != "/podspec2linuxkit/web/"+member.Name ||
+			member.Ipc != *ns.Ipc || member.Uts != *ns.Uts || member.Hostname != "" || member.Net != "" || member.Pid != "" {
+			t.Errorf("%s: got %+v", member.Name, member)
+		}
+	}
+
+	// without a limit on each container, the pod's memory isn't limited
+	members[1].Resources = nil
+	sandbox, _ = isolatePod(members, "web", &corev1.PodSpec{HostIPC: true})
+	if script := (*sandbox.Command)[2]; strings.Contains(script, "memory.limit_in_bytes") || !strings.Contains(script, "echo 512 >") {
+		t.Errorf("got script:\n%s\nwant cpu shares only", script)
+	}
+	if sandbox.Hostname != "web" || members[0].Ipc != *ns.Ipc {
+		t.Errorf("got hostname %q and ipc %q, want web and the one bound before", sandbox.Hostname, members[0].Ipc)
+	}
+
+	share := true
+	if _, err := isolatePod(members, "web", &corev1.PodSpec{ShareProcessNamespace: &share}); err == nil {
+		t.Errorf("expected an error for a pod sharing its process namespace")
+	}
+}
+
+func TestPrefixPodSeparator(t *testing.T) {
+	// pod web's nginx container and pod web-nginx's app container
+	web := &corev1.PodSpec{Containers: []corev1.Container{{Name: "nginx"}}, Volumes: []corev1.Volume{{Name: "nginx-data"}}}
+	webNginx := &corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}, Volumes: []corev1.Volume{{Name: "data"}}}
+	prefixPod(web, map[string]interface{}{}, "web")
+	prefixPod(webNginx, map[string]interface{}{}, "web-nginx")
+
+	if web.Volumes[0].Name == webNginx.Volumes[0].Name {
+		t.Errorf("both pods have volume %s", web.Volumes[0].Name)
+	}
+	if web.Containers[0].Name != "web_nginx" || webNginx.Containers[0].Name != "web-nginx_app" {
+		t.Errorf("got containers %s and %s, want web_nginx and web-nginx_app", web.Containers[0].Name, webNginx.Containers[0].Name)
+	}
+}
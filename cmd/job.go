@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"github.com/tjfontaine/podspec2linuxkit/pkg/linuxkit"
+	batchv1 "k8s.io/api/batch/v1"
+	"strings"
+)
+
+// the exit status a job reports when activeDeadlineSeconds was exceeded, the same as timeout(1)
+const jobDeadlineExitStatus = 124
+
+// the backoffLimit of a Job that doesn't set one
+const defaultBackoffLimit = 6
+
+// where a finished job leaves its exit status, in <job>.status, on the disk holding /var/lib when there is one
+const jobStatusDir = "/var/lib/podspec2linuxkit/jobs"
+
+// jobWatcherScript waits for the job's containers to complete, restarting those which fail until backoffLimit is
+// reached, and then powers off the VM. The job's exit status is logged to the console and written to jobStatusDir
+// wherever it runs, and only becomes the exit code of the VM itself under qemu. The containers are ordinary LinuxKit
+// services, so they are watched and restarted through the host's containerd.
+var jobWatcherScript = `ctr() {
+  chroot ` + hostRoot + ` /usr/bin/ctr --namespace "$namespace" "$@"
+}
+
+uptime() {
+  cut -d. -f1 /proc/uptime
+}
+
+finish() {
+  echo "podspec2linuxkit: job $job finished with exit status $1" | tee /dev/console >&2
+  mkdir -p ` + hostRoot + jobStatusDir + ` && echo $1 > ` + hostRoot + jobStatusDir + `/$job.status
+  if [ "$1" != 0 ] && [ -c ` + hostRoot + `/dev/port ]; then
+    # qemu's isa-debug-exit device (iobase=0xf4) makes the emulator exit with (status << 1) | 1
+    printf "\\$(printf %o $1)" | dd of=` + hostRoot + `/dev/port bs=1 seek=244 count=1 2>/dev/null
+  fi
+  sync
+  poweroff -f
+  exit $1
+}
+
+stop_all() {
+  for c in $containers; do
+    ctr task kill --signal SIGKILL $c >/dev/null 2>&1
+  done
+}
+
+failures=0
+state=/tmp/job
+mkdir -p $state
+while :; do
+  now=$(uptime)
+  if [ $deadline -gt 0 ] && [ $now -ge $deadline ]; then
+    echo "podspec2linuxkit: job $job exceeded its deadline" | tee /dev/console >&2
+    stop_all
+    finish ` + fmt.Sprint(jobDeadlineExitStatus) + `
+  fi
+
+  pending=0
+  for c in $containers; do
+    [ -e $state/$c.done ] && continue
+    pending=1
+    case "$(ctr task ls | awk -v id=$c '$1 == id { print $3 }')" in
+      STOPPED)
+        ctr task delete $c >/dev/null 2>&1
+        status=$?
+        if [ $status = 0 ]; then
+          touch $state/$c.done
+          continue
+        fi
+        failures=$((failures+1))
+        echo "podspec2linuxkit: job $job container $c failed with exit status $status ($failures failures)" | tee /dev/console >&2
+        if [ $failures -gt $backoff_limit ]; then
+          stop_all
+          finish $status
+        fi
+        # back off like the job controller does: 10s, doubling up to 6 minutes
+        delay=$((10 << (failures - 1)))
+        [ $delay -gt 360 ] && delay=360
+        echo $((now + delay)) > $state/$c.retry
+        ;;
+      "")
+        # either not started yet, or waiting to be retried
+        if [ -e $state/$c.retry ] && [ $now -ge $(cat $state/$c.retry) ]; then
+          rm $state/$c.retry
+          ctr container delete $c >/dev/null 2>&1
+          chroot ` + hostRoot + ` /usr/bin/service start $c || fail "couldn't restart $c"
+        fi
+        ;;
+    esac
+  done
+
+  [ $pending = 0 ] && finish 0
+  sleep 1
+done
+`
+
+// jobWatcherImage returns the service which runs a job's containers to completion and powers off the VM when it is
+// done, with an exit status that can be observed from outside it
+func jobWatcherImage(name string, namespace string, containers []string, job *batchv1.JobSpec) *linuxkit.Image {
+	backoffLimit := int32(defaultBackoffLimit)
+	if job.BackoffLimit != nil {
+		backoffLimit = *job.BackoffLimit
+	}
+
+	// without anywhere better to count from, the deadline runs from boot
+	deadline := int64(0)
+	if job.ActiveDeadlineSeconds != nil {
+		deadline = *job.ActiveDeadlineSeconds
+	}
+
+	if job.Completions != nil && *job.Completions > 1 || job.Parallelism != nil && *job.Parallelism > 1 {
+		log.Warnf("job %s: each VM runs a single pod to completion, start as many as completions and parallelism need", name)
+	}
+
+	script := fmt.Sprintf("job=%s\nnamespace=%s\ncontainers=%s\nbackoff_limit=%d\ndeadline=%d\n", shellQuote(name), shellQuote(namespace), shellQuote(strings.Join(containers, " ")), backoffLimit, deadline)
+
+	image := bootScriptImage(fmt.Sprintf("job-%s", name), script+jobWatcherScript)
+	// restarting containers means creating them through containerd, and reporting the status needs /dev/port
+	image.Capabilities = &[]string{"all"}
+
+	return image
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"fmt"
+	"github.com/tjfontaine/podspec2linuxkit/pkg/linuxkit"
+	"io/ioutil"
+	batchv1 "k8s.io/api/batch/v1"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// fakeJobHost stands in for the clock, the host's containerd and service, and poweroff, for a job watcher. Each
+// container exits in turn with the statuses in <container>.exits, one a line, and "run" keeps it running. What the
+// watcher does to the containers and the VM is kept in calls, each line starting with the time it was done at.
+const fakeJobHost = `now() {
+  cut -d. -f1 $fake/uptime
+}
+
+fake_start() {
+  fake_next=$(head -n 1 $fake/$1.exits)
+  sed -i 1d $fake/$1.exits
+  if [ "$fake_next" = run ]; then
+    echo RUNNING > $fake/$1.task
+  else
+    echo STOPPED > $fake/$1.task
+    echo $fake_next > $fake/$1.code
+  fi
+}
+
+fake_ctr() {
+  case "$1 $2" in
+  "task ls")
+    echo "TASK PID STATUS"
+    for fake_task in $fake/*.task; do
+      [ -e $fake_task ] || continue
+      fake_name=${fake_task##*/}
+      echo "${fake_name%.task} 1 $(cat $fake_task)"
+    done
+    return 0
+    ;;
+  esac
+
+  echo "$(now) ctr $*" >> $fake/calls
+  case "$1 $2" in
+  "task delete") rm $fake/$3.task; return $(cat $fake/$3.code) ;;
+  "task kill") rm -f $fake/$5.task ;;
+  esac
+}
+
+chroot() {
+  case $2 in
+  /usr/bin/ctr) shift 4; fake_ctr "$@" ;;
+  /usr/bin/service) echo "$(now) service $3 $4" >> $fake/calls; fake_start $4 ;;
+  esac
+}
+
+sleep() {
+  [ $(now) -gt 1000 ] && exit 99
+  echo $(($(now) + $1)).00 > $fake/uptime
+}
+
+poweroff() {
+  echo "$(now) poweroff $*" >> $fake/calls
+}
+
+sync() {
+  :
+}
+`
+
+// runJobWatcher runs a job watcher under /bin/sh, with the host faked in a directory, from 5s after boot, and returns
+// its exit status. Under qemu, a file stands in for the I/O ports.
+func runJobWatcher(image *linuxkit.Image, fake string, exits map[string]string, qemu bool) (int, error) {
+	if err := os.MkdirAll(path.Join(fake, "root", "dev"), 0755); err != nil {
+		return 0, err
+	}
+	if qemu {
+		ioutil.WriteFile(path.Join(fake, "root", "dev", "port"), nil, 0644)
+	}
+	ioutil.WriteFile(path.Join(fake, "uptime"), []byte("5.00 1.00\n"), 0644)
+
+	// the containers were started at boot, as LinuxKit services
+	started := ""
+	for container, statuses := range exits {
+		ioutil.WriteFile(path.Join(fake, container+".exits"), []byte(statuses+"\n"), 0644)
+		started += "fake_start " + container + "\n"
+	}
+
+	script := strings.NewReplacer(
+		"/proc/uptime", fake+"/uptime",
+		"/dev/console", fake+"/console",
+		"state=/tmp/job", "state="+fake+"/state",
+		"[ -c "+hostRoot+"/dev/port ]", "[ -f "+fake+"/root/dev/port ]",
+		hostRoot+"/", fake+"/root/",
+	).Replace((*image.Command)[2])
+
+	err := exec.Command("/bin/sh", "-c", "fake="+fake+"\n"+fakeJobHost+started+script).Run()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return exitErr.Sys().(syscall.WaitStatus).ExitStatus(), nil
+	}
+	return 0, err
+}
+
+func TestJobWatcher(t *testing.T) {
+	one := int32(1)
+	deadline := int64(30)
+
+	for _, test := range []struct {
+		name       string
+		job        batchv1.JobSpec
+		containers []string
+		exits      map[string]string
+		// whether the VM runs under qemu, with the isa-debug-exit device
+		qemu bool
+
+		status int
+		// when the containers were restarted, and whether the job killed them
+		restarts []string
+		killed   bool
+	}{
+		{
+			name:       "succeeds",
+			containers: []string{"migrate", "report"},
+			exits:      map[string]string{"migrate": "0", "report": "0"},
+			qemu:       true,
+		},
+		{
+			name:       "retries with a backoff doubling from 10s",
+			containers: []string{"migrate", "report"},
+			exits:      map[string]string{"migrate": "1\n1\n1\n0", "report": "0"},
+			restarts:   []string{"15 service start migrate", "36 service start migrate", "77 service start migrate"},
+		},
+		{
+			name:       "fails past backoffLimit",
+			job:        batchv1.JobSpec{BackoffLimit: &one},
+			containers: []string{"migrate", "report"},
+			exits:      map[string]string{"migrate": "3\n2", "report": "run"},
+			qemu:       true,
+			status:     2,
+			restarts:   []string{"15 service start migrate"},
+			killed:     true,
+		},
+		{
+			name:       "fails past backoffLimit, not under qemu",
+			job:        batchv1.JobSpec{BackoffLimit: &one},
+			containers: []string{"migrate"},
+			exits:      map[string]string{"migrate": "3\n2"},
+			status:     2,
+			restarts:   []string{"15 service start migrate"},
+			killed:     true,
+		},
+		{
+			name:       "exceeds activeDeadlineSeconds",
+			job:        batchv1.JobSpec{ActiveDeadlineSeconds: &deadline},
+			containers: []string{"migrate"},
+			exits:      map[string]string{"migrate": "run"},
+			qemu:       true,
+			status:     jobDeadlineExitStatus,
+			killed:     true,
+		},
+	} {
+		fake, err := ioutil.TempDir("", "job")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(fake)
+
+		image := jobWatcherImage("db-migrate", servicesNamespace, test.containers, &test.job)
+		status, err := runJobWatcher(image, fake, test.exits, test.qemu)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+
+		console, _ := ioutil.ReadFile(path.Join(fake, "console"))
+		contents, _ := ioutil.ReadFile(path.Join(fake, "calls"))
+		calls := strings.Split(strings.TrimSpace(string(contents)), "\n")
+		if status != test.status || !strings.Contains(string(console), fmt.Sprintf("podspec2linuxkit: job db-migrate finished with exit status %d\n", test.status)) ||
+			!strings.HasSuffix(calls[len(calls)-1], " poweroff -f") {
+			t.Errorf("%s: exited with %d, want %d, after:\n%s\nand logging:\n%s", test.name, status, test.status, contents, console)
+			continue
+		}
+
+		restarts := []string{}
+		killed := false
+		for _, call := range calls {
+			if strings.Contains(call, " service start ") {
+				restarts = append(restarts, call)
+			}
+			killed = killed || strings.Contains(call, " ctr task kill --signal SIGKILL ")
+		}
+		if strings.Join(restarts, "\n") != strings.Join(test.restarts, "\n") || killed != test.killed {
+			t.Errorf("%s: got restarts %q and killed %t, want %q and %t", test.name, restarts, killed, test.restarts, test.killed)
+		}
+
+		// the status is kept wherever the job ran
+		if written, err := ioutil.ReadFile(path.Join(fake, "root", jobStatusDir, "db-migrate.status")); err != nil || string(written) != fmt.Sprintf("%d\n", test.status) {
+			t.Errorf("%s: got status file %q (%v)", test.name, written, err)
+		}
+
+		// and becomes qemu's, through isa-debug-exit at 0xf4, when the job failed
+		port, _ := ioutil.ReadFile(path.Join(fake, "root", "dev", "port"))
+		switch {
+		case !test.qemu:
+			if port != nil {
+				t.Errorf("%s: wrote %v to the I/O ports without qemu", test.name, port)
+			}
+		case test.status == 0:
+			if len(port) != 0 {
+				t.Errorf("%s: wrote %v to the I/O ports, though it succeeded", test.name, port)
+			}
+		case len(port) != 0xf4+1 || port[0xf4] != byte(test.status):
+			t.Errorf("%s: wrote %v to the I/O ports, want %d at 0xf4", test.name, port, test.status)
+		}
+	}
+}
+
+func TestJobWatcherImage(t *testing.T) {
+	script := (*jobWatcherImage("db-migrate", servicesNamespace, []string{"migrate", "report"}, &batchv1.JobSpec{}).Command)[2]
+	if !strings.Contains(script, "job='db-migrate'\nnamespace='services.linuxkit'\ncontainers='migrate report'\nbackoff_limit=6\ndeadline=0\n") {
+		t.Errorf("got script:\n%s\nwant the default backoffLimit and no deadline", script)
+	}
+}
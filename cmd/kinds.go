@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"strings"
+)
+
+// kindConfig says where the pod template of a kind is, for kinds without an entry in GroupMap, like CRDs
+type kindConfig struct {
+	APIVersion string `yaml:"apiVersion"`
+	Kind       string `yaml:"kind"`
+	// path to a PodTemplateSpec, e.g. spec.template
+	Template string `yaml:"template,omitempty"`
+	// or to a PodSpec, whose metadata is the object's own
+	Spec string `yaml:"spec,omitempty"`
+}
+
+// extraKinds are the kinds registered with --kinds, by apiVersion/kind
+var extraKinds = map[string]kindConfig{}
+
+// where other kinds usually keep their pod template, tried in order
+var defaultKinds = []kindConfig{
+	{Template: "spec.template"},
+	{Template: "spec.jobTemplate.spec.template"},
+	{Template: "template"},
+	{Spec: "spec"},
+}
+
+func loadKinds(file string) error {
+	contents, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	kinds := []kindConfig{}
+	if err := yaml.UnmarshalStrict(contents, &kinds); err != nil {
+		return err
+	}
+
+	for _, kind := range kinds {
+		if kind.APIVersion == "" || kind.Kind == "" {
+			return fmt.Errorf("kinds need an apiVersion and a kind")
+		}
+		if (kind.Template == "") == (kind.Spec == "") {
+			return fmt.Errorf("%s/%s needs either a template or a spec path", kind.APIVersion, kind.Kind)
+		}
+		extraKinds[kind.APIVersion+"/"+kind.Kind] = kind
+	}
+
+	return nil
+}
+
+// fieldPath splits a path like spec.template.spec, or .spec.template.spec, into its fields
+func fieldPath(path string) []string {
+	return strings.Split(strings.TrimPrefix(path, "."), ".")
+}
+
+// unstructuredWorkload finds the pod template of an object where kind says it is, returning nil if it isn't there
+func unstructuredWorkload(obj map[string]interface{}, kind kindConfig) (*workload, error) {
+	meta := metav1.ObjectMeta{}
+	if rawMeta, ok, _ := unstructured.NestedMap(obj, "metadata"); ok {
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(rawMeta, &meta); err != nil {
+			return nil, fmt.Errorf("metadata: %v", err)
+		}
+	}
+
+	path := kind.Spec
+	if kind.Template != "" {
+		path = kind.Template + ".spec"
+	}
+	specPath := fieldPath(path)
+
+	rawSpec, ok, _ := unstructured.NestedMap(obj, specPath...)
+	if !ok {
+		return nil, nil
+	}
+	if _, hasContainers := rawSpec["containers"]; !hasContainers {
+		return nil, nil
+	}
+
+	template := corev1.PodTemplateSpec{}
+	if kind.Template != "" {
+		rawTemplate, _, _ := unstructured.NestedMap(obj, fieldPath(kind.Template)...)
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(rawTemplate, &template); err != nil {
+			return nil, fmt.Errorf("%s: %v", kind.Template, err)
+		}
+	} else if err := runtime.DefaultUnstructuredConverter.FromUnstructured(rawSpec, &template.Spec); err != nil {
+		return nil, fmt.Errorf("%s: %v", kind.Spec, err)
+	}
+
+	// some CRDs, like Knative's, default the name of the container
+	for idx := range template.Spec.Containers {
+		if template.Spec.Containers[idx].Name == "" {
+			template.Spec.Containers[idx].Name = "user-container"
+			if idx > 0 {
+				template.Spec.Containers[idx].Name = fmt.Sprintf("user-container-%d", idx)
+			}
+		}
+	}
+
+	w := podWorkload(meta, template)
+	w.rawSpec = specPath
+	return w, nil
+}
+
+// lookupUnstructured finds the pod template of a kind without an entry in GroupMap, where --kinds says it is or in
+// one of the usual places
+func lookupUnstructured(obj map[string]interface{}) (*workload, error) {
+	key := fmt.Sprintf("%s/%s", obj["apiVersion"], obj["kind"])
+
+	if kind, ok := extraKinds[key]; ok {
+		w, err := unstructuredWorkload(obj, kind)
+		if err == nil && w == nil {
+			err = fmt.Errorf("no pod spec at %s%s", kind.Template, kind.Spec)
+		}
+		return w, err
+	}
+
+	for _, kind := range defaultKinds {
+		w, err := unstructuredWorkload(obj, kind)
+		if err != nil {
+			return nil, err
+		}
+		if w != nil {
+			log.Infof("%s isn't a known kind, using the pod spec found at %s%s", key, kind.Template, kind.Spec)
+			return w, nil
+		}
+	}
+
+	return nil, notWorkloadError(key)
+}
+
+// notWorkloadError is returned for objects without a pod template, like a Service or ConfigMap, which a stream of
+// manifests can have alongside its workloads
+type notWorkloadError string
+
+func (err notWorkloadError) Error() string {
+	return fmt.Sprintf("%s isn't a known kind, and has no pod spec where one is usually found, see --kinds", string(err))
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestLoadKinds(t *testing.T) {
+	defer func() { extraKinds = map[string]kindConfig{} }()
+
+	if err := loadKinds("../examples/kinds.yaml"); err != nil {
+		t.Fatal(err)
+	}
+	if kind := extraKinds["serving.knative.dev/v1/Service"]; kind.Template != "spec.template" || len(extraKinds) != 4 {
+		t.Errorf("got kinds %v", extraKinds)
+	}
+
+	dir, err := ioutil.TempDir("", "kinds")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := loadKinds(path.Join(dir, "missing.yaml")); err == nil {
+		t.Errorf("expected an error for a missing file")
+	}
+	for _, kinds := range []string{
+		"- kind: Rollout\n  template: spec.template\n",
+		"- apiVersion: argoproj.io/v1alpha1\n  template: spec.template\n",
+		"- apiVersion: argoproj.io/v1alpha1\n  kind: Rollout\n",
+		"- apiVersion: argoproj.io/v1alpha1\n  kind: Rollout\n  template: spec.template\n  spec: spec.template.spec\n",
+		"- apiVersion: argoproj.io/v1alpha1\n  kind: Rollout\n  podTemplate: spec.template\n",
+		"apiVersion: argoproj.io/v1alpha1\n",
+	} {
+		file := path.Join(dir, "kinds.yaml")
+		if err := ioutil.WriteFile(file, []byte(kinds), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := loadKinds(file); err == nil {
+			t.Errorf("%q: expected an error", kinds)
+		}
+	}
+}
+
+func TestUnstructuredWorkload(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		manifest string
+		kind     kindConfig
+		// the workload found, if any
+		workload string
+		err      bool
+	}{
+		{
+			name:     "a template, with metadata of its own",
+			manifest: `{apiVersion: argoproj.io/v1alpha1, kind: Rollout, metadata: {name: web, namespace: shop}, spec: {template: {metadata: {name: web-canary}, spec: {containers: [{name: nginx, image: nginx}]}}}}`,
+			kind:     kindConfig{Template: "spec.template"},
+			workload: "shop/web-canary nginx at spec.template.spec",
+		},
+		{
+			name:     "a bare pod spec, with the object's metadata",
+			manifest: `{apiVersion: example.com/v1, kind: Worker, metadata: {name: worker, labels: {app: worker}}, spec: {pod: {containers: [{name: worker, image: busybox}]}}}`,
+			kind:     kindConfig{Spec: ".spec.pod"},
+			workload: "/worker worker at spec.pod",
+		},
+		{
+			name:     "Knative's unnamed containers",
+			manifest: `{apiVersion: serving.knative.dev/v1, kind: Service, metadata: {name: hello}, spec: {template: {spec: {containers: [{image: helloworld}, {image: sidecar}, {name: proxy, image: envoy}]}}}}`,
+			kind:     kindConfig{Template: "spec.template"},
+			workload: "/hello user-container,user-container-1,proxy at spec.template.spec",
+		},
+		{
+			name:     "nothing at the path",
+			manifest: `{apiVersion: example.com/v1, kind: Worker, metadata: {name: worker}, spec: {pod: {containers: [{name: worker, image: busybox}]}}}`,
+			kind:     kindConfig{Template: "spec.template"},
+		},
+		{
+			name:     "something without containers at the path",
+			manifest: `{apiVersion: v1, kind: Service, metadata: {name: web}, spec: {ports: [{port: 80}]}}`,
+			kind:     kindConfig{Spec: "spec"},
+		},
+		{
+			name:     "an invalid pod spec",
+			manifest: `{apiVersion: example.com/v1, kind: Worker, metadata: {name: worker}, spec: {containers: [{name: worker, ports: 80}]}}`,
+			kind:     kindConfig{Spec: "spec"},
+			err:      true,
+		},
+	} {
+		objects, err := decodeObjects([]byte(test.manifest))
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+
+		w, err := unstructuredWorkload(objects[0], test.kind)
+		if test.err {
+			if err == nil {
+				t.Errorf("%s: expected an error", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		description := ""
+		if w != nil {
+			description = describeWorkload(w)
+		}
+		if description != test.workload {
+			t.Errorf("%s: got %q, want %q", test.name, description, test.workload)
+		}
+	}
+}
+
+func TestLookupUnstructured(t *testing.T) {
+	defer func() { extraKinds = map[string]kindConfig{} }()
+
+	if err := loadKinds("../examples/kinds.yaml"); err != nil {
+		t.Fatal(err)
+	}
+	extraKinds["example.com/v1/Worker"] = kindConfig{APIVersion: "example.com/v1", Kind: "Worker", Spec: "spec.pod"}
+
+	for _, test := range []struct {
+		manifest string
+		workload string
+		err      string
+	}{
+		// a kind from the file
+		{
+			manifest: `{apiVersion: serving.knative.dev/v1, kind: Service, metadata: {name: hello}, spec: {template: {spec: {containers: [{image: helloworld}]}}}}`,
+			workload: "/hello user-container at spec.template.spec",
+		},
+		// named like a kind the decoder knows, but of another group
+		{
+			manifest: `{apiVersion: apps.kruise.io/v1beta1, kind: StatefulSet, metadata: {name: db}, spec: {replicas: 3, template: {spec: {containers: [{name: postgres, image: postgres}]}}}}`,
+			workload: "/db postgres at spec.template.spec",
+		},
+		{
+			manifest: `{apiVersion: example.com/v1, kind: Worker, metadata: {name: worker}, spec: {template: {spec: {containers: [{name: worker, image: busybox}]}}}}`,
+			err:      "no pod spec at spec.pod",
+		},
+		// kinds which aren't in the file are looked for in the usual places
+		{
+			manifest: `{apiVersion: example.com/v1, kind: Batch, metadata: {name: batch}, spec: {jobTemplate: {spec: {template: {spec: {containers: [{name: run, image: busybox}]}}}}}}`,
+			workload: "/batch run at spec.jobTemplate.spec.template.spec",
+		},
+		{
+			manifest: `{apiVersion: example.com/v1, kind: Sandbox, metadata: {name: sandbox}, spec: {containers: [{name: shell, image: busybox}]}}`,
+			workload: "/sandbox shell at spec",
+		},
+		{
+			manifest: `{apiVersion: example.com/v1, kind: Config, metadata: {name: config}, data: {key: value}}`,
+			err:      "example.com/v1/Config isn't a known kind, and has no pod spec where one is usually found, see --kinds",
+		},
+	} {
+		objects, err := decodeObjects([]byte(test.manifest))
+		if err != nil {
+			t.Fatalf("%s: %v", test.manifest, err)
+		}
+
+		// through lookupWorkload, which leaves unknown kinds to lookupUnstructured
+		w, err := lookupWorkload(objects[0])
+		if test.err != "" {
+			if err == nil || err.Error() != test.err {
+				t.Errorf("%s: got %v, want %s", test.manifest, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.manifest, err)
+			continue
+		}
+		if description := describeWorkload(w); description != test.workload {
+			t.Errorf("%s: got %q, want %q", test.manifest, description, test.workload)
+		}
+	}
+}
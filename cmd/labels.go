@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"github.com/tjfontaine/podspec2linuxkit/pkg/linuxkit"
+	"strings"
+)
+
+func formatConfigValue(value interface{}) string {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprintf("%v", value)
+	}
+	return string(encoded)
+}
+
+// mergeImageLabels fills in what the pod leaves unset with the config in the org.mobyproject.config label of each
+// image, which linuxkit build would do anyway, and says where the two differ so nothing an image needs is dropped
+// without anyone noticing
+func mergeImageLabels(config *linuxkit.Moby) error {
+	if imageInspector == nil {
+		return nil
+	}
+
+	for _, section := range []*[]*linuxkit.Image{config.Onboot, config.Onshutdown, config.Services} {
+		if section == nil {
+			continue
+		}
+
+		for _, image := range *section {
+			// the reference was already rewritten
+			ref, err := linuxkit.ParseReference(image.Image)
+			if err != nil {
+				return err
+			}
+
+			imageConfig := inspectImage(ref)
+			if imageConfig == nil {
+				continue
+			}
+
+			label, ok := imageConfig.Config.Labels[linuxkit.ConfigLabel]
+			if !ok {
+				continue
+			}
+
+			labelConfig, err := linuxkit.ParseConfigLabel(label)
+			if err != nil {
+				return fmt.Errorf("%s: %s label of %s: %v", image.Name, linuxkit.ConfigLabel, image.Image, err)
+			}
+
+			for _, difference := range linuxkit.MergeConfigLabel(&image.ImageConfig, labelConfig) {
+				switch {
+				case difference.Config == nil:
+					log.Infof("%s: using %s %s from the label of %s", image.Name, difference.Field, formatConfigValue(difference.Label), image.Image)
+				case len(difference.Dropped) > 0:
+					log.Warnf("%s: the pod's %s replace those in the label of %s, dropping %s", image.Name, difference.Field, image.Image, strings.Join(difference.Dropped, ", "))
+				default:
+					log.Infof("%s: %s %s replaces %s from the label of %s", image.Name, difference.Field, formatConfigValue(difference.Config), formatConfigValue(difference.Label), image.Image)
+				}
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"github.com/tjfontaine/podspec2linuxkit/pkg/linuxkit"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"reflect"
+)
+
+// prefixPod renames the containers and volumes of a pod, in its spec and in the manifest, so that what is generated
+// for them is named after the pod and can't collide with what is generated for others in the same image. The prefix
+// is separated by a '_', which pod, container and volume names can't have, so pod web's nginx and pod web-nginx's
+// containers can't end up with the same name
+func prefixPod(spec *corev1.PodSpec, raw map[string]interface{}, prefix string) {
+	rename := func(name string) string {
+		return fmt.Sprintf("%s_%s", prefix, name)
+	}
+
+	for idx := range spec.Volumes {
+		volume := &spec.Volumes[idx]
+		volume.Name = rename(volume.Name)
+		if volume.DownwardAPI != nil {
+			for item := range volume.DownwardAPI.Items {
+				if ref := volume.DownwardAPI.Items[item].ResourceFieldRef; ref != nil && ref.ContainerName != "" {
+					ref.ContainerName = rename(ref.ContainerName)
+				}
+			}
+		}
+	}
+
+	for _, containers := range [][]corev1.Container{spec.InitContainers, spec.Containers} {
+		for idx := range containers {
+			container := &containers[idx]
+			container.Name = rename(container.Name)
+			for mount := range container.VolumeMounts {
+				container.VolumeMounts[mount].Name = rename(container.VolumeMounts[mount].Name)
+			}
+			for device := range container.VolumeDevices {
+				container.VolumeDevices[device].Name = rename(container.VolumeDevices[device].Name)
+			}
+			for env := range container.Env {
+				if source := container.Env[env].ValueFrom; source != nil && source.ResourceFieldRef != nil && source.ResourceFieldRef.ContainerName != "" {
+					source.ResourceFieldRef.ContainerName = rename(source.ResourceFieldRef.ContainerName)
+				}
+			}
+		}
+	}
+
+	renameRaw := func(objs []interface{}) {
+		for _, obj := range objs {
+			if obj, ok := obj.(map[string]interface{}); ok {
+				if name, ok := obj["name"].(string); ok {
+					obj["name"] = rename(name)
+				}
+			}
+		}
+	}
+
+	volumes, _, _ := unstructured.NestedSlice(raw, "volumes")
+	renameRaw(volumes)
+	unstructured.SetNestedSlice(raw, volumes, "volumes")
+
+	for _, field := range []string{"initContainers", "containers"} {
+		containers, _, _ := unstructured.NestedSlice(raw, field)
+		renameRaw(containers)
+		for _, container := range containers {
+			if container, ok := container.(map[string]interface{}); ok {
+				for _, list := range []string{"volumeMounts", "volumeDevices"} {
+					if items, ok := container[list].([]interface{}); ok {
+						renameRaw(items)
+					}
+				}
+			}
+		}
+		unstructured.SetNestedSlice(raw, containers, field)
+	}
+}
+
+// mergeImages adds images to a section of the merged config. An image which is already there is only added once,
+// like the entrypoint shim every pod may need, but a different one of the same name is a collision.
+func mergeImages(section string, into **[]*linuxkit.Image, from *[]*linuxkit.Image, owner string, owners map[string]string) error {
+	if from == nil {
+		return nil
+	}
+
+	if *into == nil {
+		*into = &[]*linuxkit.Image{}
+	}
+
+	for _, image := range *from {
+		key := section + "/" + image.Name
+		if previous, ok := owners[key]; ok {
+			if existing := findImage(**into, image.Name); existing != nil && reflect.DeepEqual(existing, image) {
+				continue
+			}
+			return fmt.Errorf("%s and %s both have %s %s", previous, owner, section, image.Name)
+		}
+
+		owners[key] = owner
+		**into = append(**into, image)
+	}
+
+	return nil
+}
+
+func findImage(images []*linuxkit.Image, name string) *linuxkit.Image {
+	for _, image := range images {
+		if image.Name == name {
+			return image
+		}
+	}
+	return nil
+}
+
+// mergeConfig adds the config of one workload to the image merging all of them
+func mergeConfig(into *linuxkit.Moby, from *linuxkit.Moby, owner string, owners map[string]string) error {
+	if err := mergeImages("onboot", &into.Onboot, from.Onboot, owner, owners); err != nil {
+		return err
+	}
+
+	if err := mergeImages("onshutdown", &into.Onshutdown, from.Onshutdown, owner, owners); err != nil {
+		return err
+	}
+
+	if err := mergeImages("services", &into.Services, from.Services, owner, owners); err != nil {
+		return err
+	}
+
+	appendTrust(&into.Trust, from.Trust)
+
+	if from.Files == nil {
+		return nil
+	}
+
+	if into.Files == nil {
+		into.Files = &[]linuxkit.File{}
+	}
+
+	for _, file := range *from.Files {
+		key := "files/" + file.Path
+		if previous, ok := owners[key]; ok {
+			same := false
+			for _, existing := range *into.Files {
+				if existing.Path == file.Path && reflect.DeepEqual(existing, file) {
+					same = true
+				}
+			}
+			if same {
+				continue
+			}
+			return fmt.Errorf("%s and %s both have file %s", previous, owner, file.Path)
+		}
+
+		owners[key] = owner
+		*into.Files = append(*into.Files, file)
+	}
+
+	return nil
+}
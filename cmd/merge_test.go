@@ -0,0 +1,152 @@
+package main
+
+import (
+	"github.com/tjfontaine/podspec2linuxkit/pkg/linuxkit"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"strings"
+	"testing"
+)
+
+func TestPrefixPod(t *testing.T) {
+	spec := &corev1.PodSpec{
+		Volumes: []corev1.Volume{
+			{Name: "data"},
+			{Name: "info", VolumeSource: corev1.VolumeSource{DownwardAPI: &corev1.DownwardAPIVolumeSource{
+				Items: []corev1.DownwardAPIVolumeFile{{Path: "cpu", ResourceFieldRef: &corev1.ResourceFieldSelector{ContainerName: "app", Resource: "limits.cpu"}}},
+			}}},
+		},
+		InitContainers: []corev1.Container{{Name: "setup", VolumeMounts: []corev1.VolumeMount{{Name: "data"}}}},
+		Containers: []corev1.Container{{
+			Name:          "app",
+			VolumeMounts:  []corev1.VolumeMount{{Name: "data"}, {Name: "info"}},
+			VolumeDevices: []corev1.VolumeDevice{{Name: "disk"}},
+			Env: []corev1.EnvVar{
+				{Name: "CPU", ValueFrom: &corev1.EnvVarSource{ResourceFieldRef: &corev1.ResourceFieldSelector{ContainerName: "setup", Resource: "limits.cpu"}}},
+				// the container's own resources, without a name
+				{Name: "MEMORY", ValueFrom: &corev1.EnvVarSource{ResourceFieldRef: &corev1.ResourceFieldSelector{Resource: "limits.memory"}}},
+			},
+		}},
+	}
+	raw := map[string]interface{}{
+		"volumes":        []interface{}{map[string]interface{}{"name": "data", "image": map[string]interface{}{"reference": "models:v1"}}},
+		"initContainers": []interface{}{map[string]interface{}{"name": "setup"}},
+		"containers": []interface{}{map[string]interface{}{
+			"name":         "app",
+			"volumeMounts": []interface{}{map[string]interface{}{"name": "data", "subPathExpr": "$(POD_NAME)"}},
+		}},
+	}
+
+	prefixPod(spec, raw, "web")
+
+	for _, test := range []struct{ what, got, want string }{
+		{"volume", spec.Volumes[0].Name, "web_data"},
+		{"downwardAPI containerName", spec.Volumes[1].DownwardAPI.Items[0].ResourceFieldRef.ContainerName, "web_app"},
+		{"init container", spec.InitContainers[0].Name, "web_setup"},
+		{"init container volume mount", spec.InitContainers[0].VolumeMounts[0].Name, "web_data"},
+		{"container", spec.Containers[0].Name, "web_app"},
+		{"volume mount", spec.Containers[0].VolumeMounts[1].Name, "web_info"},
+		{"volume device", spec.Containers[0].VolumeDevices[0].Name, "web_disk"},
+		{"env containerName", spec.Containers[0].Env[0].ValueFrom.ResourceFieldRef.ContainerName, "web_setup"},
+		{"env without containerName", spec.Containers[0].Env[1].ValueFrom.ResourceFieldRef.ContainerName, ""},
+	} {
+		if test.got != test.want {
+			t.Errorf("%s: got %q, want %q", test.what, test.got, test.want)
+		}
+	}
+
+	// the raw spec is renamed the same way, so fields only it has are still found
+	if volume := rawPodSpecVolume(raw, "web_data"); volume == nil || volume["image"] == nil {
+		t.Errorf("got raw volumes %v, want web_data", raw["volumes"])
+	}
+	containers, _, _ := unstructured.NestedSlice(raw, "containers")
+	container := containers[0].(map[string]interface{})
+	mount := container["volumeMounts"].([]interface{})[0].(map[string]interface{})
+	if container["name"] != "web_app" || mount["name"] != "web_data" || mount["subPathExpr"] != "$(POD_NAME)" {
+		t.Errorf("got raw container %v, want web_app mounting web_data", container)
+	}
+}
+
+func rawPodSpecVolume(raw map[string]interface{}, name string) map[string]interface{} {
+	volumes, _, _ := unstructured.NestedSlice(raw, "volumes")
+	for _, volume := range volumes {
+		if volume, ok := volume.(map[string]interface{}); ok && volume["name"] == name {
+			return volume
+		}
+	}
+	return nil
+}
+
+func TestMergeConfig(t *testing.T) {
+	contents := "hello"
+	other := "goodbye"
+	shim := func() *linuxkit.Image {
+		return &linuxkit.Image{Name: "entrypoint-shim", Image: "busybox:latest"}
+	}
+	config := func(service string, image string, file string, contents *string) *linuxkit.Moby {
+		return &linuxkit.Moby{
+			Onboot:   &[]*linuxkit.Image{shim()},
+			Services: &[]*linuxkit.Image{{Name: service, Image: image}},
+			Files:    &[]linuxkit.File{{Path: file, Contents: contents}},
+		}
+	}
+
+	for _, test := range []struct {
+		name    string
+		configs []*linuxkit.Moby
+		// the names of the merged onboot images, services and files, or the error
+		merged string
+		err    string
+	}{
+		{
+			name:    "distinct pods, sharing the identical entrypoint shim and file",
+			configs: []*linuxkit.Moby{config("container-web_app", "nginx:1.15", "etc/motd", &contents), config("container-api_app", "nginx:1.15", "etc/motd", &contents)},
+			merged:  "entrypoint-shim | container-web_app container-api_app | etc/motd",
+		},
+		{
+			name:    "different services of the same name",
+			configs: []*linuxkit.Moby{config("container-web_app", "nginx:1.15", "etc/web", &contents), config("container-web_app", "nginx:1.16", "etc/api", &contents)},
+			err:     "web and api both have services container-web_app",
+		},
+		{
+			name:    "different files at the same path",
+			configs: []*linuxkit.Moby{config("container-web_app", "nginx:1.15", "etc/motd", &contents), config("container-api_app", "nginx:1.15", "etc/motd", &other)},
+			err:     "web and api both have file etc/motd",
+		},
+	} {
+		into := &linuxkit.Moby{}
+		owners := map[string]string{}
+		var err error
+		for idx, config := range test.configs {
+			if err = mergeConfig(into, config, []string{"web", "api"}[idx], owners); err != nil {
+				break
+			}
+		}
+
+		if test.err != "" {
+			if err == nil || err.Error() != test.err {
+				t.Errorf("%s: got %v, want %s", test.name, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+
+		names := func(images *[]*linuxkit.Image) string {
+			result := []string{}
+			for _, image := range *images {
+				result = append(result, image.Name)
+			}
+			return strings.Join(result, " ")
+		}
+		files := []string{}
+		for _, file := range *into.Files {
+			files = append(files, file.Path)
+		}
+		if merged := names(into.Onboot) + " | " + names(into.Services) + " | " + strings.Join(files, " "); merged != test.merged {
+			t.Errorf("%s: got %s, want %s", test.name, merged, test.merged)
+		}
+	}
+}
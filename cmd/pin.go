@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"github.com/containerd/containerd/reference"
+	log "github.com/sirupsen/logrus"
+	"github.com/tjfontaine/podspec2linuxkit/pkg/linuxkit"
+	"github.com/tjfontaine/podspec2linuxkit/pkg/resolver"
+	"os"
+	"strings"
+)
+
+// imageResolver is set by --pin, and pins every image to the digest its tag resolves to
+var imageResolver resolver.Resolver
+
+// imageLock records what imageResolver resolved, see --lock
+var imageLock *resolver.Locked
+
+// references already warned about, so each is only mentioned once
+var warnedLatest = map[string]bool{}
+
+// newResolver parses --pin: registry, oci:<dir> or lock:<file>
+func newResolver(spec string) (resolver.Resolver, error) {
+	parts := strings.SplitN(spec, ":", 2)
+	switch {
+	case spec == "registry":
+		return &resolver.Registry{}, nil
+	case parts[0] == "oci" && len(parts) == 2:
+		return &resolver.OCILayout{Dir: parts[1]}, nil
+	case parts[0] == "lock" && len(parts) == 2:
+		return resolver.LoadLockFile(parts[1])
+	}
+
+	return nil, fmt.Errorf("unknown resolver %s, expected registry, oci:<dir> or lock:<file>", spec)
+}
+
+// setupPinning pins images when --pin is given, through the lock file. When it exists it is authoritative: images are
+// pinned to the digests it has, and one it doesn't have fails the conversion. Otherwise, or with --update-lock, every
+// image is resolved as --pin says and the lock file is written with just those. Without --pin it isn't used at all.
+func setupPinning(pin string, lockFile string, update bool) error {
+	if pin == "" {
+		if update {
+			return fmt.Errorf("--update-lock needs --pin")
+		}
+		return nil
+	}
+
+	fallback, err := newResolver(pin)
+	if err != nil {
+		return err
+	}
+
+	lock := &resolver.LockFile{}
+	_, err = os.Stat(lockFile)
+	exists := err == nil
+	if exists {
+		if lock, err = resolver.LoadLockFile(lockFile); err != nil {
+			return err
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	// a new lock file is written even when there turns out to be nothing to pin
+	imageLock = &resolver.Locked{Lock: lock, Resolver: fallback, Update: update || !exists, Changed: !exists}
+	imageResolver = imageLock
+	return nil
+}
+
+// useRegistryCredentials has images which are resolved, or inspected, from their registry use the given credentials
+func useRegistryCredentials(credentials func(host string) (string, string)) {
+	fallback := imageResolver
+	if imageLock != nil {
+		fallback = imageLock.Resolver
+	}
+
+	for _, source := range []interface{}{fallback, imageSource} {
+		if registry, ok := source.(*resolver.Registry); ok {
+			registry.Credentials = credentials
+		}
+	}
+}
+
+// saveLock writes the lock file, if it was updated and anything was added to, changed in or dropped from it
+func saveLock(lockFile string) {
+	if imageLock == nil {
+		return
+	}
+
+	imageLock.Prune()
+	if !imageLock.Changed {
+		return
+	}
+
+	if err := imageLock.Lock.Save(lockFile); err != nil {
+		log.Errorf("Failed to write %s: %v", lockFile, err)
+		os.Exit(1)
+	}
+}
+
+// normalizeImages writes every image reference out in full, rewritten and pinned when asked to, and flags those
+// which use latest since what they run can change from one build to the next
+func normalizeImages(config *linuxkit.Moby) error {
+	return config.UpdateImages(func(ref *reference.Spec, image *linuxkit.Image) error {
+		if err := rewriteImage(ref, image); err != nil {
+			return err
+		}
+
+		name := ref.String()
+		if linuxkit.ReferenceTag(*ref) == "latest" && !warnedLatest[name] && ref.Digest() == "" {
+			warnedLatest[name] = true
+			if imageResolver == nil {
+				log.Warnf("%s uses the latest tag, the image can change from one build to the next, see --pin", name)
+			} else {
+				log.Infof("%s uses the latest tag, pinned to what it is now", name)
+			}
+		}
+
+		if imageResolver == nil {
+			return nil
+		}
+
+		return resolver.Pin(imageResolver, ref)
+	})
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestSetupPinning(t *testing.T) {
+	defer func() { imageLock, imageResolver = nil, nil }()
+
+	dir, err := ioutil.TempDir("", "pin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	lockFile := path.Join(dir, "podspec2linuxkit.lock")
+	missing := path.Join(dir, "missing.lock")
+	if err := ioutil.WriteFile(lockFile, []byte("images:\n  docker.io/library/busybox:latest: sha256:0123456789012345678901234567890123456789012345678901234567890123\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		pin    string
+		lock   string
+		update bool
+		// whether images are pinned, and if so resolved again rather than from the lock file
+		pinned  bool
+		updated bool
+		err     bool
+	}{
+		// the lock file is only used with --pin
+		{lock: lockFile},
+		{lock: lockFile, update: true, err: true},
+		{pin: "registry", lock: lockFile, pinned: true},
+		{pin: "registry", lock: lockFile, update: true, pinned: true, updated: true},
+		{pin: "oci:" + dir, lock: missing, pinned: true, updated: true},
+		{pin: "elsewhere", lock: lockFile, err: true},
+	} {
+		imageLock, imageResolver = nil, nil
+		err := setupPinning(test.pin, test.lock, test.update)
+		if test.err {
+			if err == nil {
+				t.Errorf("%+v: expected an error", test)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%+v: %v", test, err)
+			continue
+		}
+
+		if (imageResolver != nil) != test.pinned || (test.pinned && imageLock.Update != test.updated) {
+			t.Errorf("%+v: got resolver %v", test, imageResolver)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"github.com/tjfontaine/podspec2linuxkit/pkg/linuxkit"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+//go:generate go run ../templates/generate.go
+
+// the template every platform's profile is merged into, see embeddedTemplates
+const commonTemplate = "base_image.yaml"
+
+// cloud platforms whose disks can be used as volumes, see --provider
+var platformProviders = map[string]string{
+	"aws":   "aws",
+	"azure": "azure",
+	"gcp":   "gcp",
+}
+
+// platformNames are those of the base_<platform>.yaml templates
+func platformNames() []string {
+	names := []string{}
+	for file := range embeddedTemplates {
+		if file != commonTemplate {
+			names = append(names, strings.TrimSuffix(strings.TrimPrefix(file, "base_"), ".yaml"))
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// platformTemplate parses one of the embedded templates
+func platformTemplate(file string) (linuxkit.Moby, error) {
+	config, err := linuxkit.NewConfig([]byte(embeddedTemplates[file]))
+	if err != nil {
+		return config, fmt.Errorf("templates/%s: %v", file, err)
+	}
+	return config, nil
+}
+
+// loadPlatform returns the profile of a platform: <dir>/<name>.yaml in place of the embedded one when it exists,
+// extended by every <dir>/<name>.d/*.yaml in order
+func loadPlatform(name string, dir string) (linuxkit.Moby, error) {
+	override := ""
+	if dir != "" {
+		override = path.Join(dir, name+".yaml")
+		if _, err := os.Stat(override); os.IsNotExist(err) {
+			override = ""
+		}
+	}
+
+	var profile linuxkit.Moby
+	if override != "" {
+		data, err := ioutil.ReadFile(override)
+		if err != nil {
+			return profile, err
+		}
+		if profile, err = linuxkit.NewConfig(data); err != nil {
+			return profile, fmt.Errorf("%s: %v", override, err)
+		}
+	} else {
+		known := false
+		for _, platform := range platformNames() {
+			known = known || platform == name
+		}
+		if !known {
+			return profile, fmt.Errorf("unknown platform %s, expected one of %s", name, strings.Join(platformNames(), ", "))
+		}
+
+		// the same as linuxkit build given templates/base_image.yaml and templates/base_<platform>.yaml
+		common, err := platformTemplate(commonTemplate)
+		if err != nil {
+			return profile, err
+		}
+		specific, err := platformTemplate("base_" + name + ".yaml")
+		if err != nil {
+			return profile, err
+		}
+		if profile, err = linuxkit.AppendConfig(common, specific); err != nil {
+			return profile, fmt.Errorf("platform %s: %v", name, err)
+		}
+	}
+
+	if dir == "" {
+		return profile, nil
+	}
+
+	extensions, err := filepath.Glob(path.Join(dir, name+".d", "*.yaml"))
+	if err != nil {
+		return profile, err
+	}
+	sort.Strings(extensions)
+
+	for _, file := range extensions {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return profile, err
+		}
+		extension, err := linuxkit.NewConfig(data)
+		if err != nil {
+			return profile, fmt.Errorf("%s: %v", file, err)
+		}
+		if profile, err = linuxkit.AppendConfig(profile, extension); err != nil {
+			return profile, fmt.Errorf("%s: %v", file, err)
+		}
+	}
+
+	return profile, nil
+}
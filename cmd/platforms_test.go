@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEmbeddedTemplates(t *testing.T) {
+	files, err := filepath.Glob("../templates/base_*.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != len(embeddedTemplates) {
+		t.Errorf("got %d embedded templates for %d files, run go generate", len(embeddedTemplates), len(files))
+	}
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if embeddedTemplates[filepath.Base(file)] != string(data) {
+			t.Errorf("%s differs from the embedded template, run go generate", file)
+		}
+	}
+}
+
+func TestLoadPlatform(t *testing.T) {
+	if names := strings.Join(platformNames(), " "); names != "aws azure gcp metal qemu vmware" {
+		t.Errorf("got platforms %s", names)
+	}
+
+	for _, test := range []struct {
+		platform string
+		cmdline  string
+		// services on top of the five of base_image.yaml
+		services []string
+	}{
+		{"qemu", "console=tty0 console=ttyS0 console=ttyAMA0", nil},
+		{"vmware", "console=tty0 console=ttyS0", []string{"open-vm-tools"}},
+		{"aws", "console=ttyS0", []string{"sshd"}},
+		{"gcp", "console=ttyS0", []string{"sshd"}},
+		{"azure", "console=ttyS0 earlyprintk=ttyS0 rootdelay=300", []string{"sshd"}},
+		{"metal", "console=tty0 console=ttyS0", nil},
+	} {
+		profile, err := loadPlatform(test.platform, "")
+		if err != nil {
+			t.Errorf("%s: %v", test.platform, err)
+			continue
+		}
+
+		services := []string{}
+		for _, service := range (*profile.Services)[5:] {
+			services = append(services, service.Name)
+		}
+		if profile.Kernel.Cmdline != test.cmdline || strings.Join(services, " ") != strings.Join(test.services, " ") {
+			t.Errorf("%s: got cmdline %q and services %v", test.platform, profile.Kernel.Cmdline, services)
+		}
+	}
+
+	for _, name := range []string{"openstack", "image"} {
+		if _, err := loadPlatform(name, ""); err == nil {
+			t.Errorf("%s: expected an unknown platform", name)
+		}
+	}
+
+	// a profile of the directory replaces the embedded one, and its extensions are merged on top
+	dir, err := ioutil.TempDir("", "platforms")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Mkdir(path.Join(dir, "aws.d"), 0755)
+	ioutil.WriteFile(path.Join(dir, "aws.yaml"), []byte("kernel:\n  image: linuxkit/kernel:4.19.8\n"), 0644)
+	ioutil.WriteFile(path.Join(dir, "aws.d", "10-agent.yaml"), []byte("services:\n- name: agent\n  image: agent:v1\n"), 0644)
+	profile, err := loadPlatform("aws", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if profile.Onboot != nil || len(*profile.Services) != 1 || (*profile.Services)[0].Name != "agent" {
+		t.Errorf("got %+v, want the directory's profile and extension", profile)
+	}
+}
+
+func TestAzureProfile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "azure")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// the instance metadata service and the wireserver
+	health := ""
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if strings.HasPrefix(r.URL.Path, "/metadata/") && (r.Header.Get("Metadata") != "true" || query.Get("format") != "text" || query.Get("api-version") == "") {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if strings.HasPrefix(r.URL.Path, "/machine") && r.Header.Get("x-ms-version") != "2012-11-30" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		switch r.URL.Path + "?" + query.Get("comp") {
+		case "/metadata/instance/compute/name?":
+			fmt.Fprint(w, "web-1")
+		case "/metadata/instance/compute/publicKeys/0/keyData?":
+			fmt.Fprint(w, "ssh-ed25519 AAAA admin@laptop")
+		case "/metadata/instance/compute/publicKeys/1/keyData?":
+			fmt.Fprint(w, "ssh-rsa BBBB ci")
+		case "/metadata/instance/compute/userData?":
+			fmt.Fprint(w, base64.StdEncoding.EncodeToString([]byte("#cloud-config\n")))
+		case "/machine/?goalstate":
+			fmt.Fprint(w, "<?xml version=\"1.0\" encoding=\"utf-8\"?>\r\n<GoalState>\r\n  <Version>2012-11-30</Version>\r\n  <Incarnation>3</Incarnation>\r\n"+
+				"  <Container>\r\n    <ContainerId>c6d5d2bd-0000-4e3e-9f8f-0a3b1c2d3e4f</ContainerId>\r\n    <RoleInstanceList>\r\n      <RoleInstance>\r\n"+
+				"        <InstanceId>8a1b2c3d.web-1</InstanceId>\r\n      </RoleInstance>\r\n    </RoleInstanceList>\r\n  </Container>\r\n</GoalState>\r\n")
+		case "/machine?health":
+			body, _ := ioutil.ReadAll(r.Body)
+			health = r.Header.Get("Content-Type") + "\n" + string(body)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	profile, err := loadPlatform("azure", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	replacer := strings.NewReplacer("http://169.254.169.254", server.URL, "http://168.63.129.16", server.URL, "/run/config", dir)
+	for _, image := range (*profile.Onboot)[len(*profile.Onboot)-2:] {
+		script := "hostname() { echo $1 > " + dir + "/hostname-set; }\nsleep() { :; }\n" + replacer.Replace((*image.Command)[2])
+		if out, err := exec.Command("/bin/sh", "-c", script).CombinedOutput(); err != nil {
+			t.Fatalf("%s: %v %s", image.Name, err, out)
+		}
+	}
+
+	for file, want := range map[string]string{
+		"hostname":            "web-1\n",
+		"hostname-set":        "web-1\n",
+		"ssh/authorized_keys": "ssh-ed25519 AAAA admin@laptop\nssh-rsa BBBB ci\n",
+		"userdata":            "#cloud-config\n",
+	} {
+		if contents, err := ioutil.ReadFile(path.Join(dir, file)); err != nil || string(contents) != want {
+			t.Errorf("%s: got %q (%v), want %q", file, contents, err, want)
+		}
+	}
+
+	for _, want := range []string{"text/xml;charset=utf-8\n", "<GoalStateIncarnation>3</GoalStateIncarnation>", "<ContainerId>c6d5d2bd-0000-4e3e-9f8f-0a3b1c2d3e4f</ContainerId>",
+		"<InstanceId>8a1b2c3d.web-1</InstanceId>", "<State>Ready</State>"} {
+		if !strings.Contains(health, want) {
+			t.Errorf("got health report:\n%s\nwant %q", health, want)
+		}
+	}
+}
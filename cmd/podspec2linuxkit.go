@@ -1,6 +1,8 @@
 package main
 
 import (
+	"encoding/json"
+	"flag"
 	"fmt"
 	log "github.com/sirupsen/logrus"
 	"github.com/tjfontaine/podspec2linuxkit/pkg/linuxkit"
@@ -9,10 +11,18 @@ import (
 	appsv1 "k8s.io/api/apps/v1"
 	appsv1beta1 "k8s.io/api/apps/v1beta1"
 	appsv1beta2 "k8s.io/api/apps/v1beta2"
+	batchv1 "k8s.io/api/batch/v1"
+	batchv1beta1 "k8s.io/api/batch/v1beta1"
+	batchv2alpha1 "k8s.io/api/batch/v2alpha1"
 	corev1 "k8s.io/api/core/v1"
 	extv1beta1 "k8s.io/api/extensions/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/kubernetes/scheme"
 	"os"
+	"path"
+	"sort"
 	"strings"
 )
 
@@ -34,7 +44,69 @@ var DEFAULT_CAPABILITIES = map[string]bool{
 	"CAP_SETFCAP":          true,
 }
 
-func containerToLinuxKitImage(spec *corev1.PodSpec, container corev1.Container, volumeMap map[string]string) (*linuxkit.Image, error) {
+// podContext carries what we know about the pod being converted, and what has been generated for it so far
+type podContext struct {
+	meta metav1.ObjectMeta
+	spec *corev1.PodSpec
+	// the pod spec as it appeared in the manifest, for fields the vendored types don't have
+	raw map[string]interface{}
+
+	// volume name to the path on the host its contents will be found at
+	volumeMap map[string]string
+
+	// files to add to the image, e.g. for downwardAPI volumes
+	files []linuxkit.File
+
+	// whether any container needs the entrypoint shim to resolve env at boot
+	entrypointShim bool
+
+	// onboot steps that have to run before the container being converted, e.g. to create its subPaths
+	prepare []*linuxkit.Image
+
+	// volumes containers may only mount read only
+	readOnlyVolumes map[string]bool
+
+	// volumes some container uses through volumeDevices, and the block devices they turned into
+	blockVolumes map[string]bool
+	devices      map[string]*blockDevice
+
+	// the claims persistentVolumeClaim volumes can refer to, by name
+	claims map[string]*corev1.PersistentVolumeClaim
+}
+
+func newPodContext(template *corev1.PodTemplateSpec, raw map[string]interface{}, claims []corev1.PersistentVolumeClaim) *podContext {
+	pod := &podContext{
+		meta:            template.ObjectMeta,
+		spec:            &template.Spec,
+		raw:             raw,
+		volumeMap:       map[string]string{},
+		readOnlyVolumes: map[string]bool{},
+		blockVolumes:    map[string]bool{},
+		devices:         map[string]*blockDevice{},
+		claims:          map[string]*corev1.PersistentVolumeClaim{},
+	}
+
+	for idx := range claims {
+		pod.claims[claims[idx].Name] = &claims[idx]
+	}
+
+	for _, containers := range [][]corev1.Container{pod.spec.InitContainers, pod.spec.Containers} {
+		for _, container := range containers {
+			for _, volumeDevice := range container.VolumeDevices {
+				pod.blockVolumes[volumeDevice.Name] = true
+			}
+		}
+	}
+
+	if pod.meta.Namespace == "" {
+		pod.meta.Namespace = "default"
+	}
+
+	return pod
+}
+
+func containerToLinuxKitImage(pod *podContext, container corev1.Container) (*linuxkit.Image, error) {
+	spec := pod.spec
 	image := &linuxkit.Image{
 		Name:  container.Name,
 		Image: container.Image,
@@ -44,18 +116,50 @@ func containerToLinuxKitImage(spec *corev1.PodSpec, container corev1.Container,
 		},
 	}
 
-	if len(container.Command) > 0 {
-		image.ImageConfig.Command = &container.Command
+	if spec.Hostname != "" && spec.Subdomain != "" {
+		image.ImageConfig.Hostname = fmt.Sprintf("%s.%s", spec.Hostname, spec.Subdomain)
+	}
+
+	// runc only sets a hostname in a uts namespace of the container's own
+	if image.ImageConfig.Hostname != "" {
+		image.ImageConfig.Uts = "new"
+	}
+
+	imageConfig, err := inspectContainerImage(container.Image)
+	if err != nil {
+		return nil, fmt.Errorf("container %s: %v", container.Name, err)
 	}
 
-	envArr := []string{}
+	if image.Cwd == "" && imageConfig != nil {
+		image.Cwd = imageConfig.Config.WorkingDir
+	}
+
+	envArr := imageEnv(imageConfig, container.Env)
+	// env which can only be resolved once the VM has booted, in the form understood by the entrypoint shim
+	runtimeEnv := []string{}
+	// the values of env known now, nil for those only known at boot
+	envMap := map[string]*string{}
 	if len(container.Env) > 0 {
 		for _, env := range container.Env {
 			if env.ValueFrom != nil {
-				log.Warnf("valueFrom for environment variables not implemented: %s unset", env.Name)
+				value, runtimeField, err := pod.resolveEnvSource(&container, env.ValueFrom)
+				if err != nil {
+					return nil, fmt.Errorf("env %s of container %s: %v", env.Name, container.Name, err)
+				}
+				if runtimeField != "" {
+					runtimeEnv = append(runtimeEnv, fmt.Sprintf("%s=%s", env.Name, runtimeField))
+					envMap[env.Name] = nil
+				} else if value != nil {
+					envArr = append(envArr, fmt.Sprintf("%s=%s", env.Name, *value))
+					envMap[env.Name] = value
+				} else {
+					log.Warnf("valueFrom for environment variables not implemented: %s unset", env.Name)
+				}
 				continue
 			}
-			envArr = append(envArr, fmt.Sprintf("%s=%s", env.Name, env.Value))
+			value := env.Value
+			envArr = append(envArr, fmt.Sprintf("%s=%s", env.Name, value))
+			envMap[env.Name] = &value
 		}
 	}
 
@@ -71,12 +175,27 @@ func containerToLinuxKitImage(spec *corev1.PodSpec, container corev1.Container,
 		"/etc/resolv.conf:/etc/resolv.conf",
 	}
 
-	for _, volume := range container.VolumeMounts {
-		mount, propagateMounts, err := volumeMountToLinuxKitMount(&volume, volumeMap)
+	rawContainer := pod.rawContainer(container.Name)
+	for idx, volume := range container.VolumeMounts {
+		subPathExpr, _, _ := unstructured.NestedString(rawVolumeMount(rawContainer, idx), "subPathExpr")
+		subPath, err := subPathForMount(&volume, subPathExpr, envMap)
+		if err != nil {
+			return nil, fmt.Errorf("volume mount %s of container %s: %v", volume.Name, container.Name, err)
+		}
+
+		if pod.readOnlyVolumes[volume.Name] {
+			volume.ReadOnly = true
+		}
+
+		mount, propagateMounts, err := volumeMountToLinuxKitMount(&volume, subPath, pod.volumeMap)
 		if err != nil {
 			return nil, err
 		}
 
+		if subPath != "" {
+			pod.prepare = append(pod.prepare, subPathImage(fmt.Sprintf("subpath-%s-%d", container.Name, idx), pod.volumeMap[volume.Name], subPath))
+		}
+
 		if propagateMounts != "" {
 			if image.RootfsPropagation != nil {
 				log.Warnf("Overwriting RootfsPropagation value -- Old %s New %s", *image.RootfsPropagation, propagateMounts)
@@ -87,11 +206,41 @@ func containerToLinuxKitImage(spec *corev1.PodSpec, container corev1.Container,
 		mounts = append(mounts, mount)
 	}
 
+	var deviceRules []linuxkit.LinuxDeviceCgroup
+	if len(container.VolumeDevices) > 0 {
+		var deviceBinds []string
+		var err error
+		deviceBinds, deviceRules, err = volumeDevicesToLinuxKit(pod, &container)
+		if err != nil {
+			return nil, err
+		}
+		mounts = append(mounts, deviceBinds...)
+	}
+
+	if command := containerCommand(&container, imageConfig, len(runtimeEnv) > 0); len(command) > 0 {
+		image.ImageConfig.Command = &command
+	}
+
+	if len(runtimeEnv) > 0 {
+		if image.ImageConfig.Command == nil {
+			log.Warnf("container %s has no command to wrap with the entrypoint shim, runtime env unset: %s", container.Name, strings.Join(runtimeEnv, " "))
+		} else {
+			command := append(append(append(entrypointShimCommand(), runtimeEnv...), "--"), *image.ImageConfig.Command...)
+			image.ImageConfig.Command = &command
+			mounts = append(mounts, entrypointShimBinds()...)
+			pod.useEntrypointShim()
+		}
+	}
+
 	if len(mounts) > 0 {
 		image.ImageConfig.Binds = &mounts
 	}
 
-	capabMap := DEFAULT_CAPABILITIES
+	// adding and dropping capabilities changes the map, so every container starts from a copy of the defaults
+	capabMap := map[string]bool{}
+	for capab, enabled := range DEFAULT_CAPABILITIES {
+		capabMap[capab] = enabled
+	}
 
 	if container.SecurityContext != nil {
 		sc := container.SecurityContext
@@ -126,6 +275,10 @@ func containerToLinuxKitImage(spec *corev1.PodSpec, container corev1.Container,
 		// TODO sysctls?
 	}
 
+	if err := applyImageUser(spec, &container, image, imageConfig); err != nil {
+		return nil, err
+	}
+
 	capabArr := []string{}
 
 	for capabKey, capabValue := range capabMap {
@@ -134,6 +287,9 @@ func containerToLinuxKitImage(spec *corev1.PodSpec, container corev1.Container,
 		}
 	}
 
+	// map order would make the output differ from one conversion to the next
+	sort.Strings(capabArr)
+
 	if len(capabArr) > 0 {
 		image.Capabilities = &capabArr
 	}
@@ -147,7 +303,8 @@ func containerToLinuxKitImage(spec *corev1.PodSpec, container corev1.Container,
 	}
 
 	// by default, LinuxKit already runs all containers in the same host, ipc, and utc namespaces -- so
-	// spec.HostNetwork, spec.HostIPC have no particular meaning here
+	// spec.HostNetwork, spec.HostIPC have no particular meaning here, unless the pod is merged with others and
+	// isolated from them, see isolatePod
 
 	resources := linuxkit.LinuxResources{}
 	resourcesSeen := false
@@ -175,6 +332,11 @@ func containerToLinuxKitImage(spec *corev1.PodSpec, container corev1.Container,
 		}
 	}
 
+	if len(deviceRules) > 0 {
+		resources.Devices = deviceRules
+		resourcesSeen = true
+	}
+
 	if resourcesSeen {
 		image.ImageConfig.Resources = &resources
 	}
@@ -193,16 +355,20 @@ var mountPropMap = map[string]string{
 	"Bidirectional":   "rshared",
 }
 
-func volumeMountToLinuxKitMount(volume *corev1.VolumeMount, volumeMap map[string]string) (string, string, error) {
+func volumeMountToLinuxKitMount(volume *corev1.VolumeMount, subPath string, volumeMap map[string]string) (string, string, error) {
 	propagateMounts := ""
 
-	path, ok := volumeMap[volume.Name]
+	hostPath, ok := volumeMap[volume.Name]
 
 	if !ok {
 		return "", propagateMounts, fmt.Errorf("failed to find volume in pod spec: %s", volume.Name)
 	}
 
-	mount := fmt.Sprintf("%s:%s", path, volume.MountPath)
+	if subPath != "" {
+		hostPath = path.Join(hostPath, subPath)
+	}
+
+	mount := fmt.Sprintf("%s:%s", hostPath, volume.MountPath)
 
 	opts := []string{}
 
@@ -226,87 +392,115 @@ func volumeMountToLinuxKitMount(volume *corev1.VolumeMount, volumeMap map[string
 	return mount, propagateMounts, nil
 }
 
-func volumeToLinuxKitMount(volume *corev1.Volume, volumeMap map[string]string) (*linuxkit.Image, error) {
+func hostPathCheck(target string, test string, what string, hostPath string) string {
+	return fmt.Sprintf("[ %s %s ] || fail hostPath %s is not %s\n", test, target, shellQuote(hostPath), what)
+}
+
+func volumeToLinuxKitMount(pod *podContext, volume *corev1.Volume) ([]*linuxkit.Image, error) {
 	var image *linuxkit.Image = nil
+	volumeMap := pod.volumeMap
 
 	if volume.HostPath != nil {
-		var command []string
-		volumeMap[volume.Name] = volume.HostPath.Path
+		hostPath := volume.HostPath.Path
+		if hostPath == "" || !path.IsAbs(hostPath) {
+			return nil, fmt.Errorf("hostPath volume %s needs an absolute path, not %q", volume.Name, hostPath)
+		}
+
+		volumeMap[volume.Name] = hostPath
+		if pod.blockVolumes[volume.Name] {
+			pod.devices[volume.Name] = &blockDevice{path: hostPath, rules: deviceRulesForPath(hostPath)}
+		}
+		target := shellQuote(hostRoot + hostPath)
+		var script string
 		if volume.HostPath.Type != nil {
+			// mirror the kubelet, which refuses to start the pod when the host path isn't what the spec says
 			switch *volume.HostPath.Type {
-			case "DirectoryOrCreate":
-				command = []string{"mkdir", "-p", volume.HostPath.Path}
-			case "FileOrCreate":
-				command = []string{"touch", volume.HostPath.Path}
-			case "Directory":
-				fallthrough
-			case "File":
-				fallthrough
-			case "Socket":
-				fallthrough
-			case "CharDevice":
-				fallthrough
-			case "BlockDevice":
+			case corev1.HostPathDirectoryOrCreate:
+				script = fmt.Sprintf("[ -e %s ] || mkdir -p -m 0755 %s\n", target, target)
+				script += hostPathCheck(target, "-d", "a directory", hostPath)
+			case corev1.HostPathFileOrCreate:
+				script = fmt.Sprintf("[ -e %s ] || { touch %s && chmod 0644 %s; } || fail could not create %s\n", target, target, target, shellQuote(hostPath))
+				script += hostPathCheck(target, "-f", "a file", hostPath)
+			case corev1.HostPathDirectory:
+				script = hostPathCheck(target, "-d", "a directory", hostPath)
+			case corev1.HostPathFile:
+				script = hostPathCheck(target, "-f", "a file", hostPath)
+			case corev1.HostPathSocket:
+				script = hostPathCheck(target, "-S", "a socket", hostPath)
+			case corev1.HostPathCharDev:
+				script = hostPathCheck(target, "-c", "a character device", hostPath)
+			case corev1.HostPathBlockDev:
+				script = hostPathCheck(target, "-b", "a block device", hostPath)
+			case corev1.HostPathUnset:
 				break
+			default:
+				return nil, fmt.Errorf("hostPath volume %s has unknown type %s", volume.Name, *volume.HostPath.Type)
 			}
 		}
-		if len(command) > 0 {
-			image = &linuxkit.Image{
-				Name:  fmt.Sprintf("create-volume-%s", volume.Name),
-				Image: "busybox:latest",
-			}
-			image.ImageConfig.Command = &command
+		if script != "" {
+			image = bootScriptImage(fmt.Sprintf("create-volume-%s", volume.Name), script)
 		}
 	} else if volume.EmptyDir != nil {
 		path := fmt.Sprintf("/var/lib/volumes/%s", volume.Name)
 		volumeMap[volume.Name] = path
-		image = &linuxkit.Image{
-			Name:  fmt.Sprintf("create-volume-%s", volume.Name),
-			Image: "busybox:latest",
-			ImageConfig: linuxkit.ImageConfig{
-				Command: &[]string{"mkdir", "-p", path},
-			},
-		}
+		image = bootScriptImage(fmt.Sprintf("create-volume-%s", volume.Name), fmt.Sprintf("mkdir -p %s\n", shellQuote(hostRoot+path)))
+	} else if volume.DownwardAPI != nil {
+		return pod.downwardAPIVolume(volume)
+	} else if volume.AWSElasticBlockStore != nil {
+		return cloudDiskToLinuxKitMount(volume, awsDisk(volume.AWSElasticBlockStore), pod)
+	} else if volume.GCEPersistentDisk != nil {
+		return cloudDiskToLinuxKitMount(volume, gceDisk(volume.GCEPersistentDisk), pod)
+	} else if volume.AzureDisk != nil {
+		return cloudDiskToLinuxKitMount(volume, azureDisk(volume.AzureDisk), pod)
+	} else if volume.PersistentVolumeClaim != nil {
+		return persistentVolumeClaimToLinuxKitMount(pod, volume)
+	} else if source, ok := pod.rawVolume(volume.Name)["image"].(map[string]interface{}); ok {
+		return imageVolumeToLinuxKitMount(pod, volume, source)
+	} else if source, ok := pod.rawVolume(volume.Name)["ephemeral"].(map[string]interface{}); ok {
+		return ephemeralVolumeToLinuxKitMount(pod, volume, source)
+	} else if source, ok := pod.rawVolume(volume.Name)["csi"].(map[string]interface{}); ok {
+		return csiVolumeToLinuxKitMount(pod, volume, source)
 	} else {
 		return nil, fmt.Errorf("Unhandled volume type: %#v", volume)
 	}
 
-	return image, nil
+	if image == nil {
+		return nil, nil
+	}
+
+	return []*linuxkit.Image{image}, nil
 }
 
-func podSpec2LinuxKit(spec *corev1.PodSpec) (*linuxkit.Moby, error) {
+func podSpec2LinuxKit(template *corev1.PodTemplateSpec, raw map[string]interface{}, claims []corev1.PersistentVolumeClaim) (*linuxkit.Moby, error) {
 	result := &linuxkit.Moby{}
 
 	onboot := []*linuxkit.Image{}
 
-	volumeMap := map[string]string{}
+	pod := newPodContext(template, raw, claims)
+	spec := pod.spec
 
 	for _, volume := range spec.Volumes {
-		mount, err := volumeToLinuxKitMount(&volume, volumeMap)
+		mounts, err := volumeToLinuxKitMount(pod, &volume)
 		if err != nil {
 			return nil, err
 		}
-		if mount != nil {
-			onboot = append(onboot, mount)
-		}
+		onboot = append(onboot, mounts...)
 	}
 
 	for idx, initContainer := range spec.InitContainers {
-		image, err := containerToLinuxKitImage(spec, initContainer, volumeMap)
+		image, err := containerToLinuxKitImage(pod, initContainer)
 		if err != nil {
 			return nil, err
 		}
 		image.Name = fmt.Sprintf("initContainer-%d-%s", idx, initContainer.Name)
+		onboot = append(onboot, pod.prepare...)
 		onboot = append(onboot, image)
-	}
-
-	if len(onboot) > 0 {
-		result.Onboot = &onboot
+		pod.prepare = nil
 	}
 
 	services := []*linuxkit.Image{}
 	for _, container := range spec.Containers {
-		image, err := containerToLinuxKitImage(spec, container, volumeMap)
+		image, err := containerToLinuxKitImage(pod, container)
 		if err != nil {
 			return nil, err
 		}
@@ -314,71 +508,315 @@ func podSpec2LinuxKit(spec *corev1.PodSpec) (*linuxkit.Moby, error) {
 		services = append(services, image)
 	}
 
+	// like the kubelet, only prepare for the containers once the init containers are done
+	onboot = append(onboot, pod.prepare...)
+
 	if len(services) > 0 {
 		result.Services = &services
 	}
 
+	if pod.entrypointShim {
+		// the shim has to be in place before any container that needs it starts
+		onboot = append([]*linuxkit.Image{entrypointShimImage()}, onboot...)
+		pod.files = append(pod.files, entrypointShimFile())
+	}
+
+	if len(onboot) > 0 {
+		result.Onboot = &onboot
+	}
+
+	if len(pod.files) > 0 {
+		result.Files = &pod.files
+	}
+
 	return result, nil
 }
 
-type KindToLookup map[string]func(interface{}) corev1.PodSpec
+type KindToLookup map[string]func(interface{}) *workload
 type VersionLookup map[string]KindToLookup
 type GroupLookup map[string]VersionLookup
 
-// We could use reflection instead of this lookup table, but I'm not sure it buys us much?
+// Kinds whose pods are run in a particular way, or that the decoder knows, are looked up in this table; anything else
+// is found through the path to its pod template, see lookupUnstructured
 var GroupMap = GroupLookup{
 	"apps": VersionLookup{
 		"v1": KindToLookup{
-			"Deployment": func(reference interface{}) corev1.PodSpec {
-				return reference.(*appsv1.Deployment).Spec.Template.Spec
+			"Deployment": func(reference interface{}) *workload {
+				object := reference.(*appsv1.Deployment)
+				return podWorkload(object.ObjectMeta, object.Spec.Template)
+			},
+			"ReplicaSet": func(reference interface{}) *workload {
+				object := reference.(*appsv1.ReplicaSet)
+				return podWorkload(object.ObjectMeta, object.Spec.Template)
 			},
-			"ReplicaSet": func(reference interface{}) corev1.PodSpec {
-				return reference.(*appsv1.ReplicaSet).Spec.Template.Spec
+			"DaemonSet": func(reference interface{}) *workload {
+				object := reference.(*appsv1.DaemonSet)
+				return podWorkload(object.ObjectMeta, object.Spec.Template)
 			},
-			"DaemonSet": func(reference interface{}) corev1.PodSpec {
-				return reference.(*appsv1.DaemonSet).Spec.Template.Spec
+			"StatefulSet": func(reference interface{}) *workload {
+				object := reference.(*appsv1.StatefulSet)
+				return statefulSetWorkload(object.ObjectMeta, object.Spec.Template, object.Spec.Replicas, object.Spec.ServiceName, object.Spec.VolumeClaimTemplates)
 			},
 		},
 		"v1beta1": KindToLookup{
-			"Deployment": func(reference interface{}) corev1.PodSpec {
-				return reference.(*appsv1beta1.Deployment).Spec.Template.Spec
+			"Deployment": func(reference interface{}) *workload {
+				object := reference.(*appsv1beta1.Deployment)
+				return podWorkload(object.ObjectMeta, object.Spec.Template)
+			},
+			"StatefulSet": func(reference interface{}) *workload {
+				object := reference.(*appsv1beta1.StatefulSet)
+				return statefulSetWorkload(object.ObjectMeta, object.Spec.Template, object.Spec.Replicas, object.Spec.ServiceName, object.Spec.VolumeClaimTemplates)
 			},
 		},
 		"v1beta2": KindToLookup{
-			"Deployment": func(reference interface{}) corev1.PodSpec {
-				return reference.(*appsv1beta2.Deployment).Spec.Template.Spec
+			"Deployment": func(reference interface{}) *workload {
+				object := reference.(*appsv1beta2.Deployment)
+				return podWorkload(object.ObjectMeta, object.Spec.Template)
+			},
+			"ReplicaSet": func(reference interface{}) *workload {
+				object := reference.(*appsv1beta2.ReplicaSet)
+				return podWorkload(object.ObjectMeta, object.Spec.Template)
+			},
+			"DaemonSet": func(reference interface{}) *workload {
+				object := reference.(*appsv1beta2.DaemonSet)
+				return podWorkload(object.ObjectMeta, object.Spec.Template)
 			},
-			"ReplicaSet": func(reference interface{}) corev1.PodSpec {
-				return reference.(*appsv1beta2.ReplicaSet).Spec.Template.Spec
+			"StatefulSet": func(reference interface{}) *workload {
+				object := reference.(*appsv1beta2.StatefulSet)
+				return statefulSetWorkload(object.ObjectMeta, object.Spec.Template, object.Spec.Replicas, object.Spec.ServiceName, object.Spec.VolumeClaimTemplates)
 			},
-			"DaemonSet": func(reference interface{}) corev1.PodSpec {
-				return reference.(*appsv1beta2.DaemonSet).Spec.Template.Spec
+		},
+	},
+	"batch": VersionLookup{
+		"v1": KindToLookup{
+			"Job": func(reference interface{}) *workload {
+				object := reference.(*batchv1.Job)
+				return jobWorkload(object.ObjectMeta, object.Spec)
+			},
+		},
+		"v1beta1": KindToLookup{
+			"CronJob": func(reference interface{}) *workload {
+				object := reference.(*batchv1beta1.CronJob)
+				spec := object.Spec
+				cronJob := &cronJobSpec{
+					schedule:                   spec.Schedule,
+					startingDeadlineSeconds:    spec.StartingDeadlineSeconds,
+					concurrencyPolicy:          string(spec.ConcurrencyPolicy),
+					suspend:                    spec.Suspend != nil && *spec.Suspend,
+					successfulJobsHistoryLimit: spec.SuccessfulJobsHistoryLimit,
+					failedJobsHistoryLimit:     spec.FailedJobsHistoryLimit,
+				}
+				return cronJobWorkload(object.ObjectMeta, cronJob, spec.JobTemplate.ObjectMeta, spec.JobTemplate.Spec)
+			},
+		},
+		"v2alpha1": KindToLookup{
+			"CronJob": func(reference interface{}) *workload {
+				object := reference.(*batchv2alpha1.CronJob)
+				spec := object.Spec
+				cronJob := &cronJobSpec{
+					schedule:                   spec.Schedule,
+					startingDeadlineSeconds:    spec.StartingDeadlineSeconds,
+					concurrencyPolicy:          string(spec.ConcurrencyPolicy),
+					suspend:                    spec.Suspend != nil && *spec.Suspend,
+					successfulJobsHistoryLimit: spec.SuccessfulJobsHistoryLimit,
+					failedJobsHistoryLimit:     spec.FailedJobsHistoryLimit,
+				}
+				return cronJobWorkload(object.ObjectMeta, cronJob, spec.JobTemplate.ObjectMeta, spec.JobTemplate.Spec)
 			},
 		},
 	},
 	"core": VersionLookup{
 		"v1": KindToLookup{
-			"Pod": func(reference interface{}) corev1.PodSpec {
-				return reference.(*corev1.Pod).Spec
+			"Pod": func(reference interface{}) *workload {
+				object := reference.(*corev1.Pod)
+				return &workload{template: corev1.PodTemplateSpec{ObjectMeta: object.ObjectMeta, Spec: object.Spec}}
+			},
+			"PodTemplate": func(reference interface{}) *workload {
+				object := reference.(*corev1.PodTemplate)
+				return podWorkload(object.ObjectMeta, object.Template)
+			},
+			"ReplicationController": func(reference interface{}) *workload {
+				object := reference.(*corev1.ReplicationController)
+				if object.Spec.Template == nil {
+					return podWorkload(object.ObjectMeta, corev1.PodTemplateSpec{})
+				}
+				return podWorkload(object.ObjectMeta, *object.Spec.Template)
 			},
 		},
 	},
 	"extensions": VersionLookup{
 		"v1beta1": KindToLookup{
-			"DaemonSet": func(reference interface{}) corev1.PodSpec {
-				return reference.(*extv1beta1.DaemonSet).Spec.Template.Spec
+			"DaemonSet": func(reference interface{}) *workload {
+				object := reference.(*extv1beta1.DaemonSet)
+				return podWorkload(object.ObjectMeta, object.Spec.Template)
 			},
-			"ReplicaSet": func(reference interface{}) corev1.PodSpec {
-				return reference.(*extv1beta1.ReplicaSet).Spec.Template.Spec
+			"ReplicaSet": func(reference interface{}) *workload {
+				object := reference.(*extv1beta1.ReplicaSet)
+				return podWorkload(object.ObjectMeta, object.Spec.Template)
 			},
-			"Deployment": func(reference interface{}) corev1.PodSpec {
-				return reference.(*extv1beta1.Deployment).Spec.Template.Spec
+			"Deployment": func(reference interface{}) *workload {
+				object := reference.(*extv1beta1.Deployment)
+				return podWorkload(object.ObjectMeta, object.Spec.Template)
 			},
 		},
 	},
 }
 
+// kinds the vendored types only know by an older, but compatible, version
+var apiVersionAliases = map[string]string{
+	"batch/v1/CronJob": "batch/v1beta1",
+}
+
+// lookupWorkload finds the workload in a manifest, typed if it's a kind in GroupMap and unstructured otherwise
+func lookupWorkload(rawObj map[string]interface{}) (*workload, error) {
+	key := fmt.Sprintf("%s/%s", rawObj["apiVersion"], rawObj["kind"])
+
+	if _, ok := extraKinds[key]; ok {
+		return lookupUnstructured(rawObj)
+	}
+
+	if alias, ok := apiVersionAliases[key]; ok {
+		rawObj["apiVersion"] = alias
+	}
+
+	rawJson, err := json.Marshal(rawObj)
+	if err != nil {
+		return nil, err
+	}
+
+	decode := scheme.Codecs.UniversalDeserializer().Decode
+
+	obj, groupVersionKind, err := decode(rawJson, nil, nil)
+
+	if runtime.IsNotRegisteredError(err) {
+		return lookupUnstructured(rawObj)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode pod spec: %v", err)
+	}
+
+	log.Debugf("%#v", groupVersionKind)
+
+	groupName := groupVersionKind.Group
+	if groupName == "" {
+		groupName = "core"
+	}
+
+	kind, ok := GroupMap[groupName][groupVersionKind.Version][groupVersionKind.Kind]
+
+	if !ok {
+		return lookupUnstructured(rawObj)
+	}
+
+	return kind(obj), nil
+}
+
 func main() {
+	flag.StringVar(&cloudProvider, "provider", "", "cloud the image will run on (aws, gcp or azure), used to find disk volumes")
+	csiDriversFile := flag.String("csi-drivers", "", "yaml file mapping CSI driver names to the onboot image which mounts their volumes")
+	kindsFile := flag.String("kinds", "", "yaml file listing where other kinds, like CRDs, keep their pod template")
+	flag.StringVar(&SCHEDULER_IMAGE, "scheduler-image", SCHEDULER_IMAGE, "image with podspec2linuxkit, which runs the scheduler of CronJobs")
+	ordinal := flag.Int("ordinal", 0, "ordinal of the StatefulSet pod to convert")
+	outputDir := flag.String("output-dir", "", "write <pod name>.yaml to this directory for every instance, e.g. each ordinal of a StatefulSet, instead of converting one to stdout")
+	merge := flag.Bool("merge", false, "convert every workload in the input into a single image, with names prefixed by the pod")
+	platform := flag.String("platform", "", fmt.Sprintf("merge the result into the base profile of a platform (%s) so it can be built on its own", strings.Join(platformNames(), ", ")))
+	platformDir := flag.String("platform-dir", "", "directory with <platform>.yaml files replacing the embedded profiles, and <platform>.d/*.yaml files extending them")
+	trustPolicyFile := flag.String("trust-policy", "", "yaml file listing the images and orgs whose signatures are checked, and the orgs whose images must be signed")
+	rewriteRulesFile := flag.String("rewrite-rules", "", "yaml file of rules rewriting image references, e.g. to pull everything from a mirror")
+	pin := flag.String("pin", "", "pin images to the digest their tag resolves to, from the registry, an OCI image layout (oci:<dir>) or a lock file (lock:<file>)")
+	lockFile := flag.String("lock", "podspec2linuxkit.lock", "lock file recording the digests --pin pinned images to, which are used as long as it exists")
+	updateLock := flag.Bool("update-lock", false, "with --pin, resolve every image again and rewrite the lock file with just those")
+	inspect := flag.String("inspect", "", "read the configs of images, from their registry or an OCI image layout (oci:<dir>), for their user, entrypoint, env, working directory and org.mobyproject.config label")
+	inspectCache := flag.String("inspect-cache", defaultInspectCache(), "directory keeping the configs of inspected images, so they are only read once")
+	dockerConfig := flag.String("docker-config", "", "directory to write a config.json to, with the credentials of the imagePullSecrets in the input, for linuxkit build")
+	buildEnv := flag.Bool("print-build-env", false, "print the shell setting DOCKER_CONFIG to --docker-config, instead of anything else on stdout, so manifests have to go to --output-dir")
+	baseFiles := flag.String("base", "", "comma separated LinuxKit yaml files, like templates/base_image.yaml, to merge the result into so it can be built on its own")
+	flag.Parse()
+
+	if flag.NArg() > 0 {
+		switch flag.Arg(0) {
+		case "cron-scheduler":
+			if err := cronSchedulerMain(flag.Args()[1:]); err != nil {
+				log.Errorf("CronJob scheduler failed: %v", err)
+				os.Exit(1)
+			}
+		case "validate":
+			if err := validateMain(flag.Args()[1:]); err != nil {
+				log.Errorf("%v", err)
+				os.Exit(1)
+			}
+		case "export-images":
+			if err := exportImagesMain(flag.Args()[1:]); err != nil {
+				log.Errorf("Failed to export images: %v", err)
+				os.Exit(1)
+			}
+		default:
+			log.Errorf("Unknown command: %s", flag.Arg(0))
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *buildEnv && (*dockerConfig == "" || *outputDir == "") {
+		log.Errorf("--print-build-env needs --docker-config and --output-dir")
+		os.Exit(1)
+	}
+
+	if *csiDriversFile != "" {
+		if err := loadCSIDrivers(*csiDriversFile); err != nil {
+			log.Errorf("Failed to load CSI drivers: %v", err)
+			os.Exit(1)
+		}
+	}
+
+	if *kindsFile != "" {
+		if err := loadKinds(*kindsFile); err != nil {
+			log.Errorf("Failed to load kinds: %v", err)
+			os.Exit(1)
+		}
+	}
+
+	if *platform != "" && cloudProvider == "" {
+		cloudProvider = platformProviders[*platform]
+	}
+
+	if *platform != "" || *baseFiles != "" {
+		files := []string{}
+		if *baseFiles != "" {
+			files = strings.Split(*baseFiles, ",")
+		}
+		if err := loadBase(*platform, *platformDir, files); err != nil {
+			log.Errorf("Failed to load base: %v", err)
+			os.Exit(1)
+		}
+	}
+
+	if *trustPolicyFile != "" {
+		if err := loadTrustPolicy(*trustPolicyFile); err != nil {
+			log.Errorf("Failed to load trust policy: %v", err)
+			os.Exit(1)
+		}
+	}
+
+	if *rewriteRulesFile != "" {
+		if err := loadRewriteRules(*rewriteRulesFile); err != nil {
+			log.Errorf("Failed to load rewrite rules: %v", err)
+			os.Exit(1)
+		}
+	}
+
+	if err := setupPinning(*pin, *lockFile, *updateLock); err != nil {
+		log.Errorf("Failed to set up pinning: %v", err)
+		os.Exit(1)
+	}
+
+	if *inspect != "" {
+		if err := setupInspection(*inspect, *inspectCache); err != nil {
+			log.Errorf("Failed to set up image inspection: %v", err)
+			os.Exit(1)
+		}
+	}
 
 	rawYaml, err := ioutil.ReadAll(os.Stdin)
 
@@ -388,9 +826,7 @@ func main() {
 		return
 	}
 
-	decode := scheme.Codecs.UniversalDeserializer().Decode
-
-	obj, groupVersionKind, err := decode(rawYaml, nil, nil)
+	objects, err := decodeObjects(rawYaml)
 
 	if err != nil {
 		log.Errorf("Failed to decode pod spec: %v", err)
@@ -398,37 +834,192 @@ func main() {
 		return
 	}
 
-	log.Debugf("%#v", groupVersionKind)
+	workloads := []*workload{}
+	rawObjs := []map[string]interface{}{}
+	for _, rawObj := range objects {
+		if ok, err := collectPullSecret(rawObj); ok {
+			if err != nil {
+				log.Errorf("%v", err)
+				os.Exit(1)
+			}
+			continue
+		}
 
-	group, ok := GroupMap[groupVersionKind.Group]
+		workload, err := lookupWorkload(rawObj)
+		if _, ok := err.(notWorkloadError); ok && len(objects) > 1 {
+			log.Infof("skipping %s, it has no pod template", workloadName(rawObj))
+			continue
+		}
+		if err != nil {
+			log.Errorf("%v", err)
+			os.Exit(1)
+		}
 
-	if !ok {
-		log.Errorf("Unknown Group: %s", group)
+		workloads = append(workloads, workload)
+		rawObjs = append(rawObjs, rawObj)
+	}
+
+	if len(workloads) == 0 {
+		log.Errorf("No workloads to convert")
 		os.Exit(1)
 	}
 
-	version, ok := group[groupVersionKind.Version]
+	// registry credentials are only ever written next to the manifests, never into the image
+	auths := registryAuths(workloads)
+	useRegistryCredentials(registryCredentials(auths))
+	if *dockerConfig != "" {
+		if err := writeDockerConfig(*dockerConfig, auths); err != nil {
+			log.Errorf("Failed to write docker config: %v", err)
+			os.Exit(1)
+		}
+	} else if len(auths) > 0 {
+		log.Warnf("linuxkit build needs the credentials of the imagePullSecrets, see --docker-config")
+	}
+
+	if *outputDir != "" {
+		// instance name to the workload it came from
+		written := map[string]string{}
+		for idx, workload := range workloads {
+			for _, ordinal := range workload.ordinals() {
+				name := workload.instanceName(ordinal)
+				if previous, ok := written[name]; ok {
+					log.Errorf("%s and %s would both be written to %s.yaml", previous, workloadName(rawObjs[idx]), name)
+					os.Exit(1)
+				}
+				written[name] = workloadName(rawObjs[idx])
 
-	if !ok {
-		log.Errorf("Unknown Group/Version %s/%s", groupVersionKind.Group, groupVersionKind.Version)
+				foo, err := workloadToLinuxKit(workload, rawObjs[idx], ordinal, "")
+				if err == nil {
+					foo, err = finishConfig(foo)
+				}
+				if err != nil {
+					log.Errorf("Failed to convert %s: %v", name, err)
+					os.Exit(1)
+				}
+
+				if err := writeConfig(path.Join(*outputDir, name+".yaml"), foo); err != nil {
+					log.Errorf("Failed to write %s: %v", name, err)
+					os.Exit(1)
+				}
+			}
+		}
+		saveLock(*lockFile)
+
+		if *buildEnv {
+			if err := printBuildEnv(*dockerConfig); err != nil {
+				log.Errorf("Failed to print build environment: %v", err)
+				os.Exit(1)
+			}
+		}
+		return
+	}
+
+	if len(workloads) > 1 && !*merge {
+		log.Errorf("Found %d workloads, use --output-dir for an image each or --merge for one with all of them", len(workloads))
 		os.Exit(1)
 	}
 
-	kind, ok := version[groupVersionKind.Kind]
+	for _, workload := range workloads {
+		if ordinals := workload.ordinals(); len(ordinals) > 1 {
+			log.Infof("converting %s of %d instances, use --output-dir for all of them", workload.instanceName(int32(*ordinal)), len(ordinals))
+		}
+	}
 
-	if !ok {
-		log.Errorf("Unknown Group/Version/Kind %s/%s/%s", groupVersionKind.Group, groupVersionKind.Version, groupVersionKind.Kind)
-		os.Exit(1)
+	if len(workloads) == 1 {
+		foo, err := workloadToLinuxKit(workloads[0], rawObjs[0], int32(*ordinal), "")
+		if err == nil {
+			foo, err = finishConfig(foo)
+		}
+		if err != nil {
+			log.Errorf("Failed to convert: %v", err)
+			os.Exit(1)
+		} else {
+			encoder := yaml.NewEncoder(os.Stdout)
+			encoder.Encode(foo)
+		}
+		saveLock(*lockFile)
+		return
 	}
 
-	podSpec := kind(obj)
+	if len(workloads) > 1 {
+		log.Warnf("The merged pods share the VM's network, so they can reach each other and can't listen on the same port")
+	}
+
+	merged := &linuxkit.Moby{Services: &[]*linuxkit.Image{}}
+	// what is already in the merged image, by section and name, to the workload it came from
+	owners := map[string]string{}
+	for idx, workload := range workloads {
+		name := workload.instanceName(int32(*ordinal))
+		if workload.job != nil {
+			log.Warnf("%s powers off the VM when it completes, stopping everything else in it", workloadName(rawObjs[idx]))
+		}
+
+		foo, err := workloadToLinuxKit(workload, rawObjs[idx], int32(*ordinal), name)
+		if err != nil {
+			log.Errorf("Failed to convert %s: %v", workloadName(rawObjs[idx]), err)
+			os.Exit(1)
+		}
+
+		if err := mergeConfig(merged, foo, workloadName(rawObjs[idx]), owners); err != nil {
+			log.Errorf("Failed to merge: %v", err)
+			os.Exit(1)
+		}
+	}
 
-	foo, err := podSpec2LinuxKit(&podSpec)
+	merged, err = finishConfig(merged)
 	if err != nil {
-		log.Errorf("Failed to convert: %v", err)
+		log.Errorf("Failed to merge: %v", err)
 		os.Exit(1)
-	} else {
-		encoder := yaml.NewEncoder(os.Stdout)
-		encoder.Encode(foo)
 	}
+
+	encoder := yaml.NewEncoder(os.Stdout)
+	encoder.Encode(merged)
+	saveLock(*lockFile)
+}
+
+// workloadName is how a workload is referred to in messages
+func workloadName(obj map[string]interface{}) string {
+	name, _, _ := unstructured.NestedString(obj, "metadata", "name")
+	return fmt.Sprintf("%s %s", obj["kind"], name)
+}
+
+// finishConfig turns a converted config into what is written out
+func finishConfig(config *linuxkit.Moby) (*linuxkit.Moby, error) {
+	config, err := withBase(config)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := normalizeImages(config); err != nil {
+		return nil, err
+	}
+
+	if err := mergeImageLabels(config); err != nil {
+		return nil, err
+	}
+
+	if err := applyTrust(config); err != nil {
+		return nil, err
+	}
+
+	// what linuxkit build would refuse is better found now than when building
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("the result isn't valid:\n%v", err)
+	}
+
+	return config, nil
+}
+
+func writeConfig(file string, config *linuxkit.Moby) error {
+	if err := os.MkdirAll(path.Dir(file), 0755); err != nil {
+		return err
+	}
+
+	out, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	return yaml.NewEncoder(out).Encode(config)
 }
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"fmt"
+	"github.com/tjfontaine/podspec2linuxkit/pkg/linuxkit"
+	"io/ioutil"
+	corev1 "k8s.io/api/core/v1"
+	"net"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"testing"
+)
+
+// runBootScript runs the script of a bootScriptImage with root standing in for the host's root filesystem, and a
+// fail which exits instead of powering off
+func runBootScript(image *linuxkit.Image, root string) ([]byte, error) {
+	script := strings.TrimPrefix((*image.Command)[2], bootScriptPrelude)
+	script = strings.Replace(script, "'"+hostRoot+"/", "'"+root+"/", -1)
+	return exec.Command("/bin/sh", "-c", "fail() { echo \"$*\" >&2; exit 1; }\n"+script).CombinedOutput()
+}
+
+func TestHostPathVolume(t *testing.T) {
+	root, err := ioutil.TempDir("", "hostpath")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	for _, dir := range []string{"srv/data", "etc"} {
+		if err := os.MkdirAll(path.Join(root, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(path.Join(root, "etc/app.conf"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.Listen("unix", path.Join(root, "srv/app.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	for _, test := range []struct {
+		path     string
+		hostType corev1.HostPathType
+		// whether there is an onboot step, and whether it fails
+		script bool
+		fails  bool
+		// what has to exist afterwards
+		dir, file string
+	}{
+		{path: "/srv/data", hostType: corev1.HostPathUnset},
+		{path: "/srv/data", hostType: corev1.HostPathDirectory, script: true},
+		{path: "/srv/missing", hostType: corev1.HostPathDirectory, script: true, fails: true},
+		{path: "/etc/app.conf", hostType: corev1.HostPathDirectory, script: true, fails: true},
+		{path: "/srv/new/dir", hostType: corev1.HostPathDirectoryOrCreate, script: true, dir: "srv/new/dir"},
+		{path: "/etc/app.conf", hostType: corev1.HostPathDirectoryOrCreate, script: true, fails: true},
+		{path: "/etc/app.conf", hostType: corev1.HostPathFile, script: true},
+		{path: "/srv/data", hostType: corev1.HostPathFile, script: true, fails: true},
+		{path: "/etc/new.conf", hostType: corev1.HostPathFileOrCreate, script: true, file: "etc/new.conf"},
+		{path: "/srv/data", hostType: corev1.HostPathFileOrCreate, script: true, fails: true},
+		{path: "/srv/app.sock", hostType: corev1.HostPathSocket, script: true},
+		{path: "/etc/app.conf", hostType: corev1.HostPathSocket, script: true, fails: true},
+		{path: "/etc/app.conf", hostType: corev1.HostPathCharDev, script: true, fails: true},
+		{path: "/etc/app.conf", hostType: corev1.HostPathBlockDev, script: true, fails: true},
+	} {
+		name := string(test.hostType) + " " + test.path
+		hostType := test.hostType
+		pod := newPodContext(&corev1.PodTemplateSpec{}, nil, nil)
+		images, err := volumeToLinuxKitMount(pod, &corev1.Volume{
+			Name:         "host",
+			VolumeSource: corev1.VolumeSource{HostPath: &corev1.HostPathVolumeSource{Path: test.path, Type: &hostType}},
+		})
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if pod.volumeMap["host"] != test.path {
+			t.Errorf("%s: got volume at %s, want %s", name, pod.volumeMap["host"], test.path)
+		}
+		if len(images) == 0 {
+			if test.script {
+				t.Errorf("%s: expected an onboot step", name)
+			}
+			continue
+		}
+		if !test.script {
+			t.Errorf("%s: expected no onboot step", name)
+			continue
+		}
+
+		out, err := runBootScript(images[0], root)
+		if test.fails != (err != nil) {
+			t.Errorf("%s: got %v (%s), want failure %v", name, err, out, test.fails)
+		}
+		if test.dir != "" {
+			if info, err := os.Stat(path.Join(root, test.dir)); err != nil || !info.IsDir() {
+				t.Errorf("%s: expected %s to be created as a directory", name, test.dir)
+			}
+		}
+		if test.file != "" {
+			if info, err := os.Stat(path.Join(root, test.file)); err != nil || !info.Mode().IsRegular() {
+				t.Errorf("%s: expected %s to be created as a file", name, test.file)
+			}
+		}
+	}
+
+	fifo := corev1.HostPathType("Fifo")
+	for _, source := range []corev1.HostPathVolumeSource{
+		{Path: "srv/data"},
+		{Path: ""},
+		{Path: "/srv/data", Type: &fifo},
+	} {
+		source := source
+		pod := newPodContext(&corev1.PodTemplateSpec{}, nil, nil)
+		if _, err := volumeToLinuxKitMount(pod, &corev1.Volume{Name: "host", VolumeSource: corev1.VolumeSource{HostPath: &source}}); err == nil {
+			t.Errorf("%q: expected an error", source.Path)
+		}
+	}
+}
+
+func TestContainerCapabilities(t *testing.T) {
+	template := &corev1.PodTemplateSpec{Spec: corev1.PodSpec{Containers: []corev1.Container{
+		{Name: "admin", Image: "alpine:3.8", SecurityContext: &corev1.SecurityContext{Capabilities: &corev1.Capabilities{
+			Add:  []corev1.Capability{"SYS_ADMIN"},
+			Drop: []corev1.Capability{"NET_RAW"},
+		}}},
+		{Name: "plain", Image: "alpine:3.8"},
+	}}}
+
+	config, err := podSpec2LinuxKit(template, map[string]interface{}{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	capabilities := map[string]string{}
+	for _, image := range *config.Services {
+		capabilities[image.Name] = strings.Join(*image.Capabilities, " ")
+	}
+	for name, want := range map[string]struct{ has, lacks string }{
+		"container-admin": {has: "CAP_SYS_ADMIN", lacks: "CAP_NET_RAW"},
+		// what one container adds or drops doesn't leak into the next
+		"container-plain": {has: "CAP_NET_RAW", lacks: "CAP_SYS_ADMIN"},
+	} {
+		if !strings.Contains(capabilities[name], want.has) || strings.Contains(capabilities[name], want.lacks) {
+			t.Errorf("%s: got %s, want %s and not %s", name, capabilities[name], want.has, want.lacks)
+		}
+	}
+
+	if _, ok := DEFAULT_CAPABILITIES["CAP_SYS_ADMIN"]; ok || !DEFAULT_CAPABILITIES["CAP_NET_RAW"] {
+		t.Errorf("the default capabilities were changed: %v", DEFAULT_CAPABILITIES)
+	}
+}
+
+// describeWorkload describes what a kind contributed to a workload, e.g. "jobs/migrate migrate job backoffLimit=2"
+func describeWorkload(w *workload) string {
+	containers := []string{}
+	for _, container := range w.template.Spec.Containers {
+		containers = append(containers, container.Name)
+	}
+	description := fmt.Sprintf("%s/%s %s", w.template.Namespace, w.template.Name, strings.Join(containers, ","))
+
+	if w.job != nil {
+		description += " job"
+		if w.job.BackoffLimit != nil {
+			description += fmt.Sprintf(" backoffLimit=%d", *w.job.BackoffLimit)
+		}
+	}
+	if w.cronJob != nil {
+		description += fmt.Sprintf(" cronJob %q %s", w.cronJob.schedule, w.cronJob.concurrencyPolicy)
+	}
+	if w.statefulSet != nil {
+		description += fmt.Sprintf(" statefulSet %s replicas=%d serviceName=%s claims=%d", w.statefulSet.name, w.statefulSet.replicas, w.statefulSet.serviceName, len(w.statefulSet.volumeClaimTemplates))
+	}
+	if w.rawSpec != nil {
+		description += " at " + strings.Join(w.rawSpec, ".")
+	}
+
+	return description
+}
+
+func TestLookupWorkload(t *testing.T) {
+	for _, test := range []struct {
+		manifest string
+		workload string
+		err      string
+	}{
+		{
+			manifest: `{apiVersion: apps/v1, kind: Deployment, metadata: {name: web, namespace: shop}, spec: {template: {spec: {containers: [{name: nginx, image: nginx}]}}}}`,
+			workload: "shop/web nginx",
+		},
+		{
+			manifest: `{apiVersion: apps/v1, kind: StatefulSet, metadata: {name: db}, spec: {replicas: 3, serviceName: db, template: {spec: {containers: [{name: postgres, image: postgres}]}},
+				volumeClaimTemplates: [{metadata: {name: data}, spec: {resources: {requests: {storage: 1Gi}}}}]}}`,
+			workload: "/db postgres statefulSet db replicas=3 serviceName=db claims=1",
+		},
+		{
+			manifest: `{apiVersion: apps/v1beta1, kind: StatefulSet, metadata: {name: db}, spec: {serviceName: db, template: {spec: {containers: [{name: postgres, image: postgres}]}}}}`,
+			workload: "/db postgres statefulSet db replicas=1 serviceName=db claims=0",
+		},
+		{
+			manifest: `{apiVersion: apps/v1beta2, kind: StatefulSet, metadata: {name: db}, spec: {replicas: 2, serviceName: db, template: {spec: {containers: [{name: postgres, image: postgres}]}}}}`,
+			workload: "/db postgres statefulSet db replicas=2 serviceName=db claims=0",
+		},
+		{
+			manifest: `{apiVersion: batch/v1, kind: Job, metadata: {name: migrate, namespace: jobs}, spec: {backoffLimit: 2, template: {spec: {containers: [{name: migrate, image: migrate}]}}}}`,
+			workload: "jobs/migrate migrate job backoffLimit=2",
+		},
+		{
+			manifest: `{apiVersion: batch/v1beta1, kind: CronJob, metadata: {name: backup}, spec: {schedule: "0 3 * * *", concurrencyPolicy: Forbid,
+				jobTemplate: {spec: {template: {spec: {containers: [{name: dump, image: postgres}]}}}}}}`,
+			workload: `/backup dump job cronJob "0 3 * * *" Forbid`,
+		},
+		// batch/v1 is only known to the vendored types by batch/v1beta1
+		{
+			manifest: `{apiVersion: batch/v1, kind: CronJob, metadata: {name: backup}, spec: {schedule: "0 3 * * *",
+				jobTemplate: {metadata: {name: nightly}, spec: {backoffLimit: 1, template: {spec: {containers: [{name: dump, image: postgres}]}}}}}}`,
+			workload: `/nightly dump job backoffLimit=1 cronJob "0 3 * * *" `,
+		},
+		{
+			manifest: `{apiVersion: batch/v2alpha1, kind: CronJob, metadata: {name: backup}, spec: {schedule: "@daily", concurrencyPolicy: Replace,
+				jobTemplate: {spec: {template: {spec: {containers: [{name: dump, image: postgres}]}}}}}}`,
+			workload: `/backup dump job cronJob "@daily" Replace`,
+		},
+		{
+			manifest: `{apiVersion: v1, kind: ReplicationController, metadata: {name: web}, spec: {replicas: 2, template: {spec: {containers: [{name: nginx, image: nginx}]}}}}`,
+			workload: "/web nginx",
+		},
+		{
+			manifest: `{apiVersion: v1, kind: ReplicationController, metadata: {name: web}, spec: {replicas: 2}}`,
+			workload: "/web ",
+		},
+		{
+			manifest: `{apiVersion: v1, kind: PodTemplate, metadata: {name: web, namespace: shop}, template: {metadata: {labels: {app: web}}, spec: {containers: [{name: nginx, image: nginx}]}}}`,
+			workload: "shop/web nginx",
+		},
+		{
+			manifest: `{apiVersion: v1, kind: Pod, metadata: {name: web}, spec: {containers: [{name: nginx, image: nginx}, {name: sidecar, image: busybox}]}}`,
+			workload: "/web nginx,sidecar",
+		},
+		{
+			manifest: `{apiVersion: v1, kind: Pod, metadata: {name: web}, spec: {containers: nginx}}`,
+			err:      "Failed to decode pod spec: ",
+		},
+		{
+			manifest: `{apiVersion: v1, kind: Service, metadata: {name: web}, spec: {ports: [{port: 80}]}}`,
+			err:      "v1/Service isn't a known kind, and has no pod spec where one is usually found, see --kinds",
+		},
+	} {
+		objects, err := decodeObjects([]byte(test.manifest))
+		if err != nil {
+			t.Fatalf("%s: %v", test.manifest, err)
+		}
+
+		w, err := lookupWorkload(objects[0])
+		if test.err != "" {
+			if err == nil || !strings.HasPrefix(err.Error(), test.err) {
+				t.Errorf("%s: got %v, want %s", test.manifest, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.manifest, err)
+			continue
+		}
+		if description := describeWorkload(w); description != test.workload {
+			t.Errorf("%s: got %q, want %q", test.manifest, description, test.workload)
+		}
+	}
+}
+
+func TestAPIVersionAliases(t *testing.T) {
+	// an alias is only needed, and only works, while the vendored types don't know the version but do know its alias
+	for key, alias := range apiVersionAliases {
+		kind := key[strings.LastIndex(key, "/")+1:]
+		version := strings.TrimSuffix(key, "/"+kind)
+		for _, apiVersion := range []string{version, alias} {
+			group, versionName := "core", apiVersion
+			if i := strings.Index(apiVersion, "/"); i >= 0 {
+				group, versionName = apiVersion[:i], apiVersion[i+1:]
+			}
+			_, known := GroupMap[group][versionName][kind]
+			if known != (apiVersion == alias) {
+				t.Errorf("%s: %s/%s is known %t", key, apiVersion, kind, known)
+			}
+		}
+	}
+}
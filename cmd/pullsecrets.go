@@ -0,0 +1,265 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	corev1 "k8s.io/api/core/v1"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// registryAuth is an entry of the auths of a docker config.json
+type registryAuth struct {
+	Auth          string `json:"auth,omitempty"`
+	Username      string `json:"username,omitempty"`
+	Password      string `json:"password,omitempty"`
+	IdentityToken string `json:"identitytoken,omitempty"`
+}
+
+// credentials returns the username and password of an entry, from auth when they aren't set on their own
+func (auth registryAuth) credentials() (string, string) {
+	if auth.Username != "" {
+		return auth.Username, auth.Password
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(auth.Auth)
+	if err != nil {
+		return "", ""
+	}
+	parts := strings.SplitN(string(decoded), ":", 2)
+	if len(parts) != 2 {
+		return "", ""
+	}
+	return parts[0], parts[1]
+}
+
+// the registry credentials of the dockerconfigjson and dockercfg Secrets in the input, by namespace/name and then by
+// registry host, see normalizeAuths
+var pullSecrets = map[string]map[string]registryAuth{}
+
+// collectPullSecret takes the credentials from a raw Secret, if it is one holding registry credentials
+func collectPullSecret(rawObj map[string]interface{}) (bool, error) {
+	if rawObj["kind"] != "Secret" {
+		return false, nil
+	}
+
+	encoded, err := json.Marshal(rawObj)
+	if err != nil {
+		return true, err
+	}
+
+	secret := &corev1.Secret{}
+	if err := json.Unmarshal(encoded, secret); err != nil {
+		return true, fmt.Errorf("Secret %s: %v", secret.Name, err)
+	}
+
+	data := secret.Data
+	for key, value := range secret.StringData {
+		if data == nil {
+			data = map[string][]byte{}
+		}
+		data[key] = []byte(value)
+	}
+
+	auths := map[string]registryAuth{}
+	switch secret.Type {
+	case corev1.SecretTypeDockerConfigJson:
+		config := struct {
+			Auths map[string]registryAuth `json:"auths"`
+		}{}
+		if err := json.Unmarshal(data[corev1.DockerConfigJsonKey], &config); err != nil {
+			return true, fmt.Errorf("Secret %s: %v", secret.Name, err)
+		}
+		auths = config.Auths
+	case corev1.SecretTypeDockercfg:
+		if err := json.Unmarshal(data[corev1.DockerConfigKey], &auths); err != nil {
+			return true, fmt.Errorf("Secret %s: %v", secret.Name, err)
+		}
+	default:
+		return true, nil
+	}
+
+	if auths, err = normalizeAuths(auths); err != nil {
+		return true, fmt.Errorf("Secret %s: %v", secret.Name, err)
+	}
+
+	namespace := secret.Namespace
+	if namespace == "" {
+		namespace = "default"
+	}
+	pullSecrets[namespace+"/"+secret.Name] = auths
+
+	return true, nil
+}
+
+// registryAuths merges the credentials of every imagePullSecret the workloads use, which are expected to be in
+// the input along with them. When two Secrets have credentials for the same registry, the first workload's first
+// Secret wins, the way the kubelet tries them in order.
+func registryAuths(workloads []*workload) map[string]registryAuth {
+	auths := map[string]registryAuth{}
+	// registry to the Secret its credentials came from
+	owners := map[string]string{}
+
+	for _, w := range workloads {
+		namespace := w.template.Namespace
+		if namespace == "" {
+			namespace = "default"
+		}
+
+		for _, ref := range w.template.Spec.ImagePullSecrets {
+			name := namespace + "/" + ref.Name
+			secret, ok := pullSecrets[name]
+			if !ok {
+				log.Warnf("%s: imagePullSecret %s isn't in the input, or doesn't hold registry credentials", w.template.Name, name)
+				continue
+			}
+
+			registries := []string{}
+			for registry := range secret {
+				registries = append(registries, registry)
+			}
+			sort.Strings(registries)
+
+			for _, registry := range registries {
+				if owner, ok := owners[registry]; ok && owner != name {
+					log.Warnf("%s and %s both have credentials for %s, using those of %s", owner, name, registry, owner)
+					continue
+				}
+				owners[registry] = name
+				auths[registry] = secret[registry]
+			}
+		}
+	}
+
+	return auths
+}
+
+// normalizeRegistry turns the keys of docker config auths, like https://index.docker.io/v1/, into a host name
+func normalizeRegistry(registry string) string {
+	registry = strings.TrimPrefix(strings.TrimPrefix(registry, "https://"), "http://")
+	registry = strings.SplitN(registry, "/", 2)[0]
+	if registry == "index.docker.io" || registry == "registry-1.docker.io" {
+		return "docker.io"
+	}
+	return registry
+}
+
+// the key of Docker Hub's credentials in a docker config.json, which is the one docker and linuxkit build look up
+const dockerHubAuthKey = "https://index.docker.io/v1/"
+
+// normalizeAuths keys docker config auths by the registry host they are for. Keys for the same host, like
+// https://index.docker.io/v1/ and docker.io, have to hold the same credentials, or which would be used is arbitrary.
+func normalizeAuths(auths map[string]registryAuth) (map[string]registryAuth, error) {
+	keys := []string{}
+	for key := range auths {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	normalized := map[string]registryAuth{}
+	// host to the key its credentials came from
+	sources := map[string]string{}
+	for _, key := range keys {
+		host := normalizeRegistry(key)
+		auth := auths[key]
+		if source, ok := sources[host]; ok {
+			existing := normalized[host]
+			username, password := existing.credentials()
+			otherUsername, otherPassword := auth.credentials()
+			if username != otherUsername || password != otherPassword || existing.IdentityToken != auth.IdentityToken {
+				return nil, fmt.Errorf("%s and %s have different credentials for %s", source, key, host)
+			}
+			continue
+		}
+		sources[host] = key
+		normalized[host] = auth
+	}
+
+	return normalized, nil
+}
+
+// registryCredentials looks up the credentials for a registry host, for resolving images from it
+func registryCredentials(auths map[string]registryAuth) func(host string) (string, string) {
+	return func(host string) (string, string) {
+		if auth, ok := auths[host]; ok {
+			return auth.credentials()
+		}
+		return "", ""
+	}
+}
+
+// writeDockerConfig writes the credentials, by registry host, to dir/config.json, for linuxkit build (through
+// DOCKER_CONFIG) and never into the image, readable only by the user running the conversion
+func writeDockerConfig(dir string, auths map[string]registryAuth) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	keyed := map[string]registryAuth{}
+	for host, auth := range auths {
+		if host == "docker.io" {
+			host = dockerHubAuthKey
+		}
+		keyed[host] = auth
+	}
+
+	encoded, err := json.MarshalIndent(struct {
+		Auths map[string]registryAuth `json:"auths"`
+	}{keyed}, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	file := path.Join(dir, "config.json")
+	out, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	// an existing file keeps its mode, so make sure it isn't readable by anyone else
+	if err := out.Chmod(0600); err != nil {
+		return err
+	}
+
+	_, err = out.Write(append(encoded, '\n'))
+	return err
+}
+
+// readDockerConfig reads the credentials in dir/config.json, like one written by writeDockerConfig, by registry host
+func readDockerConfig(dir string) (map[string]registryAuth, error) {
+	contents, err := ioutil.ReadFile(path.Join(dir, "config.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	config := struct {
+		Auths map[string]registryAuth `json:"auths"`
+	}{}
+	if err := json.Unmarshal(contents, &config); err != nil {
+		return nil, fmt.Errorf("%s: %v", path.Join(dir, "config.json"), err)
+	}
+
+	auths, err := normalizeAuths(config.Auths)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path.Join(dir, "config.json"), err)
+	}
+	return auths, nil
+}
+
+// printBuildEnv prints the shell to set up linuxkit build with the docker config
+func printBuildEnv(dir string) error {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("export DOCKER_CONFIG=%s\n", shellQuote(abs))
+	return nil
+}
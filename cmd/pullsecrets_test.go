@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeRegistry(t *testing.T) {
+	for _, test := range []struct{ key, host string }{
+		{"https://index.docker.io/v1/", "docker.io"},
+		{"index.docker.io", "docker.io"},
+		{"registry-1.docker.io", "docker.io"},
+		{"docker.io", "docker.io"},
+		{"https://gcr.io", "gcr.io"},
+		{"http://registry.internal:5000/v2/", "registry.internal:5000"},
+		{"quay.io/coreos", "quay.io"},
+	} {
+		if host := normalizeRegistry(test.key); host != test.host {
+			t.Errorf("%s: got %s, want %s", test.key, host, test.host)
+		}
+	}
+}
+
+func testSecret(namespace string, name string, secretType string, key string, contents string) map[string]interface{} {
+	return map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "Secret",
+		"metadata":   map[string]interface{}{"name": name, "namespace": namespace},
+		"type":       secretType,
+		"data":       map[string]interface{}{key: base64.StdEncoding.EncodeToString([]byte(contents))},
+	}
+}
+
+func TestCollectPullSecret(t *testing.T) {
+	defer func() { pullSecrets = map[string]map[string]registryAuth{} }()
+
+	auth := base64.StdEncoding.EncodeToString([]byte("robot:hunter2"))
+	for _, test := range []struct {
+		name   string
+		obj    map[string]interface{}
+		secret bool
+		// the secret collected, by namespace/name, with its registries in order
+		collected  string
+		registries string
+		err        string
+	}{
+		{
+			name:   "not a Secret",
+			obj:    map[string]interface{}{"kind": "ConfigMap", "metadata": map[string]interface{}{"name": "regcred"}},
+			secret: false,
+		},
+		{
+			name:   "a Secret of another type",
+			obj:    testSecret("", "regcred", "Opaque", "password", "hunter2"),
+			secret: true,
+		},
+		{
+			name:       "dockerconfigjson, in the default namespace",
+			obj:        testSecret("", "regcred", "kubernetes.io/dockerconfigjson", ".dockerconfigjson", `{"auths": {"https://index.docker.io/v1/": {"auth": "`+auth+`"}, "https://gcr.io": {"username": "_json_key", "password": "{}"}}}`),
+			secret:     true,
+			collected:  "default/regcred",
+			registries: "docker.io gcr.io",
+		},
+		{
+			name:       "dockercfg",
+			obj:        testSecret("apps", "legacy", "kubernetes.io/dockercfg", ".dockercfg", `{"quay.io": {"auth": "`+auth+`"}}`),
+			secret:     true,
+			collected:  "apps/legacy",
+			registries: "quay.io",
+		},
+		{
+			name:       "two keys for Docker Hub, with the same credentials",
+			obj:        testSecret("", "hub", "kubernetes.io/dockerconfigjson", ".dockerconfigjson", `{"auths": {"https://index.docker.io/v1/": {"auth": "`+auth+`"}, "docker.io": {"username": "robot", "password": "hunter2"}}}`),
+			secret:     true,
+			collected:  "default/hub",
+			registries: "docker.io",
+		},
+		{
+			name:   "two keys for Docker Hub, with different credentials",
+			obj:    testSecret("", "hub", "kubernetes.io/dockerconfigjson", ".dockerconfigjson", `{"auths": {"https://index.docker.io/v1/": {"auth": "`+auth+`"}, "registry-1.docker.io": {"username": "robot", "password": "other"}}}`),
+			secret: true,
+			err:    "Secret hub: https://index.docker.io/v1/ and registry-1.docker.io have different credentials for docker.io",
+		},
+		{
+			name:   "invalid config",
+			obj:    testSecret("", "broken", "kubernetes.io/dockerconfigjson", ".dockerconfigjson", `{"auths": [`),
+			secret: true,
+			err:    "Secret broken: unexpected end of JSON input",
+		},
+	} {
+		pullSecrets = map[string]map[string]registryAuth{}
+		secret, err := collectPullSecret(test.obj)
+		if secret != test.secret {
+			t.Errorf("%s: got secret %v", test.name, secret)
+		}
+		if test.err != "" {
+			if err == nil || err.Error() != test.err {
+				t.Errorf("%s: got %v, want %s", test.name, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+
+		if test.collected == "" {
+			if len(pullSecrets) != 0 {
+				t.Errorf("%s: got %v, want nothing collected", test.name, pullSecrets)
+			}
+			continue
+		}
+		registries := []string{}
+		for registry := range pullSecrets[test.collected] {
+			registries = append(registries, registry)
+		}
+		sort.Strings(registries)
+		if len(pullSecrets) != 1 || strings.Join(registries, " ") != test.registries {
+			t.Errorf("%s: got %v, want %s with %s", test.name, pullSecrets, test.collected, test.registries)
+		}
+		for _, auth := range pullSecrets[test.collected] {
+			if username, password := auth.credentials(); username == "" || password == "" {
+				t.Errorf("%s: got credentials %q and %q", test.name, username, password)
+			}
+		}
+	}
+}
+
+func TestRegistryCredentials(t *testing.T) {
+	defer func() { pullSecrets = map[string]map[string]registryAuth{} }()
+
+	pullSecrets = map[string]map[string]registryAuth{
+		"default/hub":     {"docker.io": {Username: "robot", Password: "hub"}},
+		"default/mirror":  {"docker.io": {Username: "robot", Password: "mirror"}, "gcr.io": {Username: "_json_key", Password: "{}"}},
+		"staging/regcred": {"quay.io": {Username: "robot", Password: "quay"}},
+	}
+	pulling := func(namespace string, secrets ...string) *workload {
+		refs := []corev1.LocalObjectReference{}
+		for _, secret := range secrets {
+			refs = append(refs, corev1.LocalObjectReference{Name: secret})
+		}
+		return &workload{template: corev1.PodTemplateSpec{
+			ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: namespace},
+			Spec:       corev1.PodSpec{ImagePullSecrets: refs},
+		}}
+	}
+
+	// the first Secret with credentials for a registry wins, whichever order the maps are in
+	for i := 0; i < 10; i++ {
+		credentials := registryCredentials(registryAuths([]*workload{
+			pulling("", "mirror", "missing"),
+			pulling("default", "hub"),
+			pulling("staging", "regcred"),
+		}))
+		for host, want := range map[string]string{"docker.io": "mirror", "gcr.io": "{}", "quay.io": "quay", "ghcr.io": ""} {
+			if _, password := credentials(host); password != want {
+				t.Errorf("%s: got %q, want %q", host, password, want)
+			}
+		}
+	}
+}
+
+func TestWriteDockerConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "docker-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	auths := map[string]registryAuth{
+		"docker.io": {Username: "robot", Password: "hub"},
+		"gcr.io":    {Username: "_json_key", Password: "{}"},
+	}
+	if err := writeDockerConfig(dir, auths); err != nil {
+		t.Fatal(err)
+	}
+
+	// Docker Hub is written out under the key docker looks it up with
+	contents, err := ioutil.ReadFile(path.Join(dir, "config.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(contents), `"https://index.docker.io/v1/"`) || strings.Contains(string(contents), `"docker.io"`) {
+		t.Errorf("got config.json:\n%s", contents)
+	}
+	if info, err := os.Stat(path.Join(dir, "config.json")); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("got mode %v (%v), want 0600", info.Mode(), err)
+	}
+
+	read, err := readDockerConfig(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(read) != 2 || read["docker.io"] != auths["docker.io"] || read["gcr.io"] != auths["gcr.io"] {
+		t.Errorf("got %v, want %v", read, auths)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
+)
+
+// The vendored k8s.io/api predates some of the fields we support (like subPathExpr or image volumes), and the typed
+// decoder drops what it doesn't know about, so those fields are read from the raw manifest instead.
+
+// rawPodSpecPaths are where a pod spec can be found in the kinds we know, most specific first
+var rawPodSpecPaths = [][]string{
+	{"spec", "jobTemplate", "spec", "template", "spec"},
+	{"spec", "template", "spec"},
+	{"template", "spec"},
+	{"spec"},
+}
+
+func decodeRaw(rawYaml []byte) (map[string]interface{}, error) {
+	obj := map[string]interface{}{}
+	err := yaml.Unmarshal(rawYaml, &obj)
+	return obj, err
+}
+
+// rawPodSpec finds the pod spec in a raw manifest
+func rawPodSpec(obj map[string]interface{}) map[string]interface{} {
+	for _, fields := range rawPodSpecPaths {
+		spec, ok, _ := unstructured.NestedMap(obj, fields...)
+		if ok {
+			if _, hasContainers := spec["containers"]; hasContainers {
+				return spec
+			}
+		}
+	}
+
+	return map[string]interface{}{}
+}
+
+// rawContainer finds the raw container or init container with the given name
+func (pod *podContext) rawContainer(name string) map[string]interface{} {
+	for _, field := range []string{"initContainers", "containers"} {
+		containers, _, _ := unstructured.NestedSlice(pod.raw, field)
+		for _, container := range containers {
+			container, ok := container.(map[string]interface{})
+			if ok && container["name"] == name {
+				return container
+			}
+		}
+	}
+
+	return map[string]interface{}{}
+}
+
+// rawVolumeMount returns the idx'th volume mount of a raw container
+func rawVolumeMount(container map[string]interface{}, idx int) map[string]interface{} {
+	mounts, _, _ := unstructured.NestedSlice(container, "volumeMounts")
+	if idx < len(mounts) {
+		if mount, ok := mounts[idx].(map[string]interface{}); ok {
+			return mount
+		}
+	}
+
+	return map[string]interface{}{}
+}
+
+// rawVolume finds the raw volume with the given name
+func (pod *podContext) rawVolume(name string) map[string]interface{} {
+	volumes, _, _ := unstructured.NestedSlice(pod.raw, "volumes")
+	for _, volume := range volumes {
+		volume, ok := volume.(map[string]interface{})
+		if ok && volume["name"] == name {
+			return volume
+		}
+	}
+
+	return map[string]interface{}{}
+}
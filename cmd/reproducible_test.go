@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	digest "github.com/opencontainers/go-digest"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+// a Deployment using the pod's UID, which is only known when converting, and the pod IP, which only is at boot
+const reproducibleManifest = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+spec:
+  template:
+    metadata:
+      labels: {app: web}
+    spec:
+      initContainers:
+      - name: setup
+        image: busybox:1.29
+        command: ["sh", "-c", "echo $POD_UID > /data/uid"]
+        env:
+        - name: POD_UID
+          valueFrom: {fieldRef: {fieldPath: metadata.uid}}
+        volumeMounts: [{name: data, mountPath: /data}]
+      containers:
+      - name: nginx
+        image: nginx:1.15.4
+        env:
+        - name: POD_UID
+          valueFrom: {fieldRef: {fieldPath: metadata.uid}}
+        - name: POD_IP
+          valueFrom: {fieldRef: {fieldPath: status.podIP}}
+        volumeMounts: [{name: data, mountPath: /data}, {name: info, mountPath: /etc/podinfo}]
+      volumes:
+      - name: data
+        emptyDir: {}
+      - name: info
+        downwardAPI:
+          items:
+          - path: uid
+            fieldRef: {fieldPath: metadata.uid}
+          - path: labels
+            fieldRef: {fieldPath: metadata.labels}
+`
+
+// convertManifest converts a manifest of a single workload the way main does, to a file
+func convertManifest(manifest string, file string) error {
+	objects, err := decodeObjects([]byte(manifest))
+	if err != nil {
+		return err
+	}
+	w, err := lookupWorkload(objects[0])
+	if err != nil {
+		return err
+	}
+
+	config, err := workloadToLinuxKit(w, objects[0], 0, "")
+	if err == nil {
+		config, err = finishConfig(config)
+	}
+	if err != nil {
+		return err
+	}
+
+	return writeConfig(file, config)
+}
+
+func TestReproducibleOutput(t *testing.T) {
+	defer func() { imageLock, imageResolver = nil, nil }()
+
+	dir, err := ioutil.TempDir("", "reproducible")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// what the images resolve to, standing in for their registry
+	source := path.Join(dir, "source.lock")
+	images := ""
+	for _, image := range []string{"docker.io/library/busybox:1.29", "docker.io/library/busybox:latest", "docker.io/library/nginx:1.15.4"} {
+		images += fmt.Sprintf("  %s: %s\n", image, digest.FromString(image))
+	}
+	if err := ioutil.WriteFile(source, []byte("images:\n"+images), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// the first conversion creates the lock file, the others only use it
+	lockFile := path.Join(dir, "podspec2linuxkit.lock")
+	outputs := [][]byte{}
+	for i := 0; i < 3; i++ {
+		imageLock, imageResolver = nil, nil
+		if err := setupPinning("lock:"+source, lockFile, false); err != nil {
+			t.Fatal(err)
+		}
+		if i > 0 && imageLock.Update {
+			t.Fatalf("conversion %d: the lock file isn't used", i+1)
+		}
+
+		file := path.Join(dir, fmt.Sprintf("web-%d.yaml", i))
+		if err := convertManifest(reproducibleManifest, file); err != nil {
+			t.Fatalf("conversion %d: %v", i+1, err)
+		}
+		saveLock(lockFile)
+
+		output, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		outputs = append(outputs, output)
+	}
+
+	uid := string(podUID("Deployment", "default", "web", ""))
+	if !bytes.Contains(outputs[0], []byte(uid)) || !bytes.Contains(outputs[0], []byte("@sha256:")) {
+		t.Errorf("got:\n%s\nwant the pod UID %s and pinned images", outputs[0], uid)
+	}
+	for i, output := range outputs[1:] {
+		if !bytes.Equal(output, outputs[0]) {
+			t.Errorf("conversion %d differs from the first:\n%s\nand:\n%s", i+2, output, outputs[0])
+		}
+	}
+}
+
+func TestNginxExample(t *testing.T) {
+	dir, err := ioutil.TempDir("", "example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	manifest, err := ioutil.ReadFile("../examples/nginx-deployment.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := path.Join(dir, "nginx-linuxkit.yaml")
+	if err := convertManifest(string(manifest), file); err != nil {
+		t.Fatal(err)
+	}
+
+	converted, _ := ioutil.ReadFile(file)
+	example, err := ioutil.ReadFile("../examples/nginx-linuxkit.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(converted, example) {
+		t.Errorf("examples/nginx-linuxkit.yaml differs from what examples/nginx-deployment.yaml converts to:\n%s", converted)
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"github.com/containerd/containerd/reference"
+	log "github.com/sirupsen/logrus"
+	"github.com/tjfontaine/podspec2linuxkit/pkg/linuxkit"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"regexp"
+	"strings"
+)
+
+// the annotations a rewritten image records where it came from with
+const (
+	originalImageAnnotation = "podspec2linuxkit/original-image"
+	rewriteRuleAnnotation   = "podspec2linuxkit/rewrite-rule"
+)
+
+// rewriteRule replaces image references which match it, compared in full, e.g. docker.io/library/busybox:latest
+type rewriteRule struct {
+	// an exact reference, replaced as a whole
+	Exact string `yaml:"exact,omitempty"`
+	// or the start of references, replaced by Replace
+	Prefix string `yaml:"prefix,omitempty"`
+	// or a regular expression, whose matches are replaced by Replace, which can refer to its groups as $1
+	Regex   string `yaml:"regex,omitempty"`
+	Replace string `yaml:"replace"`
+
+	regex *regexp.Regexp
+}
+
+// rewriteRules are set by --rewrite-rules, and the first that matches an image is applied to it
+var rewriteRules []*rewriteRule
+
+func loadRewriteRules(file string) error {
+	contents, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	rules := []*rewriteRule{}
+	if err := yaml.UnmarshalStrict(contents, &rules); err != nil {
+		return err
+	}
+
+	for idx, rule := range rules {
+		matchers := 0
+		for _, matcher := range []string{rule.Exact, rule.Prefix, rule.Regex} {
+			if matcher != "" {
+				matchers++
+			}
+		}
+		if matchers != 1 {
+			return fmt.Errorf("rule %d needs exactly one of exact, prefix or regex", idx+1)
+		}
+		if rule.Replace == "" {
+			return fmt.Errorf("rule %d needs a replacement", idx+1)
+		}
+
+		if rule.Regex != "" {
+			if rule.regex, err = regexp.Compile(rule.Regex); err != nil {
+				return fmt.Errorf("rule %d: %v", idx+1, err)
+			}
+		}
+	}
+
+	rewriteRules = rules
+	return nil
+}
+
+func (rule *rewriteRule) String() string {
+	switch {
+	case rule.Exact != "":
+		return fmt.Sprintf("exact %s", rule.Exact)
+	case rule.Prefix != "":
+		return fmt.Sprintf("prefix %s", rule.Prefix)
+	}
+	return fmt.Sprintf("regex %s", rule.Regex)
+}
+
+// apply returns what a reference is rewritten to, if the rule matches it
+func (rule *rewriteRule) apply(ref string) (string, bool) {
+	switch {
+	case rule.Exact != "":
+		return rule.Replace, ref == rule.Exact
+	case rule.Prefix != "":
+		return rule.Replace + strings.TrimPrefix(ref, rule.Prefix), strings.HasPrefix(ref, rule.Prefix)
+	}
+	return rule.regex.ReplaceAllString(ref, rule.Replace), rule.regex.MatchString(ref)
+}
+
+// rewriteReference applies the first rule matching a reference, and returns it
+func rewriteReference(ref *reference.Spec) (*rewriteRule, error) {
+	original := ref.String()
+	for _, rule := range rewriteRules {
+		rewritten, ok := rule.apply(original)
+		if !ok {
+			continue
+		}
+
+		parsed, err := linuxkit.ParseReference(rewritten)
+		if err != nil {
+			return nil, fmt.Errorf("rule %s rewrote %s: %v", rule, original, err)
+		}
+		*ref = parsed
+		return rule, nil
+	}
+
+	return nil, nil
+}
+
+// rewriteImage applies the first rule matching a reference, recording the original reference and the rule on the
+// image it belongs to, which the kernel and init don't have
+func rewriteImage(ref *reference.Spec, image *linuxkit.Image) error {
+	original := ref.String()
+	rule, err := rewriteReference(ref)
+	if err != nil || rule == nil {
+		return err
+	}
+
+	if image == nil {
+		log.Infof("rewrote %s to %s", original, ref.String())
+		return nil
+	}
+
+	if image.Annotations == nil {
+		image.Annotations = &map[string]string{}
+	}
+	(*image.Annotations)[originalImageAnnotation] = original
+	(*image.Annotations)[rewriteRuleAnnotation] = rule.String()
+	return nil
+}
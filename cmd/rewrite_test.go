@@ -0,0 +1,117 @@
+package main
+
+import (
+	"github.com/tjfontaine/podspec2linuxkit/pkg/linuxkit"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestRewriteReference(t *testing.T) {
+	defer func() { rewriteRules = nil }()
+
+	if err := loadRewriteRules("../examples/rewrite-rules.yaml"); err != nil {
+		t.Fatal(err)
+	}
+
+	pinned := "@sha256:0123456789012345678901234567890123456789012345678901234567890123"
+	for _, test := range []struct {
+		image string
+		// what it is rewritten to, and by which rule, if any
+		rewritten string
+		rule      string
+	}{
+		// references are compared in full
+		{"busybox", "mirror.internal/tools/busybox:1.29", "exact docker.io/library/busybox:latest"},
+		{"docker.io/library/busybox:latest", "mirror.internal/tools/busybox:1.29", "exact docker.io/library/busybox:latest"},
+		// which an exact rule doesn't match with another tag or a digest, the regex after it does
+		{"busybox:1.29", "mirror.internal/dockerhub/library/busybox:1.29", "regex ^docker\\.io/(.*)$"},
+		{"busybox:latest" + pinned, "mirror.internal/dockerhub/library/busybox:latest" + pinned, "regex ^docker\\.io/(.*)$"},
+		// the first matching rule wins, though a later one matches too
+		{"linuxkit/kernel:4.19.8", "mirror.internal/linuxkit/kernel:4.19.8", "prefix docker.io/linuxkit/"},
+		{"nginx:1.15", "mirror.internal/dockerhub/library/nginx:1.15", "regex ^docker\\.io/(.*)$"},
+		{"gcr.io/google-containers/pause:3.1", "mirror.internal/gcr.io/google-containers/pause:3.1", "regex ^(gcr\\.io|quay\\.io)/(.*)$"},
+		{"quay.io/coreos/etcd:v3.3" + pinned, "mirror.internal/quay.io/coreos/etcd:v3.3" + pinned, "regex ^(gcr\\.io|quay\\.io)/(.*)$"},
+		// nothing matches
+		{"mirror.internal/tools/busybox:1.29", "mirror.internal/tools/busybox:1.29", ""},
+		{"registry.example.com/docker.io/app:v1", "registry.example.com/docker.io/app:v1", ""},
+	} {
+		ref, err := linuxkit.ParseReference(test.image)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rule, err := rewriteReference(&ref)
+		if err != nil {
+			t.Errorf("%s: %v", test.image, err)
+			continue
+		}
+		matched := ""
+		if rule != nil {
+			matched = rule.String()
+		}
+		if ref.String() != test.rewritten || matched != test.rule {
+			t.Errorf("%s: got %s by %q, want %s by %q", test.image, ref.String(), matched, test.rewritten, test.rule)
+		}
+	}
+
+	// a rewrite to something which isn't a reference fails
+	rewriteRules = []*rewriteRule{{Prefix: "docker.io/library/", Replace: "Mirror/"}}
+	ref, _ := linuxkit.ParseReference("busybox")
+	if _, err := rewriteReference(&ref); err == nil {
+		t.Errorf("got %s, expected an error", ref.String())
+	}
+}
+
+func TestLoadRewriteRules(t *testing.T) {
+	defer func() { rewriteRules = nil }()
+
+	dir, err := ioutil.TempDir("", "rewrite")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, rules := range []string{
+		"- replace: mirror.internal/\n",
+		"- exact: busybox\n  prefix: docker.io/\n  replace: mirror.internal/\n",
+		"- prefix: docker.io/\n",
+		"- regex: ^docker.io/(\n  replace: mirror.internal/\n",
+		"- prefix: docker.io/\n  replacement: mirror.internal/\n",
+	} {
+		file := path.Join(dir, "rules.yaml")
+		if err := ioutil.WriteFile(file, []byte(rules), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := loadRewriteRules(file); err == nil {
+			t.Errorf("%q: expected an error", rules)
+		}
+	}
+}
+
+func TestRewriteImage(t *testing.T) {
+	defer func() { rewriteRules = nil }()
+	rewriteRules = []*rewriteRule{{Prefix: "docker.io/library/", Replace: "mirror.internal/library/"}}
+
+	image := &linuxkit.Image{Name: "web"}
+	ref, _ := linuxkit.ParseReference("nginx:1.15")
+	if err := rewriteImage(&ref, image); err != nil {
+		t.Fatal(err)
+	}
+	if ref.String() != "mirror.internal/library/nginx:1.15" || image.Annotations == nil ||
+		(*image.Annotations)[originalImageAnnotation] != "docker.io/library/nginx:1.15" ||
+		(*image.Annotations)[rewriteRuleAnnotation] != "prefix docker.io/library/" {
+		t.Errorf("got %s, annotated %v", ref.String(), image.Annotations)
+	}
+
+	// the kernel and init have no image to annotate
+	ref, _ = linuxkit.ParseReference("linuxkit/kernel:4.19.8")
+	if err := rewriteImage(&ref, nil); err != nil || ref.String() != "docker.io/linuxkit/kernel:4.19.8" {
+		t.Errorf("got %s (%v)", ref.String(), err)
+	}
+	ref, _ = linuxkit.ParseReference("alpine:3.8")
+	if err := rewriteImage(&ref, nil); err != nil || ref.String() != "mirror.internal/library/alpine:3.8" {
+		t.Errorf("got %s (%v)", ref.String(), err)
+	}
+}
@@ -0,0 +1,402 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"github.com/tjfontaine/podspec2linuxkit/pkg/cron"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// the containerd namespace LinuxKit runs services in
+const servicesNamespace = "services.linuxkit"
+
+// where, on the host, the scheduler keeps the bundles of the job's containers, and those of each run
+const cronJobDir = "/containers/cronjob"
+
+// how often the scheduler looks at the schedule and its runs
+const schedulerInterval = time.Second
+
+// cronRun is a job the scheduler started, and the state of its containers
+type cronRun struct {
+	id        string
+	scheduled time.Time
+	started   time.Time
+	failures  int32
+	// containers which completed
+	done map[string]bool
+	// containers waiting to be restarted after failing, and when
+	retry map[string]time.Time
+}
+
+type cronScheduler struct {
+	config   cronSchedulerConfig
+	schedule *cron.Schedule
+	policy   cron.Policy
+	last     time.Time
+	active   []*cronRun
+	finished []cron.Run
+	// the run that was last blocked by Forbid, so it's only logged once
+	blocked time.Time
+	// where the host's root filesystem is mounted
+	root string
+}
+
+// hostCommand runs a command of the host, where the LinuxKit tools and containerd are
+var hostCommand = func(args ...string) *exec.Cmd {
+	return exec.Command("chroot", append([]string{hostRoot}, args...)...)
+}
+
+// ctr runs ctr against the namespace the CronJob's containers run in, returning its output and exit status
+func (s *cronScheduler) ctr(args ...string) ([]byte, int, error) {
+	namespace := s.config.Namespace
+	if namespace == "" {
+		namespace = servicesNamespace
+	}
+
+	cmd := hostCommand(append([]string{"/usr/bin/ctr", "--namespace", namespace}, args...)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return out, exitErr.Sys().(syscall.WaitStatus).ExitStatus(), nil
+	}
+	if err != nil {
+		return nil, -1, fmt.Errorf("ctr %s: %v %s", strings.Join(args, " "), err, stderr.String())
+	}
+	return out, 0, nil
+}
+
+// taskStatuses returns the status of each task in the CronJob's namespace
+func (s *cronScheduler) taskStatuses() (map[string]string, error) {
+	out, _, err := s.ctr("task", "ls")
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 3 && fields[0] != "TASK" {
+			statuses[fields[0]] = fields[2]
+		}
+	}
+
+	return statuses, scanner.Err()
+}
+
+func copyFile(from string, to string) error {
+	contents, err := ioutil.ReadFile(from)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(to, contents, 0644)
+}
+
+// claimBundles moves the job's containers out of onshutdown, so they are only ever run by the scheduler
+func (s *cronScheduler) claimBundles() error {
+	if err := os.MkdirAll(path.Join(s.root, cronJobDir, "runs"), 0755); err != nil {
+		return err
+	}
+
+	for _, container := range s.config.Containers {
+		bundle := path.Join(s.root, cronJobDir, container)
+		if _, err := os.Stat(bundle); err == nil {
+			// claimed before the scheduler was restarted
+			continue
+		}
+
+		matches, _ := filepath.Glob(path.Join(s.root, "containers", "onshutdown", "*-"+container))
+		if len(matches) != 1 {
+			return fmt.Errorf("no onshutdown bundle for container %s", container)
+		}
+
+		if err := os.Rename(matches[0], bundle); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *cronScheduler) taskID(run *cronRun, container string) string {
+	return fmt.Sprintf("%s-%s", run.id, container)
+}
+
+// startContainer starts a container of a run as a LinuxKit service, with a copy of the rootfs of the job's, so no
+// run sees what another wrote to it. A copy rather than an overlay, whose mount would only be in the scheduler's
+// mount namespace and not seen by containerd.
+func (s *cronScheduler) startContainer(run *cronRun, container string) error {
+	id := s.taskID(run, container)
+	bundle := path.Join(cronJobDir, "runs", id)
+	template := path.Join(cronJobDir, container)
+
+	// a container being retried is started afresh
+	s.ctr("container", "delete", id)
+	os.RemoveAll(path.Join(s.root, bundle))
+
+	if err := os.MkdirAll(path.Join(s.root, bundle), 0755); err != nil {
+		return err
+	}
+
+	for _, file := range []string{"config.json", "runtime.json"} {
+		if _, err := os.Stat(path.Join(s.root, template, file)); os.IsNotExist(err) {
+			continue
+		}
+		if err := copyFile(path.Join(s.root, template, file), path.Join(s.root, bundle, file)); err != nil {
+			return err
+		}
+	}
+
+	if out, err := hostCommand("/bin/cp", "-a", path.Join(template, "rootfs"), path.Join(bundle, "rootfs")).CombinedOutput(); err != nil {
+		return fmt.Errorf("copying the rootfs of %s: %v %s", container, err, out)
+	}
+
+	if out, err := hostCommand("/usr/bin/service", "start", "-path", path.Join(cronJobDir, "runs"), id).CombinedOutput(); err != nil {
+		return fmt.Errorf("starting %s: %v %s", id, err, out)
+	}
+
+	return nil
+}
+
+// stopRun kills whatever is still running of a run
+func (s *cronScheduler) stopRun(run *cronRun) {
+	for _, container := range s.config.Containers {
+		s.ctr("task", "delete", "--force", s.taskID(run, container))
+	}
+}
+
+// removeRun removes what is left of a run, once it has fallen out of the history
+func (s *cronScheduler) removeRun(id string) {
+	for _, container := range s.config.Containers {
+		taskID := fmt.Sprintf("%s-%s", id, container)
+		s.ctr("task", "delete", "--force", taskID)
+		s.ctr("container", "delete", taskID)
+		os.RemoveAll(path.Join(s.root, cronJobDir, "runs", taskID))
+	}
+}
+
+func (s *cronScheduler) startRun(scheduled time.Time, now time.Time) {
+	// like the CronJob controller, runs are named after the minute they were scheduled for
+	run := &cronRun{
+		id:        fmt.Sprintf("%s-%d", s.config.Name, scheduled.Unix()/60),
+		scheduled: scheduled,
+		started:   now,
+		done:      map[string]bool{},
+		retry:     map[string]time.Time{},
+	}
+
+	log.Infof("starting %s, scheduled for %s", run.id, scheduled)
+	s.active = append(s.active, run)
+
+	for _, container := range s.config.Containers {
+		if err := s.startContainer(run, container); err != nil {
+			log.Errorf("%s: %v", run.id, err)
+			// counted as a failure, and retried like one
+			if s.containerFailed(run, container, now) {
+				// updateRun finishes it
+				s.stopRun(run)
+				return
+			}
+		}
+	}
+}
+
+// containerFailed counts a failure of a container of a run and schedules its retry, unless the run is past its
+// backoff limit, which it returns
+func (s *cronScheduler) containerFailed(run *cronRun, container string, now time.Time) bool {
+	run.failures++
+	if run.failures > s.config.BackoffLimit {
+		log.Warnf("%s: %d failures, past its backoff limit of %d", run.id, run.failures, s.config.BackoffLimit)
+		return true
+	}
+
+	run.retry[container] = now.Add(jobBackoff(run.failures))
+	return false
+}
+
+// jobBackoff is how long the job controller waits before retrying a job's pod after it failed for the n'th time
+func jobBackoff(failures int32) time.Duration {
+	backoff := 10 * time.Second
+	for i := int32(1); i < failures && backoff < 6*time.Minute; i++ {
+		backoff *= 2
+	}
+	if backoff > 6*time.Minute {
+		backoff = 6 * time.Minute
+	}
+	return backoff
+}
+
+// updateRun looks at the containers of a run, restarting those that failed, and returns whether it finished and
+// whether it succeeded
+func (s *cronScheduler) updateRun(run *cronRun, statuses map[string]string, now time.Time) (bool, bool) {
+	if s.config.ActiveDeadlineSeconds != nil && now.Sub(run.started) >= time.Duration(*s.config.ActiveDeadlineSeconds)*time.Second {
+		log.Warnf("%s exceeded its deadline", run.id)
+		s.stopRun(run)
+		return true, false
+	}
+
+	// its containers failed to start too often when it started
+	if run.failures > s.config.BackoffLimit {
+		return true, false
+	}
+
+	for _, container := range s.config.Containers {
+		if run.done[container] {
+			continue
+		}
+
+		id := s.taskID(run, container)
+		switch statuses[id] {
+		case "STOPPED":
+			_, status, err := s.ctr("task", "delete", id)
+			if err != nil {
+				log.Errorf("%s: %v", run.id, err)
+				continue
+			}
+
+			if status == 0 {
+				run.done[container] = true
+				continue
+			}
+
+			log.Warnf("%s: container %s failed with exit status %d", run.id, container, status)
+			if s.containerFailed(run, container, now) {
+				s.stopRun(run)
+				return true, false
+			}
+		case "":
+			if retry, ok := run.retry[container]; ok && !now.Before(retry) {
+				delete(run.retry, container)
+				if err := s.startContainer(run, container); err != nil {
+					log.Errorf("%s: %v", run.id, err)
+					if s.containerFailed(run, container, now) {
+						s.stopRun(run)
+						return true, false
+					}
+				}
+			}
+		}
+	}
+
+	return len(run.done) == len(s.config.Containers), true
+}
+
+// pruneHistory splits finished runs into those kept under the history limits and those which expired, which aren't
+// necessarily the oldest since successful and failed runs have limits of their own
+func pruneHistory(finished []cron.Run, successfulLimit int, failedLimit int) ([]cron.Run, []cron.Run) {
+	expired := cron.Expired(finished, successfulLimit, failedLimit)
+
+	ids := map[string]bool{}
+	for _, run := range expired {
+		ids[run.ID] = true
+	}
+
+	kept := []cron.Run{}
+	for _, run := range finished {
+		if !ids[run.ID] {
+			kept = append(kept, run)
+		}
+	}
+
+	return kept, expired
+}
+
+func (s *cronScheduler) tick(now time.Time) error {
+	statuses, err := s.taskStatuses()
+	if err != nil {
+		return err
+	}
+
+	active := []*cronRun{}
+	for _, run := range s.active {
+		finished, succeeded := s.updateRun(run, statuses, now)
+		if !finished {
+			active = append(active, run)
+			continue
+		}
+
+		log.Infof("%s finished, succeeded: %t", run.id, succeeded)
+		s.finished = append(s.finished, cron.Run{ID: run.id, Scheduled: run.scheduled, Succeeded: succeeded})
+	}
+	s.active = active
+
+	var expired []cron.Run
+	s.finished, expired = pruneHistory(s.finished, int(s.config.SuccessfulJobsHistoryLimit), int(s.config.FailedJobsHistoryLimit))
+	for _, run := range expired {
+		s.removeRun(run.ID)
+	}
+
+	decision := s.policy.Decide(s.schedule, s.last, now, len(s.active))
+	if decision.Missed > 0 {
+		log.Warnf("missed %d runs before %s", decision.Missed, decision.Scheduled)
+	}
+
+	switch decision.Action {
+	case cron.Start:
+		s.startRun(decision.Scheduled, now)
+		s.last = decision.Scheduled
+	case cron.Replace:
+		for _, run := range s.active {
+			log.Infof("replacing %s", run.id)
+			s.stopRun(run)
+			s.removeRun(run.id)
+		}
+		s.active = nil
+		s.startRun(decision.Scheduled, now)
+		s.last = decision.Scheduled
+	case cron.Skip:
+		log.Warnf("skipping the run scheduled for %s, it's past its starting deadline", decision.Scheduled)
+		s.last = decision.Scheduled
+	case cron.Blocked:
+		if !decision.Scheduled.Equal(s.blocked) {
+			log.Infof("the run scheduled for %s waits for the active run to finish", decision.Scheduled)
+			s.blocked = decision.Scheduled
+		}
+	}
+
+	return nil
+}
+
+// cronSchedulerMain is the cron-scheduler command, which runs a CronJob on the VM it was converted for
+func cronSchedulerMain(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: cron-scheduler <config>")
+	}
+
+	s := &cronScheduler{root: hostRoot}
+	if err := json.Unmarshal([]byte(args[0]), &s.config); err != nil {
+		return err
+	}
+
+	var err error
+	if s.schedule, err = s.config.parseSchedule(); err != nil {
+		return err
+	}
+	s.policy = s.config.policy()
+
+	if err := s.claimBundles(); err != nil {
+		return err
+	}
+
+	// nothing is known about runs before the VM booted
+	s.last = time.Now()
+
+	for range time.Tick(schedulerInterval) {
+		if err := s.tick(time.Now()); err != nil {
+			log.Errorf("%v", err)
+		}
+	}
+
+	return nil
+}
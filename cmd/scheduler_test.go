@@ -0,0 +1,378 @@
+package main
+
+import (
+	"fmt"
+	"github.com/tjfontaine/podspec2linuxkit/pkg/cron"
+	"github.com/tjfontaine/podspec2linuxkit/pkg/linuxkit"
+	"io/ioutil"
+	batchv1 "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPruneHistory(t *testing.T) {
+	start := time.Date(2018, 11, 1, 10, 0, 0, 0, time.UTC)
+	finished := []cron.Run{}
+	// s1 f2 s3 f4 s5 f6 s7, oldest first
+	for i, succeeded := range []bool{true, false, true, false, true, false, true} {
+		id := "f"
+		if succeeded {
+			id = "s"
+		}
+		finished = append(finished, cron.Run{
+			ID:        id + string('1'+rune(i)),
+			Scheduled: start.Add(time.Duration(i) * time.Hour),
+			Succeeded: succeeded,
+		})
+	}
+
+	ids := func(runs []cron.Run) string {
+		names := []string{}
+		for _, run := range runs {
+			names = append(names, run.ID)
+		}
+		return strings.Join(names, " ")
+	}
+
+	for _, test := range []struct {
+		successful, failed int
+		kept, expired      string
+	}{
+		{successful: 3, failed: 1, kept: "s3 s5 f6 s7", expired: "s1 f2 f4"},
+		{successful: 1, failed: 3, kept: "f2 f4 f6 s7", expired: "s1 s3 s5"},
+		{successful: 0, failed: 0, kept: "", expired: "s1 f2 s3 f4 s5 f6 s7"},
+		{successful: 4, failed: 3, kept: "s1 f2 s3 f4 s5 f6 s7", expired: ""},
+	} {
+		kept, expired := pruneHistory(finished, test.successful, test.failed)
+		if ids(kept) != test.kept || ids(expired) != test.expired {
+			t.Errorf("limits %d/%d: kept %q and expired %q, want %q and %q", test.successful, test.failed, ids(kept), ids(expired), test.kept, test.expired)
+		}
+	}
+}
+
+// fakeHostScript stands in for the host's ctr, cp and service, keeping what it's asked to do in calls: task ls
+// reports what is in tasks, task delete exits with the status in exit-<task>, and service fails to start the tasks
+// listed in broken
+const fakeHostScript = `dir=$1
+shift
+echo "$*" >> $dir/calls
+case $1 in
+/usr/bin/ctr)
+  shift 3
+  case "$1 $2 $3" in
+  "task ls ") echo "TASK PID STATUS"; cat $dir/tasks 2>/dev/null ;;
+  "task delete --force") ;;
+  "task delete "*) exit $(cat $dir/exit-$3 2>/dev/null || echo 0) ;;
+  esac
+  ;;
+/bin/cp) mkdir -p $dir/root$4 ;;
+/usr/bin/service) ! grep -qx "$5" $dir/broken 2>/dev/null ;;
+esac
+`
+
+// fakeScheduler returns a scheduler of the config whose host is faked in a temporary directory
+func fakeScheduler(t *testing.T, config cronSchedulerConfig) (*cronScheduler, string) {
+	dir, err := ioutil.TempDir("", "scheduler")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hostCommand = func(args ...string) *exec.Cmd {
+		return exec.Command("/bin/sh", append([]string{"-c", fakeHostScript, "host", dir}, args...)...)
+	}
+
+	s := &cronScheduler{config: config, root: path.Join(dir, "root")}
+	if s.schedule, err = config.parseSchedule(); err != nil {
+		t.Fatal(err)
+	}
+	s.policy = config.policy()
+
+	return s, dir
+}
+
+// hostCalls returns what the fake host was asked to do since it was last asked
+func hostCalls(dir string) []string {
+	calls, _ := ioutil.ReadFile(path.Join(dir, "calls"))
+	os.Remove(path.Join(dir, "calls"))
+	return strings.Split(strings.TrimSpace(string(calls)), "\n")
+}
+
+func TestStartRun(t *testing.T) {
+	defer func(command func(...string) *exec.Cmd) { hostCommand = command }(hostCommand)
+
+	scheduled := time.Date(2018, 11, 1, 10, 0, 0, 0, time.UTC)
+	now := scheduled.Add(5 * time.Second)
+	id := fmt.Sprintf("backup-%d", scheduled.Unix()/60)
+
+	for _, test := range []struct {
+		backoffLimit int32
+		// whether the run is stopped right away, its container that failed to start being past the backoff limit
+		stopped bool
+	}{
+		{backoffLimit: 1},
+		{backoffLimit: 0, stopped: true},
+	} {
+		s, dir := fakeScheduler(t, cronSchedulerConfig{Name: "backup", Schedule: "* * * * *", BackoffLimit: test.backoffLimit, Containers: []string{"dump", "upload"}})
+		defer os.RemoveAll(dir)
+		ioutil.WriteFile(path.Join(dir, "broken"), []byte(id+"-dump\n"), 0644)
+
+		s.startRun(scheduled, now)
+		if len(s.active) != 1 || s.active[0].id != id || s.active[0].failures != 1 {
+			t.Fatalf("backoff limit %d: got active runs %v", test.backoffLimit, s.active)
+		}
+		run := s.active[0]
+		calls := strings.Join(hostCalls(dir), "\n")
+
+		finished, succeeded := s.updateRun(run, map[string]string{}, now)
+		if test.stopped {
+			if len(run.retry) != 0 || strings.Contains(calls, "service start -path /containers/cronjob/runs "+id+"-upload") ||
+				!strings.Contains(calls, "task delete --force "+id+"-dump") {
+				t.Errorf("backoff limit %d: got retries %v after:\n%s", test.backoffLimit, run.retry, calls)
+			}
+			if !finished || succeeded {
+				t.Errorf("backoff limit %d: got finished %t and succeeded %t, want it to have failed", test.backoffLimit, finished, succeeded)
+			}
+			continue
+		}
+
+		if !run.retry["dump"].Equal(now.Add(10*time.Second)) || !strings.Contains(calls, "service start -path /containers/cronjob/runs "+id+"-upload") {
+			t.Errorf("backoff limit %d: got retries %v after:\n%s", test.backoffLimit, run.retry, calls)
+		}
+		if _, err := os.Stat(path.Join(s.root, cronJobDir, "runs", id+"-upload", "rootfs")); err != nil {
+			t.Errorf("backoff limit %d: %v", test.backoffLimit, err)
+		}
+		if finished {
+			t.Errorf("backoff limit %d: finished, want it retried", test.backoffLimit)
+		}
+	}
+}
+
+func TestUpdateRun(t *testing.T) {
+	defer func(command func(...string) *exec.Cmd) { hostCommand = command }(hostCommand)
+
+	deadline := int64(600)
+	s, dir := fakeScheduler(t, cronSchedulerConfig{Name: "backup", Schedule: "* * * * *", BackoffLimit: 2, ActiveDeadlineSeconds: &deadline, Containers: []string{"dump", "upload"}})
+	defer os.RemoveAll(dir)
+
+	started := time.Date(2018, 11, 1, 10, 0, 0, 0, time.UTC)
+	s.startRun(started, started)
+	run := s.active[0]
+	hostCalls(dir)
+
+	for i, test := range []struct {
+		after    time.Duration
+		statuses map[string]string
+		// the exit status of a stopped container, and whether the upload container fails to start
+		exit   string
+		broken bool
+
+		finished, succeeded bool
+		failures            int32
+		// when upload is retried, after the run started
+		retry time.Duration
+		// whether upload was started again
+		restarted bool
+	}{
+		{after: time.Minute, statuses: map[string]string{"dump": "STOPPED", "upload": "RUNNING"}, exit: "0", succeeded: true},
+		{after: 2 * time.Minute, statuses: map[string]string{"upload": "STOPPED"}, exit: "1", succeeded: true, failures: 1, retry: 2*time.Minute + 10*time.Second},
+		// waiting for its backoff
+		{after: 2*time.Minute + 5*time.Second, succeeded: true, failures: 1, retry: 2*time.Minute + 10*time.Second},
+		// failing to restart is a failure too, backing off twice as long
+		{after: 2*time.Minute + 10*time.Second, broken: true, succeeded: true, failures: 2, retry: 2*time.Minute + 30*time.Second, restarted: true},
+		{after: 2*time.Minute + 30*time.Second, succeeded: true, failures: 2, restarted: true},
+		{after: 3 * time.Minute, statuses: map[string]string{"upload": "RUNNING"}, succeeded: true, failures: 2},
+		{after: 4 * time.Minute, statuses: map[string]string{"upload": "STOPPED"}, exit: "0", finished: true, succeeded: true, failures: 2},
+	} {
+		statuses := map[string]string{}
+		for container, status := range test.statuses {
+			statuses[s.taskID(run, container)] = status
+			ioutil.WriteFile(path.Join(dir, "exit-"+s.taskID(run, container)), []byte(test.exit), 0644)
+		}
+		os.Remove(path.Join(dir, "broken"))
+		if test.broken {
+			ioutil.WriteFile(path.Join(dir, "broken"), []byte(s.taskID(run, "upload")+"\n"), 0644)
+		}
+
+		finished, succeeded := s.updateRun(run, statuses, started.Add(test.after))
+		restarted := strings.Contains(strings.Join(hostCalls(dir), "\n"), "service start -path /containers/cronjob/runs "+s.taskID(run, "upload"))
+		retry := time.Duration(0)
+		if at, ok := run.retry["upload"]; ok {
+			retry = at.Sub(started)
+		}
+		if finished != test.finished || succeeded != test.succeeded || run.failures != test.failures || retry != test.retry || restarted != test.restarted {
+			t.Errorf("step %d: got finished %t, succeeded %t, %d failures, retry after %s and restarted %t, want %t, %t, %d, %s and %t",
+				i+1, finished, succeeded, run.failures, retry, restarted, test.finished, test.succeeded, test.failures, test.retry, test.restarted)
+		}
+	}
+
+	// a run failing past its backoff limit, whether it stopped or failed to restart, is stopped
+	for _, broken := range []bool{false, true} {
+		run := &cronRun{id: "backup-1", started: started, failures: 2, done: map[string]bool{}, retry: map[string]time.Time{"upload": started}}
+		statuses := map[string]string{}
+		os.Remove(path.Join(dir, "broken"))
+		if broken {
+			ioutil.WriteFile(path.Join(dir, "broken"), []byte("backup-1-upload\n"), 0644)
+		} else {
+			delete(run.retry, "upload")
+			statuses["backup-1-upload"] = "STOPPED"
+			ioutil.WriteFile(path.Join(dir, "exit-backup-1-upload"), []byte("2"), 0644)
+		}
+
+		finished, succeeded := s.updateRun(run, statuses, started.Add(time.Minute))
+		calls := strings.Join(hostCalls(dir), "\n")
+		if !finished || succeeded || run.failures != 3 || !strings.Contains(calls, "task delete --force backup-1-dump") {
+			t.Errorf("broken %t: got finished %t, succeeded %t and %d failures after:\n%s", broken, finished, succeeded, run.failures, calls)
+		}
+	}
+
+	// past its deadline, whatever is still running is killed
+	run = &cronRun{id: "backup-2", started: started, done: map[string]bool{}, retry: map[string]time.Time{}}
+	finished, succeeded := s.updateRun(run, map[string]string{"backup-2-dump": "RUNNING"}, started.Add(10*time.Minute))
+	calls := strings.Join(hostCalls(dir), "\n")
+	if !finished || succeeded || !strings.Contains(calls, "task delete --force backup-2-dump") {
+		t.Errorf("got finished %t and succeeded %t after:\n%s, want it killed past its deadline", finished, succeeded, calls)
+	}
+}
+
+func TestTick(t *testing.T) {
+	defer func(command func(...string) *exec.Cmd) { hostCommand = command }(hostCommand)
+
+	last := time.Date(2018, 11, 1, 10, 0, 0, 0, time.UTC)
+	first := fmt.Sprintf("backup-%d", last.Unix()/60+1)
+	second := fmt.Sprintf("backup-%d", last.Unix()/60+2)
+
+	for _, test := range []struct {
+		policy cron.ConcurrencyPolicy
+		// the runs active once the second is due while the first still runs, and once the first finished
+		active, after string
+	}{
+		{policy: cron.AllowConcurrent, active: first + " " + second, after: second},
+		{policy: cron.ForbidConcurrent, active: first, after: second},
+		{policy: cron.ReplaceConcurrent, active: second, after: second},
+	} {
+		s, dir := fakeScheduler(t, cronSchedulerConfig{Name: "backup", Schedule: "* * * * *", ConcurrencyPolicy: string(test.policy), SuccessfulJobsHistoryLimit: 0, FailedJobsHistoryLimit: 1, Containers: []string{"dump"}})
+		defer os.RemoveAll(dir)
+		s.last = last
+
+		active := func() string {
+			ids := []string{}
+			for _, run := range s.active {
+				ids = append(ids, run.id)
+			}
+			return strings.Join(ids, " ")
+		}
+		tick := func(now time.Time, tasks string) {
+			ioutil.WriteFile(path.Join(dir, "tasks"), []byte(tasks), 0644)
+			if err := s.tick(now); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		tick(last.Add(time.Minute), "")
+		if active() != first {
+			t.Errorf("%s: got active runs %q, want %s started", test.policy, active(), first)
+		}
+
+		tick(last.Add(2*time.Minute), first+"-dump 10 RUNNING\n")
+		if active() != test.active {
+			t.Errorf("%s: got active runs %q, want %q", test.policy, active(), test.active)
+		}
+
+		// a Forbid run starts once the run it waited for finishes, which expires at once with no history kept
+		hostCalls(dir)
+		tick(last.Add(2*time.Minute+30*time.Second), first+"-dump 10 STOPPED\n"+second+"-dump 11 RUNNING\n")
+		calls := strings.Join(hostCalls(dir), "\n")
+		if active() != test.after || len(s.finished) != 0 {
+			t.Errorf("%s: got active runs %q and history %v, want %q", test.policy, active(), s.finished, test.after)
+		}
+		if test.policy != cron.ReplaceConcurrent && !strings.Contains(calls, "container delete "+first+"-dump") {
+			t.Errorf("%s: %s wasn't removed:\n%s", test.policy, first, calls)
+		}
+	}
+}
+
+func TestCronJobToLinuxKit(t *testing.T) {
+	two := int32(2)
+	deadline := int64(600)
+	services := func() *linuxkit.Moby {
+		return &linuxkit.Moby{Services: &[]*linuxkit.Image{{Name: "dump"}, {Name: "upload"}}}
+	}
+
+	for _, test := range []struct {
+		name    string
+		cronJob cronJobSpec
+		job     batchv1.JobSpec
+		result  *linuxkit.Moby
+		// the config the scheduler is given, or the error
+		config string
+		err    string
+	}{
+		{
+			name:    "defaults",
+			cronJob: cronJobSpec{schedule: "0 3 * * *"},
+			result:  services(),
+			config:  `{"name":"backup","namespace":"services.linuxkit","schedule":"0 3 * * *","concurrencyPolicy":"Allow","successfulJobsHistoryLimit":3,"failedJobsHistoryLimit":1,"backoffLimit":6,"containers":["dump","upload"]}`,
+		},
+		{
+			name:    "the CronJob's and the job's settings",
+			cronJob: cronJobSpec{schedule: "0 3 * * *", timeZone: "Europe/Berlin", concurrencyPolicy: "Forbid", successfulJobsHistoryLimit: &two, failedJobsHistoryLimit: &two},
+			job:     batchv1.JobSpec{BackoffLimit: &two, ActiveDeadlineSeconds: &deadline},
+			result:  services(),
+			config:  `{"name":"backup","namespace":"services.linuxkit","schedule":"0 3 * * *","timeZone":"Europe/Berlin","concurrencyPolicy":"Forbid","successfulJobsHistoryLimit":2,"failedJobsHistoryLimit":2,"backoffLimit":2,"activeDeadlineSeconds":600,"containers":["dump","upload"]}`,
+		},
+		{
+			name:    "unknown concurrencyPolicy",
+			cronJob: cronJobSpec{schedule: "0 3 * * *", concurrencyPolicy: "Queue"},
+			result:  services(),
+			err:     "CronJob backup: unknown concurrencyPolicy Queue",
+		},
+		{
+			name:    "invalid schedule",
+			cronJob: cronJobSpec{schedule: "0 25 * * *"},
+			result:  services(),
+			err:     "CronJob backup: ",
+		},
+		{
+			name:    "unknown timeZone",
+			cronJob: cronJobSpec{schedule: "0 3 * * *", timeZone: "Mars/Olympus_Mons"},
+			result:  services(),
+			err:     "CronJob backup: unknown timeZone Mars/Olympus_Mons",
+		},
+		{
+			name:    "onshutdown in use",
+			cronJob: cronJobSpec{schedule: "0 3 * * *"},
+			result:  &linuxkit.Moby{Services: &[]*linuxkit.Image{{Name: "dump"}}, Onshutdown: &[]*linuxkit.Image{{Name: "flush"}}},
+			err:     "CronJob backup: onshutdown is already in use",
+		},
+	} {
+		cronJob := test.cronJob
+		w := cronJobWorkload(metav1.ObjectMeta{Name: "backup"}, &cronJob, metav1.ObjectMeta{}, test.job)
+		err := cronJobToLinuxKit(w, servicesNamespace, test.result)
+		if test.err != "" {
+			if err == nil || !strings.HasPrefix(err.Error(), test.err) {
+				t.Errorf("%s: got %v, want %s", test.name, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+
+		// the job's containers are moved to onshutdown, for the scheduler to take
+		if len(*test.result.Onshutdown) != 2 || (*test.result.Onshutdown)[0].Name != "dump" || len(*test.result.Services) != 1 {
+			t.Errorf("%s: got onshutdown %v and services %v", test.name, *test.result.Onshutdown, *test.result.Services)
+			continue
+		}
+		scheduler := (*test.result.Services)[0]
+		command := *scheduler.Command
+		if scheduler.Name != "cronjob-backup" || scheduler.Pid != "host" || len(command) != 3 || command[1] != "cron-scheduler" || command[2] != test.config {
+			t.Errorf("%s: got scheduler %s running %v, want the config %s", test.name, scheduler.Name, command, test.config)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"github.com/tjfontaine/podspec2linuxkit/pkg/linuxkit"
+	corev1 "k8s.io/api/core/v1"
+	"path"
+	"strings"
+)
+
+// expandEnv expands $(VAR) references the way Kubernetes does: references to unknown variables are left as they
+// are, and $$ escapes a $
+func expandEnv(s string, env map[string]*string) (string, error) {
+	var result strings.Builder
+
+	for i := 0; i < len(s); i++ {
+		if s[i] != '$' || i+1 == len(s) {
+			result.WriteByte(s[i])
+			continue
+		}
+
+		switch s[i+1] {
+		case '$':
+			result.WriteByte('$')
+			i++
+		case '(':
+			end := strings.IndexByte(s[i+2:], ')')
+			if end < 0 {
+				result.WriteString(s[i:])
+				return result.String(), nil
+			}
+			name := s[i+2 : i+2+end]
+			value, ok := env[name]
+			if !ok {
+				result.WriteString(s[i : i+3+end])
+			} else if value == nil {
+				return "", fmt.Errorf("$(%s) is only known at boot", name)
+			} else {
+				result.WriteString(*value)
+			}
+			i += 2 + end
+		default:
+			result.WriteByte(s[i])
+		}
+	}
+
+	return result.String(), nil
+}
+
+// subPathForMount returns the subPath of a volume mount, with subPathExpr expanded, refusing anything which could
+// reach outside of the volume
+func subPathForMount(volume *corev1.VolumeMount, subPathExpr string, env map[string]*string) (string, error) {
+	subPath := volume.SubPath
+
+	if subPathExpr != "" {
+		if subPath != "" {
+			return "", fmt.Errorf("subPath and subPathExpr are mutually exclusive")
+		}
+
+		var err error
+		subPath, err = expandEnv(subPathExpr, env)
+		if err != nil {
+			return "", fmt.Errorf("subPathExpr %s: %v", subPathExpr, err)
+		}
+	}
+
+	if subPath == "" {
+		return "", nil
+	}
+
+	if path.IsAbs(subPath) {
+		return "", fmt.Errorf("subPath %s must be relative", subPath)
+	}
+
+	for _, element := range strings.Split(subPath, "/") {
+		if element == ".." {
+			return "", fmt.Errorf("subPath %s may not contain '..'", subPath)
+		}
+	}
+
+	return path.Clean(subPath), nil
+}
+
+// subPathImage creates a subPath in its volume when it doesn't exist yet, and makes sure any symlinks along the way
+// don't lead outside of the volume
+func subPathImage(name string, volumePath string, subPath string) *linuxkit.Image {
+	root := shellQuote(hostRoot + volumePath)
+	target := shellQuote(hostRoot + path.Join(volumePath, subPath))
+
+	// readlink -f only needs what's above the last element to exist
+	return bootScriptImage(name, fmt.Sprintf(`[ -d %s ] && root=$(readlink -f %s) || fail volume %s does not exist
+[ -e %s ] || mkdir -p %s || fail could not create subPath %s
+case "$(readlink -f %s)" in
+  "$root"|"$root"/*) ;;
+  *) fail subPath %s leads outside of its volume ;;
+esac
+`, root, root, shellQuote(volumePath), target, target, shellQuote(subPath), target, shellQuote(subPath)))
+}
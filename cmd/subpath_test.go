@@ -0,0 +1,120 @@
+package main
+
+import (
+	"io/ioutil"
+	corev1 "k8s.io/api/core/v1"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestExpandEnv(t *testing.T) {
+	name := "web-0"
+	env := map[string]*string{"POD_NAME": &name, "POD_IP": nil}
+
+	for _, test := range []struct {
+		in, out string
+		err     bool
+	}{
+		{in: "data", out: "data"},
+		{in: "$(POD_NAME)", out: "web-0"},
+		{in: "logs/$(POD_NAME)/app", out: "logs/web-0/app"},
+		{in: "$(POD_NAME)$(POD_NAME)", out: "web-0web-0"},
+		// unknown variables are left alone
+		{in: "$(MISSING)/$(POD_NAME)", out: "$(MISSING)/web-0"},
+		// $$ escapes a $
+		{in: "$$(POD_NAME)", out: "$(POD_NAME)"},
+		{in: "cost$$", out: "cost$"},
+		{in: "$POD_NAME", out: "$POD_NAME"},
+		{in: "trailing$", out: "trailing$"},
+		{in: "$(POD_NAME", out: "$(POD_NAME"},
+		{in: "$(POD_IP)", err: true},
+	} {
+		out, err := expandEnv(test.in, env)
+		if test.err {
+			if err == nil {
+				t.Errorf("%s: expected an error", test.in)
+			}
+			continue
+		}
+		if err != nil || out != test.out {
+			t.Errorf("%s: got %q (%v), want %q", test.in, out, err, test.out)
+		}
+	}
+}
+
+func TestSubPathForMount(t *testing.T) {
+	name := "web-0"
+	env := map[string]*string{"POD_NAME": &name}
+
+	for _, test := range []struct {
+		subPath, subPathExpr string
+		out                  string
+		err                  bool
+	}{
+		{out: ""},
+		{subPath: "conf", out: "conf"},
+		{subPath: "conf/./nginx/", out: "conf/nginx"},
+		{subPathExpr: "logs/$(POD_NAME)", out: "logs/web-0"},
+		{subPath: "conf", subPathExpr: "logs", err: true},
+		{subPath: "/etc", err: true},
+		{subPath: "../etc", err: true},
+		{subPath: "conf/../../etc", err: true},
+		{subPathExpr: "$(POD_NAME)/..", err: true},
+		// .. as part of a name is fine
+		{subPath: "conf..d", out: "conf..d"},
+	} {
+		out, err := subPathForMount(&corev1.VolumeMount{Name: "data", SubPath: test.subPath}, test.subPathExpr, env)
+		name := test.subPath + test.subPathExpr
+		if test.err {
+			if err == nil {
+				t.Errorf("%q: expected an error", name)
+			}
+			continue
+		}
+		if err != nil || out != test.out {
+			t.Errorf("%q: got %q (%v), want %q", name, out, err, test.out)
+		}
+	}
+}
+
+func TestSubPathImage(t *testing.T) {
+	root, err := ioutil.TempDir("", "subpath")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	volume := path.Join(root, "var/lib/volumes/data")
+	if err := os.MkdirAll(path.Join(volume, "conf"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("/etc", path.Join(volume, "escape")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("conf", path.Join(volume, "inside")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		volume, subPath string
+		fails           bool
+	}{
+		{volume: "/var/lib/volumes/data", subPath: "conf"},
+		{volume: "/var/lib/volumes/data", subPath: "logs/web-0"},
+		{volume: "/var/lib/volumes/data", subPath: "inside"},
+		{volume: "/var/lib/volumes/data", subPath: "escape", fails: true},
+		{volume: "/var/lib/volumes/data", subPath: "escape/nginx", fails: true},
+		{volume: "/var/lib/volumes/missing", subPath: "conf", fails: true},
+	} {
+		out, err := runBootScript(subPathImage("subpath", test.volume, test.subPath), root)
+		if test.fails != (err != nil) {
+			t.Errorf("%s in %s: got %v (%s), want failure %v", test.subPath, test.volume, err, out, test.fails)
+		}
+		if !test.fails {
+			if info, err := os.Stat(path.Join(root, test.volume, test.subPath)); err != nil || !info.IsDir() {
+				t.Errorf("%s in %s: expected a directory", test.subPath, test.volume)
+			}
+		}
+	}
+}
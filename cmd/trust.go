@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"github.com/tjfontaine/podspec2linuxkit/pkg/linuxkit"
+	"github.com/tjfontaine/podspec2linuxkit/pkg/resolver"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"strings"
+)
+
+// the pod annotations which add comma separated images and orgs to the trust config
+const (
+	trustImagesAnnotation = "podspec2linuxkit/trust-images"
+	trustOrgsAnnotation   = "podspec2linuxkit/trust-orgs"
+)
+
+// trustPolicy is what --trust-policy says about content trust
+type trustPolicy struct {
+	// images and orgs whose signatures linuxkit build checks
+	Image []string `yaml:"image,omitempty"`
+	Org   []string `yaml:"org,omitempty"`
+	// orgs whose images have to be signed to be used at all
+	Mandatory []string `yaml:"mandatory,omitempty"`
+	// the Notary server of each registry host, other than docker.io
+	NotaryServers map[string]string `yaml:"notaryServers,omitempty"`
+}
+
+var imageTrustPolicy *trustPolicy
+
+// signatureResolver finds what the tags of mandatory orgs were signed with
+var signatureResolver resolver.Resolver
+
+func loadTrustPolicy(file string) error {
+	contents, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	policy := &trustPolicy{}
+	if err := yaml.UnmarshalStrict(contents, policy); err != nil {
+		return err
+	}
+
+	imageTrustPolicy = policy
+	signatureResolver = &resolver.Notary{Servers: policy.NotaryServers}
+	return nil
+}
+
+func splitList(list string) []string {
+	items := []string{}
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// podTrust is the trust config a pod asks for with its annotations
+func podTrust(meta metav1.ObjectMeta) linuxkit.TrustConfig {
+	return linuxkit.TrustConfig{
+		Image: splitList(meta.Annotations[trustImagesAnnotation]),
+		Org:   splitList(meta.Annotations[trustOrgsAnnotation]),
+	}
+}
+
+// appendTrust adds what isn't already in a trust config
+func appendTrust(into *linuxkit.TrustConfig, from linuxkit.TrustConfig) {
+	for _, image := range from.Image {
+		if !contains(into.Image, image) {
+			into.Image = append(into.Image, image)
+		}
+	}
+	for _, org := range from.Org {
+		if !contains(into.Org, org) {
+			into.Org = append(into.Org, org)
+		}
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// applyTrust adds the policy to the trust config, with images named the way they are written out, and refuses
+// images from mandatory orgs whose tag isn't signed, or which are pinned to something other than what was signed
+func applyTrust(config *linuxkit.Moby) error {
+	if imageTrustPolicy != nil {
+		appendTrust(&config.Trust, linuxkit.TrustConfig{
+			Image: imageTrustPolicy.Image,
+			// linuxkit build checks mandatory orgs too
+			Org: append(append([]string{}, imageTrustPolicy.Org...), imageTrustPolicy.Mandatory...),
+		})
+	}
+
+	// matching is by name, so trusted images have to be named in full, without the tag we'd add
+	images := []string{}
+	for _, image := range config.Trust.Image {
+		ref, err := linuxkit.ParseReference(image)
+		if err != nil {
+			return err
+		}
+		if !strings.ContainsAny(image[strings.LastIndex(image, "/")+1:], ":@") {
+			images = append(images, ref.Locator)
+		} else {
+			images = append(images, ref.String())
+		}
+	}
+	config.Trust.Image = images
+
+	if imageTrustPolicy == nil || len(imageTrustPolicy.Mandatory) == 0 {
+		return nil
+	}
+
+	// what linuxkit build would check the signatures of, were only the mandatory orgs trusted
+	mandatory := linuxkit.TrustConfig{Org: imageTrustPolicy.Mandatory}
+	for _, image := range config.ImageReferences() {
+		if !mandatory.Enforced(image) {
+			continue
+		}
+
+		ref, err := linuxkit.ParseReference(image)
+		if err != nil {
+			return err
+		}
+
+		signed, err := signatureResolver.Resolve(ref)
+		if err != nil {
+			return fmt.Errorf("refusing %s, org %s requires signed images: %v", image, linuxkit.ImageOrg(image), err)
+		}
+		if ref.Digest() != "" && ref.Digest() != signed {
+			return fmt.Errorf("refusing %s, its tag was signed with %s", image, signed)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	digest "github.com/opencontainers/go-digest"
+	"github.com/tjfontaine/podspec2linuxkit/pkg/linuxkit"
+	"github.com/tjfontaine/podspec2linuxkit/pkg/resolver"
+	"io/ioutil"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestLoadTrustPolicy(t *testing.T) {
+	defer func() { imageTrustPolicy, signatureResolver = nil, nil }()
+
+	if err := loadTrustPolicy("../examples/trust-policy.yaml"); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(imageTrustPolicy.Image, " ") != "nginx docker.io/library/redis:5" || strings.Join(imageTrustPolicy.Org, " ") != "linuxkit" ||
+		strings.Join(imageTrustPolicy.Mandatory, " ") != "myteam" {
+		t.Errorf("got policy %+v", imageTrustPolicy)
+	}
+	if notary, ok := signatureResolver.(*resolver.Notary); !ok || notary.Servers["registry.example.com"] != "https://notary.example.com" {
+		t.Errorf("got signature resolver %+v", signatureResolver)
+	}
+
+	dir, err := ioutil.TempDir("", "trust")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := path.Join(dir, "policy.yaml")
+	ioutil.WriteFile(file, []byte("mandatory:\n- myteam\nrequired:\n- other\n"), 0644)
+	if err := loadTrustPolicy(file); err == nil {
+		t.Errorf("expected an error for an unknown field")
+	}
+}
+
+func TestPodTrust(t *testing.T) {
+	trust := podTrust(metav1.ObjectMeta{Annotations: map[string]string{
+		trustImagesAnnotation: " nginx, docker.io/library/redis:5 ,,",
+		trustOrgsAnnotation:   "myteam",
+	}})
+	if strings.Join(trust.Image, " ") != "nginx docker.io/library/redis:5" || strings.Join(trust.Org, " ") != "myteam" {
+		t.Errorf("got %+v", trust)
+	}
+
+	if trust := podTrust(metav1.ObjectMeta{}); len(trust.Image) != 0 || len(trust.Org) != 0 {
+		t.Errorf("got %+v for a pod without annotations", trust)
+	}
+}
+
+func TestApplyTrust(t *testing.T) {
+	defer func() { imageTrustPolicy, signatureResolver = nil, nil }()
+
+	signed := digest.FromString("signed")
+	sum, _ := hex.DecodeString(signed.Hex())
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/registry.example.com/myteam/app/_trust/tuf/targets/releases.json":
+			fmt.Fprintf(w, `{"signed":{"targets":{"1.0":{"hashes":{"sha256":"%s"},"length":1}}}}`, base64.StdEncoding.EncodeToString(sum))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	imageTrustPolicy = &trustPolicy{
+		Image:     []string{"nginx", "docker.io/library/redis:5"},
+		Org:       []string{"linuxkit"},
+		Mandatory: []string{"myteam"},
+	}
+	signatureResolver = &resolver.Notary{Client: server.Client(), Servers: map[string]string{"registry.example.com": server.URL}}
+
+	for _, test := range []struct {
+		image string
+		err   bool
+	}{
+		{image: "registry.example.com/myteam/app:1.0"},
+		{image: "registry.example.com/myteam/app:1.0@" + signed.String()},
+		// an image from an org that isn't mandatory needn't be signed
+		{image: "registry.example.com/otherteam/app:2.0"},
+		{image: "registry.example.com/myteam/app:2.0", err: true},
+		{image: "registry.example.com/myteam/app:1.0@" + digest.FromString("other").String(), err: true},
+		{image: "registry.example.com/myteam/other:1.0", err: true},
+	} {
+		config := &linuxkit.Moby{
+			Services: &[]*linuxkit.Image{{Name: "app", Image: test.image}},
+			// what the pod's annotations asked for
+			Trust: linuxkit.TrustConfig{Image: []string{"nginx", "busybox:1.29"}, Org: []string{"myteam"}},
+		}
+
+		err := applyTrust(config)
+		if test.err {
+			if err == nil {
+				t.Errorf("%s: expected it to be refused", test.image)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.image, err)
+			continue
+		}
+
+		// the pod's and the policy's, each once, named in full, and the mandatory orgs
+		images := strings.Join(config.Trust.Image, " ")
+		if images != "docker.io/library/nginx docker.io/library/busybox:1.29 docker.io/library/redis:5" {
+			t.Errorf("%s: got trusted images %s", test.image, images)
+		}
+		if orgs := strings.Join(config.Trust.Org, " "); orgs != "myteam linuxkit" {
+			t.Errorf("%s: got trusted orgs %s", test.image, orgs)
+		}
+	}
+
+	// without a policy only the pod's trust config is written out
+	imageTrustPolicy, signatureResolver = nil, nil
+	config := &linuxkit.Moby{
+		Services: &[]*linuxkit.Image{{Name: "app", Image: "registry.example.com/myteam/app:2.0"}},
+		Trust:    linuxkit.TrustConfig{Image: []string{"nginx"}},
+	}
+	if err := applyTrust(config); err != nil || strings.Join(config.Trust.Image, " ") != "docker.io/library/nginx" || len(config.Trust.Org) != 0 {
+		t.Errorf("got %+v (%v)", config.Trust, err)
+	}
+}
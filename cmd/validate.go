@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"github.com/tjfontaine/podspec2linuxkit/pkg/linuxkit"
+	"io/ioutil"
+	"os"
+)
+
+// validateMain checks LinuxKit yaml files, like hand-written ones, with the same checks as the converter's output
+func validateMain(args []string) error {
+	files := args
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+
+	invalid := 0
+	for _, file := range files {
+		var contents []byte
+		var err error
+		if file == "-" {
+			contents, err = ioutil.ReadAll(os.Stdin)
+		} else {
+			contents, err = ioutil.ReadFile(file)
+		}
+		if err != nil {
+			return err
+		}
+
+		config, err := linuxkit.NewConfig(contents)
+		if err == nil {
+			err = config.Validate()
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s:\n%v\n", file, err)
+			invalid++
+		}
+	}
+
+	if invalid > 0 {
+		return fmt.Errorf("%d of %d configs aren't valid", invalid, len(files))
+	}
+	return nil
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"fmt"
+	"github.com/tjfontaine/podspec2linuxkit/pkg/linuxkit"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// workload is what a kind contributes to the conversion: the pod template, and whatever about how its pods are
+// meant to run changes how they are converted
+type workload struct {
+	template corev1.PodTemplateSpec
+
+	// set for Jobs and CronJobs, whose pods run to completion and then power off the VM
+	job *batchv1.JobSpec
+	// set for CronJobs, whose job runs on a schedule
+	cronJob *cronJobSpec
+	// set for StatefulSets, whose pods have a stable identity
+	statefulSet *statefulSetSpec
+
+	// where the pod spec is in the manifest, when it isn't somewhere rawPodSpec looks
+	rawSpec []string
+}
+
+// cronJobSpec is the part of a CronJob's spec which is the same across its versions
+type cronJobSpec struct {
+	schedule                   string
+	timeZone                   string
+	startingDeadlineSeconds    *int64
+	concurrencyPolicy          string
+	suspend                    bool
+	successfulJobsHistoryLimit *int32
+	failedJobsHistoryLimit     *int32
+}
+
+// statefulSetSpec is the part of a StatefulSet's spec which is the same across its versions
+type statefulSetSpec struct {
+	name                 string
+	replicas             int32
+	serviceName          string
+	volumeClaimTemplates []corev1.PersistentVolumeClaim
+}
+
+// podTemplate gives a workload's pod template the name and namespace its pods would be created with
+func podTemplate(meta metav1.ObjectMeta, template corev1.PodTemplateSpec) corev1.PodTemplateSpec {
+	if template.Name == "" {
+		template.Name = meta.Name
+	}
+
+	if template.Namespace == "" {
+		template.Namespace = meta.Namespace
+	}
+
+	return template
+}
+
+func podWorkload(meta metav1.ObjectMeta, template corev1.PodTemplateSpec) *workload {
+	return &workload{template: podTemplate(meta, template)}
+}
+
+func jobWorkload(meta metav1.ObjectMeta, job batchv1.JobSpec) *workload {
+	return &workload{
+		template: podTemplate(meta, job.Template),
+		job:      &job,
+	}
+}
+
+func cronJobWorkload(meta metav1.ObjectMeta, cronJob *cronJobSpec, jobMeta metav1.ObjectMeta, job batchv1.JobSpec) *workload {
+	w := jobWorkload(podTemplate(meta, corev1.PodTemplateSpec{ObjectMeta: jobMeta}).ObjectMeta, job)
+	w.cronJob = cronJob
+	return w
+}
+
+func statefulSetWorkload(meta metav1.ObjectMeta, template corev1.PodTemplateSpec, replicas *int32, serviceName string, claims []corev1.PersistentVolumeClaim) *workload {
+	statefulSet := &statefulSetSpec{
+		name:                 meta.Name,
+		replicas:             1,
+		serviceName:          serviceName,
+		volumeClaimTemplates: claims,
+	}
+
+	if replicas != nil {
+		statefulSet.replicas = *replicas
+	}
+
+	return &workload{
+		template:    podTemplate(meta, template),
+		statefulSet: statefulSet,
+	}
+}
+
+// statefulSetOrdinal gives the pod template the identity of one of the StatefulSet's pods, and returns the claims
+// its volumeClaimTemplates make for it
+func statefulSetOrdinal(template corev1.PodTemplateSpec, statefulSet *statefulSetSpec, ordinal int32) (corev1.PodTemplateSpec, []corev1.PersistentVolumeClaim) {
+	template.Name = fmt.Sprintf("%s-%d", statefulSet.name, ordinal)
+
+	if template.Spec.Hostname == "" {
+		template.Spec.Hostname = template.Name
+	}
+
+	if template.Spec.Subdomain == "" {
+		template.Spec.Subdomain = statefulSet.serviceName
+	}
+
+	// the labels the StatefulSet controller adds to its pods
+	labels := map[string]string{}
+	for key, value := range template.Labels {
+		labels[key] = value
+	}
+	labels["statefulset.kubernetes.io/pod-name"] = template.Name
+	labels["apps.kubernetes.io/pod-index"] = fmt.Sprint(ordinal)
+	template.Labels = labels
+
+	// each claim template becomes a claim of this pod's own, named <template>-<pod>, which replaces any volume of
+	// the same name
+	claims := []corev1.PersistentVolumeClaim{}
+	volumes := []corev1.Volume{}
+	claimed := map[string]bool{}
+	for _, claimTemplate := range statefulSet.volumeClaimTemplates {
+		claim := *claimTemplate.DeepCopy()
+		claim.Name = fmt.Sprintf("%s-%s", claimTemplate.Name, template.Name)
+		claims = append(claims, claim)
+		claimed[claimTemplate.Name] = true
+
+		volumes = append(volumes, corev1.Volume{
+			Name: claimTemplate.Name,
+			VolumeSource: corev1.VolumeSource{
+				PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: claim.Name},
+			},
+		})
+	}
+	for _, volume := range template.Spec.Volumes {
+		if !claimed[volume.Name] {
+			volumes = append(volumes, volume)
+		}
+	}
+	template.Spec.Volumes = volumes
+
+	return template, claims
+}
+
+// ordinals are the instances of the workload there is an image for: each of a StatefulSet's ordinals, or just the
+// one pod of other kinds
+func (w *workload) ordinals() []int32 {
+	if w.statefulSet == nil {
+		return []int32{0}
+	}
+
+	ordinals := []int32{}
+	for ordinal := int32(0); ordinal < w.statefulSet.replicas; ordinal++ {
+		ordinals = append(ordinals, ordinal)
+	}
+	return ordinals
+}
+
+// instanceName is the name of the pod an instance of the workload runs
+func (w *workload) instanceName(ordinal int32) string {
+	if w.statefulSet == nil {
+		return w.template.Name
+	}
+	return fmt.Sprintf("%s-%d", w.statefulSet.name, ordinal)
+}
+
+// workloadToLinuxKit converts a workload's pod template according to how its kind runs pods, for the StatefulSet
+// ordinal given. With a prefix, the pod's containers and volumes are renamed to start with it, see prefixPod.
+func workloadToLinuxKit(w *workload, raw map[string]interface{}, ordinal int32, prefix string) (*linuxkit.Moby, error) {
+	if w.cronJob != nil {
+		// newer than the vendored types
+		w.cronJob.timeZone, _, _ = unstructured.NestedString(raw, "spec", "timeZone")
+	}
+
+	template := *w.template.DeepCopy()
+	var claims []corev1.PersistentVolumeClaim
+
+	if w.statefulSet != nil {
+		if ordinal < 0 || ordinal >= w.statefulSet.replicas {
+			return nil, fmt.Errorf("StatefulSet %s has no ordinal %d", w.statefulSet.name, ordinal)
+		}
+		template, claims = statefulSetOrdinal(template, w.statefulSet, ordinal)
+	}
+
+	rawSpec := rawPodSpec(raw)
+	if w.rawSpec != nil {
+		rawSpec, _, _ = unstructured.NestedMap(raw, w.rawSpec...)
+	}
+
+	if prefix != "" {
+		prefixPod(&template.Spec, rawSpec, prefix)
+	}
+
+	if template.UID == "" {
+		kind, _ := raw["kind"].(string)
+		namespace := template.Namespace
+		if namespace == "" {
+			namespace = "default"
+		}
+		template.UID = podUID(kind, namespace, template.Name, prefix)
+	}
+
+	result, err := podSpec2LinuxKit(&template, rawSpec, claims)
+	if err != nil {
+		return nil, err
+	}
+	result.Trust = podTrust(template.ObjectMeta)
+
+	// a pod merged with others is isolated from them
+	namespace := servicesNamespace
+	if prefix != "" && result.Services != nil {
+		namespace = podNamespace(prefix)
+		sandbox, err := isolatePod(*result.Services, prefix, &template.Spec)
+		if err != nil {
+			return nil, err
+		}
+		onboot := []*linuxkit.Image{sandbox}
+		if result.Onboot != nil {
+			onboot = append(onboot, *result.Onboot...)
+		}
+		result.Onboot = &onboot
+	}
+
+	if w.cronJob != nil {
+		if err := cronJobToLinuxKit(w, namespace, result); err != nil {
+			return nil, err
+		}
+	} else if w.job != nil && result.Services != nil {
+		containers := []string{}
+		for _, service := range *result.Services {
+			containers = append(containers, service.Name)
+		}
+
+		services := append(*result.Services, jobWatcherImage(template.Name, namespace, containers, w.job))
+		result.Services = &services
+	}
+
+	return result, nil
+}
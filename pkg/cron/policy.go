@@ -0,0 +1,150 @@
+package cron
+
+import (
+	"sort"
+	"time"
+)
+
+// ConcurrencyPolicy is what a CronJob does when a run is due while another is still active
+type ConcurrencyPolicy string
+
+const (
+	AllowConcurrent   ConcurrencyPolicy = "Allow"
+	ForbidConcurrent  ConcurrencyPolicy = "Forbid"
+	ReplaceConcurrent ConcurrencyPolicy = "Replace"
+)
+
+// Policy is how a CronJob deals with late and overlapping runs
+type Policy struct {
+	Concurrency ConcurrencyPolicy
+	// when set, how late a run may start before it's skipped
+	StartingDeadline *time.Duration
+	Suspend          bool
+}
+
+// Action is what a CronJob should do now
+type Action int
+
+const (
+	// nothing is due
+	Wait Action = iota
+	// a run is due and should be started
+	Start
+	// a run is due, but the active runs have to be stopped before it's started
+	Replace
+	// a run was due, but is past its starting deadline and won't be started
+	Skip
+	// a run is due, but can't be started while another is active; it's considered again later
+	Blocked
+)
+
+var actionNames = map[Action]string{
+	Wait:    "Wait",
+	Start:   "Start",
+	Replace: "Replace",
+	Skip:    "Skip",
+	Blocked: "Blocked",
+}
+
+func (a Action) String() string {
+	return actionNames[a]
+}
+
+// Decision is the Action a CronJob should take, and the run it concerns
+type Decision struct {
+	Action Action
+	// when the run was scheduled for. Once it has been started or skipped, this is when the CronJob was last
+	// scheduled, which is what the next decision has to be made from.
+	Scheduled time.Time
+	// how many runs before the one scheduled were missed, e.g. while the VM was off
+	Missed int
+}
+
+// Unmet returns the most recent time the schedule was due after last and up to now, and how many times it was due
+func Unmet(s *Schedule, last time.Time, now time.Time) (time.Time, int) {
+	var recent time.Time
+	count := 0
+
+	for t := s.Next(last); !t.IsZero() && !t.After(now); t = s.Next(t) {
+		recent = t
+		count++
+	}
+
+	return recent, count
+}
+
+// Decide returns what a CronJob that was last scheduled at last, and has active runs still going, should do now
+func (p Policy) Decide(s *Schedule, last time.Time, now time.Time, active int) Decision {
+	if p.Suspend {
+		return Decision{Action: Wait}
+	}
+
+	// runs which were due longer ago than the starting deadline are never started
+	earliest := last
+	if p.StartingDeadline != nil {
+		if deadline := now.Add(-*p.StartingDeadline); deadline.After(earliest) {
+			earliest = deadline
+		}
+	}
+
+	scheduled, count := Unmet(s, earliest, now)
+	if count == 0 {
+		if earliest.After(last) {
+			if skipped, count := Unmet(s, last, earliest); count > 0 {
+				return Decision{Action: Skip, Scheduled: skipped, Missed: count - 1}
+			}
+		}
+		return Decision{Action: Wait}
+	}
+
+	decision := Decision{Action: Start, Scheduled: scheduled, Missed: count - 1}
+
+	if active > 0 {
+		switch p.Concurrency {
+		case ForbidConcurrent:
+			decision.Action = Blocked
+		case ReplaceConcurrent:
+			decision.Action = Replace
+		}
+	}
+
+	return decision
+}
+
+// Run is a finished run of a CronJob
+type Run struct {
+	ID        string
+	Scheduled time.Time
+	Succeeded bool
+}
+
+// Expired returns the finished runs beyond what the history limits keep, oldest first
+func Expired(runs []Run, successfulLimit int, failedLimit int) []Run {
+	sorted := append([]Run{}, runs...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Scheduled.After(sorted[j].Scheduled)
+	})
+
+	expired := []Run{}
+	successful, failed := 0, 0
+	for _, run := range sorted {
+		if run.Succeeded {
+			successful++
+			if successful > successfulLimit {
+				expired = append(expired, run)
+			}
+		} else {
+			failed++
+			if failed > failedLimit {
+				expired = append(expired, run)
+			}
+		}
+	}
+
+	// oldest first
+	for i, j := 0, len(expired)-1; i < j; i, j = i+1, j-1 {
+		expired[i], expired[j] = expired[j], expired[i]
+	}
+
+	return expired
+}
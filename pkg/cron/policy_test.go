@@ -0,0 +1,136 @@
+package cron
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDecide(t *testing.T) {
+	everyHour, err := Parse("0 * * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	last := time.Date(2018, 11, 1, 10, 0, 0, 0, time.UTC)
+	minutes := func(m int) *time.Duration {
+		d := time.Duration(m) * time.Minute
+		return &d
+	}
+
+	for _, test := range []struct {
+		name   string
+		policy Policy
+		now    time.Time
+		active int
+		want   Decision
+	}{
+		{
+			name: "nothing due",
+			now:  last.Add(59 * time.Minute),
+			want: Decision{Action: Wait},
+		},
+		{
+			name: "due",
+			now:  last.Add(61 * time.Minute),
+			want: Decision{Action: Start, Scheduled: last.Add(time.Hour)},
+		},
+		{
+			name: "missed runs start the most recent",
+			now:  last.Add(3*time.Hour + time.Minute),
+			want: Decision{Action: Start, Scheduled: last.Add(3 * time.Hour), Missed: 2},
+		},
+		{
+			name:   "suspended",
+			policy: Policy{Suspend: true},
+			now:    last.Add(61 * time.Minute),
+			want:   Decision{Action: Wait},
+		},
+		{
+			name:   "within starting deadline",
+			policy: Policy{StartingDeadline: minutes(5)},
+			now:    last.Add(64 * time.Minute),
+			want:   Decision{Action: Start, Scheduled: last.Add(time.Hour)},
+		},
+		{
+			name:   "past starting deadline",
+			policy: Policy{StartingDeadline: minutes(5)},
+			now:    last.Add(66 * time.Minute),
+			want:   Decision{Action: Skip, Scheduled: last.Add(time.Hour)},
+		},
+		{
+			name:   "starting deadline ignores older missed runs",
+			policy: Policy{StartingDeadline: minutes(5)},
+			now:    last.Add(3*time.Hour + time.Minute),
+			want:   Decision{Action: Start, Scheduled: last.Add(3 * time.Hour)},
+		},
+		{
+			name:   "allow concurrent",
+			policy: Policy{Concurrency: AllowConcurrent},
+			now:    last.Add(61 * time.Minute),
+			active: 1,
+			want:   Decision{Action: Start, Scheduled: last.Add(time.Hour)},
+		},
+		{
+			name:   "forbid concurrent",
+			policy: Policy{Concurrency: ForbidConcurrent},
+			now:    last.Add(61 * time.Minute),
+			active: 1,
+			want:   Decision{Action: Blocked, Scheduled: last.Add(time.Hour)},
+		},
+		{
+			name:   "forbid without active runs",
+			policy: Policy{Concurrency: ForbidConcurrent},
+			now:    last.Add(61 * time.Minute),
+			want:   Decision{Action: Start, Scheduled: last.Add(time.Hour)},
+		},
+		{
+			name:   "replace concurrent",
+			policy: Policy{Concurrency: ReplaceConcurrent},
+			now:    last.Add(61 * time.Minute),
+			active: 2,
+			want:   Decision{Action: Replace, Scheduled: last.Add(time.Hour)},
+		},
+	} {
+		if got := test.policy.Decide(everyHour, last, test.now, test.active); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %s %s (missed %d), want %s %s (missed %d)", test.name,
+				got.Action, got.Scheduled, got.Missed, test.want.Action, test.want.Scheduled, test.want.Missed)
+		}
+	}
+}
+
+func TestExpired(t *testing.T) {
+	at := func(hour int) time.Time {
+		return time.Date(2018, 11, 1, hour, 0, 0, 0, time.UTC)
+	}
+
+	runs := []Run{
+		{ID: "a", Scheduled: at(1), Succeeded: true},
+		{ID: "b", Scheduled: at(2), Succeeded: false},
+		{ID: "c", Scheduled: at(3), Succeeded: true},
+		{ID: "d", Scheduled: at(4), Succeeded: false},
+		{ID: "e", Scheduled: at(5), Succeeded: true},
+	}
+
+	ids := func(runs []Run) []string {
+		result := []string{}
+		for _, run := range runs {
+			result = append(result, run.ID)
+		}
+		return result
+	}
+
+	for _, test := range []struct {
+		successful, failed int
+		want               []string
+	}{
+		{3, 1, []string{"b"}},
+		{1, 1, []string{"a", "b", "c"}},
+		{0, 0, []string{"a", "b", "c", "d", "e"}},
+		{10, 10, []string{}},
+	} {
+		if got := ids(Expired(runs, test.successful, test.failed)); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Expired(%d, %d) = %v, want %v", test.successful, test.failed, got, test.want)
+		}
+	}
+}
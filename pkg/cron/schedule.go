@@ -0,0 +1,300 @@
+// Package cron parses the schedules of CronJobs, and decides what a CronJob should do at a given time the same way
+// the Kubernetes CronJob controller would.
+package cron
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule is a parsed cron schedule, in the standard five field format understood by Kubernetes
+type Schedule struct {
+	minute, hour, dom, month, dow uint64
+	// a day of month or day of week of "*" decides how the two combine
+	domStar, dowStar bool
+	// set for @every schedules
+	every time.Duration
+
+	// Location is the time zone the schedule is interpreted in, the zone of the time given to Next when nil
+	Location *time.Location
+}
+
+type bounds struct {
+	min, max int
+	names    map[string]int
+}
+
+var (
+	minutes = bounds{0, 59, nil}
+	hours   = bounds{0, 23, nil}
+	doms    = bounds{1, 31, nil}
+	months  = bounds{1, 12, map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	dows = bounds{0, 6, map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+)
+
+var descriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// Parse parses a schedule, which may start with a CRON_TZ= or TZ= time zone
+func Parse(spec string) (*Schedule, error) {
+	return ParseInLocation(spec, nil)
+}
+
+// ParseInLocation parses a schedule to be interpreted in loc, which is how a CronJob's timeZone applies. A schedule
+// with its own time zone can't also be given one.
+func ParseInLocation(spec string, loc *time.Location) (*Schedule, error) {
+	spec = strings.TrimSpace(spec)
+
+	if strings.HasPrefix(spec, "CRON_TZ=") || strings.HasPrefix(spec, "TZ=") {
+		if loc != nil {
+			return nil, fmt.Errorf("schedule %q has a time zone, and is also given one", spec)
+		}
+
+		fields := strings.SplitN(spec, " ", 2)
+		zone := fields[0][strings.Index(fields[0], "=")+1:]
+		var err error
+		if loc, err = time.LoadLocation(zone); err != nil {
+			return nil, fmt.Errorf("schedule %q: unknown time zone %s", spec, zone)
+		}
+
+		spec = ""
+		if len(fields) > 1 {
+			spec = strings.TrimSpace(fields[1])
+		}
+	}
+
+	if strings.HasPrefix(spec, "@every ") {
+		every, err := time.ParseDuration(strings.TrimSpace(spec[len("@every "):]))
+		if err != nil || every < time.Second {
+			return nil, fmt.Errorf("schedule %q: @every needs a duration of at least a second", spec)
+		}
+		return &Schedule{every: every, Location: loc}, nil
+	}
+
+	if expanded, ok := descriptors[spec]; ok {
+		spec = expanded
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("schedule %q: expected 5 fields, found %d", spec, len(fields))
+	}
+
+	s := &Schedule{Location: loc}
+	var err error
+
+	if s.minute, _, err = parseField(fields[0], minutes); err != nil {
+		return nil, fmt.Errorf("schedule %q: minute: %v", spec, err)
+	}
+	if s.hour, _, err = parseField(fields[1], hours); err != nil {
+		return nil, fmt.Errorf("schedule %q: hour: %v", spec, err)
+	}
+	if s.dom, s.domStar, err = parseField(fields[2], doms); err != nil {
+		return nil, fmt.Errorf("schedule %q: day of month: %v", spec, err)
+	}
+	if s.month, _, err = parseField(fields[3], months); err != nil {
+		return nil, fmt.Errorf("schedule %q: month: %v", spec, err)
+	}
+	if s.dow, s.dowStar, err = parseField(fields[4], dows); err != nil {
+		return nil, fmt.Errorf("schedule %q: day of week: %v", spec, err)
+	}
+
+	return s, nil
+}
+
+// parseField returns the bits of the values a comma separated list of ranges matches, and whether it was "*"
+func parseField(field string, b bounds) (uint64, bool, error) {
+	var bits uint64
+	star := false
+
+	for _, expr := range strings.Split(field, ",") {
+		rangeAndStep := strings.Split(expr, "/")
+		if len(rangeAndStep) > 2 {
+			return 0, false, fmt.Errorf("too many slashes in %q", expr)
+		}
+
+		var start, end int
+		var err error
+		isStar := false
+
+		lowAndHigh := strings.Split(rangeAndStep[0], "-")
+		switch {
+		case rangeAndStep[0] == "*" || rangeAndStep[0] == "?":
+			start, end, isStar = b.min, b.max, true
+		case len(lowAndHigh) == 1:
+			if start, err = parseValue(lowAndHigh[0], b); err != nil {
+				return 0, false, err
+			}
+			end = start
+			// N/step means N-max/step
+			if len(rangeAndStep) == 2 {
+				end = b.max
+			}
+		case len(lowAndHigh) == 2:
+			if start, err = parseValue(lowAndHigh[0], b); err != nil {
+				return 0, false, err
+			}
+			if end, err = parseValue(lowAndHigh[1], b); err != nil {
+				return 0, false, err
+			}
+		default:
+			return 0, false, fmt.Errorf("too many hyphens in %q", expr)
+		}
+
+		step := 1
+		if len(rangeAndStep) == 2 {
+			if step, err = strconv.Atoi(rangeAndStep[1]); err != nil || step <= 0 {
+				return 0, false, fmt.Errorf("invalid step in %q", expr)
+			}
+		}
+
+		if start > end {
+			return 0, false, fmt.Errorf("range %q starts after it ends", expr)
+		}
+
+		for value := start; value <= end; value += step {
+			bits |= 1 << uint(value)
+		}
+
+		// only an unrestricted "*" counts, not "*/2"
+		if isStar && step == 1 {
+			star = true
+		}
+	}
+
+	return bits, star, nil
+}
+
+func parseValue(value string, b bounds) (int, error) {
+	if n, ok := b.names[strings.ToLower(value)]; ok {
+		return n, nil
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q", value)
+	}
+
+	if n < b.min || n > b.max {
+		return 0, fmt.Errorf("%d is outside %d-%d", n, b.min, b.max)
+	}
+
+	return n, nil
+}
+
+func matches(bits uint64, value int) bool {
+	return bits&(1<<uint(value)) != 0
+}
+
+// like cron, when both are restricted a day matches either its day of month or its day of week
+func (s *Schedule) dayMatches(t time.Time) bool {
+	domMatch := matches(s.dom, t.Day())
+	dowMatch := matches(s.dow, int(t.Weekday()))
+
+	if s.domStar || s.dowStar {
+		return domMatch && dowMatch
+	}
+
+	return domMatch || dowMatch
+}
+
+// Next returns the first time after t the schedule is due, or the zero time if it never is
+func (s *Schedule) Next(t time.Time) time.Time {
+	if s.every > 0 {
+		return t.Truncate(time.Second).Add(s.every)
+	}
+
+	origLocation := t.Location()
+	loc := s.Location
+	if loc == nil {
+		loc = origLocation
+	}
+	t = t.In(loc)
+
+	// the schedule is due at whole minutes, the earliest being the one after t
+	t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, loc).Add(time.Minute)
+
+	// once a field has been moved on, the ones below it start again from their lowest value
+	added := false
+
+	// no schedule is more than a leap year or so apart, so searching further means it never matches, e.g. 30 feb
+	yearLimit := t.Year() + 5
+
+wrap:
+	if t.Year() > yearLimit {
+		return time.Time{}
+	}
+
+	for !matches(s.month, int(t.Month())) {
+		if !added {
+			added = true
+			t = time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, loc)
+		}
+		t = t.AddDate(0, 1, 0)
+
+		if t.Month() == time.January {
+			goto wrap
+		}
+	}
+
+	for !s.dayMatches(t) {
+		if !added {
+			added = true
+			t = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+		}
+		t = t.AddDate(0, 0, 1)
+
+		// where DST starts at midnight, that day begins at 01:00 instead
+		if t.Hour() != 0 {
+			if t.Hour() > 12 {
+				t = t.Add(time.Duration(24-t.Hour()) * time.Hour)
+			} else {
+				t = t.Add(time.Duration(-t.Hour()) * time.Hour)
+			}
+		}
+
+		if t.Day() == 1 {
+			goto wrap
+		}
+	}
+
+	for !matches(s.hour, t.Hour()) {
+		if !added {
+			added = true
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, loc)
+		}
+		t = t.Add(time.Hour)
+
+		if t.Hour() == 0 {
+			goto wrap
+		}
+	}
+
+	for !matches(s.minute, t.Minute()) {
+		if !added {
+			added = true
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), 0, 0, loc)
+		}
+		t = t.Add(time.Minute)
+
+		if t.Minute() == 0 {
+			goto wrap
+		}
+	}
+
+	return t.In(origLocation)
+}
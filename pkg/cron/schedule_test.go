@@ -0,0 +1,169 @@
+package cron
+
+import (
+	"testing"
+	"time"
+)
+
+func mustTime(t *testing.T, value string, loc *time.Location) time.Time {
+	parsed, err := time.ParseInLocation("2006-01-02 15:04", value, loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsed
+}
+
+func TestParseInvalid(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 7",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"1-2-3 * * * *",
+		"*/2/3 * * * *",
+		"* * * foo *",
+		"@every 0s",
+		"@fortnightly",
+		"CRON_TZ=Nowhere/Special * * * * *",
+	} {
+		if _, err := Parse(spec); err == nil {
+			t.Errorf("Parse(%q) succeeded", spec)
+		}
+	}
+}
+
+func TestParseTimeZoneTwice(t *testing.T) {
+	if _, err := ParseInLocation("CRON_TZ=UTC * * * * *", time.UTC); err == nil {
+		t.Errorf("ParseInLocation accepted a schedule with its own time zone")
+	}
+}
+
+func TestNext(t *testing.T) {
+	for _, test := range []struct {
+		spec, from, next string
+	}{
+		{"* * * * *", "2018-11-01 10:00", "2018-11-01 10:01"},
+		{"*/15 * * * *", "2018-11-01 10:07", "2018-11-01 10:15"},
+		{"5/15 * * * *", "2018-11-01 10:21", "2018-11-01 10:35"},
+		{"0 9-17/4 * * *", "2018-11-01 13:00", "2018-11-01 17:00"},
+		{"0,30 * * * *", "2018-11-01 10:29", "2018-11-01 10:30"},
+		{"0 0 * * *", "2018-12-31 23:59", "2019-01-01 00:00"},
+		{"@hourly", "2018-11-01 10:00", "2018-11-01 11:00"},
+		{"@daily", "2018-11-01 10:00", "2018-11-02 00:00"},
+		{"@weekly", "2018-11-01 10:00", "2018-11-04 00:00"},
+		{"@monthly", "2018-11-01 10:00", "2018-12-01 00:00"},
+		{"@yearly", "2018-11-01 10:00", "2019-01-01 00:00"},
+		{"0 12 * jan,JUL *", "2018-11-01 10:00", "2019-01-01 12:00"},
+		{"0 12 * * mon-fri", "2018-11-02 12:00", "2018-11-05 12:00"},
+		// leap days
+		{"0 0 29 2 *", "2018-11-01 10:00", "2020-02-29 00:00"},
+		// day of month and day of week both restricted match either
+		{"0 0 13 * 5", "2018-11-01 10:00", "2018-11-02 00:00"},
+		{"0 0 13 * 5", "2018-11-10 10:00", "2018-11-13 00:00"},
+		// but a stepped day of week is still a restriction
+		{"0 0 13 * */3", "2018-11-01 10:00", "2018-11-03 00:00"},
+		// and a "*" day of week defers to the day of month
+		{"0 0 13 * *", "2018-11-01 10:00", "2018-11-13 00:00"},
+		{"0 0 * * 0", "2018-11-01 10:00", "2018-11-04 00:00"},
+	} {
+		schedule, err := Parse(test.spec)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", test.spec, err)
+			continue
+		}
+
+		from, want := mustTime(t, test.from, time.UTC), mustTime(t, test.next, time.UTC)
+		if got := schedule.Next(from); !got.Equal(want) {
+			t.Errorf("%q.Next(%s) = %s, want %s", test.spec, test.from, got, want)
+		}
+	}
+}
+
+func TestNextNever(t *testing.T) {
+	schedule, err := Parse("0 0 30 2 *")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if next := schedule.Next(time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)); !next.IsZero() {
+		t.Errorf("30 feb is due at %s", next)
+	}
+}
+
+func TestNextEvery(t *testing.T) {
+	schedule, err := Parse("@every 90s")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	from := time.Date(2018, 11, 1, 10, 0, 0, 500, time.UTC)
+	if got, want := schedule.Next(from), time.Date(2018, 11, 1, 10, 1, 30, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("Next = %s, want %s", got, want)
+	}
+}
+
+func TestNextInLocation(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("no time zone data: %v", err)
+	}
+
+	from := time.Date(2018, 11, 1, 14, 0, 0, 0, time.UTC)
+	want := time.Date(2018, 11, 2, 13, 0, 0, 0, time.UTC)
+
+	schedule, err := ParseInLocation("0 9 * * *", newYork)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := schedule.Next(from); !got.Equal(want) {
+		t.Errorf("timeZone: Next = %s, want %s", got, want)
+	}
+
+	schedule, err = Parse("CRON_TZ=America/New_York 0 9 * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := schedule.Next(from); !got.Equal(want) {
+		t.Errorf("CRON_TZ: Next = %s, want %s", got, want)
+	}
+
+	// results are in the zone of the time given
+	if got := schedule.Next(from); got.Location() != time.UTC {
+		t.Errorf("Next returned a time in %s", got.Location())
+	}
+}
+
+func TestNextDaylightSaving(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("no time zone data: %v", err)
+	}
+
+	// 02:30 doesn't exist the day DST starts, so that day is skipped
+	schedule, err := ParseInLocation("30 2 * * *", newYork)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	from := mustTime(t, "2019-03-10 00:00", newYork)
+	if got, want := schedule.Next(from), mustTime(t, "2019-03-11 02:30", newYork); !got.Equal(want) {
+		t.Errorf("Next = %s, want %s", got, want)
+	}
+
+	// hourly runs keep going across the change
+	schedule, err = ParseInLocation("0 * * * *", newYork)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	from = mustTime(t, "2019-03-10 01:30", newYork)
+	if got, want := schedule.Next(from), mustTime(t, "2019-03-10 03:00", newYork); !got.Equal(want) {
+		t.Errorf("Next = %s, want %s", got, want)
+	}
+}
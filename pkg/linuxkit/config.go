@@ -85,6 +85,8 @@ type Image struct {
 	Name        string `yaml:"name" json:"name"`
 	Image       string `yaml:"image" json:"image"`
 	ImageConfig `yaml:",inline"`
+
+	ref *reference.Spec
 }
 
 // ImageConfig is the configuration part of Image, it is the subset
@@ -132,7 +134,7 @@ type Runtime struct {
 	Cgroups    *[]string    `yaml:"cgroups,omitempty" json:"cgroups,omitempty"`
 	Mounts     *[]Mount     `yaml:"mounts,omitempty" json:"mounts,omitempty"`
 	Mkdir      *[]string    `yaml:"mkdir,omitempty" json:"mkdir,omitempty"`
-	Interfaces *[]Interface `yaml:"interfaces,omitempty,omitempty" json:"interfaces,omitempty"`
+	Interfaces *[]Interface `yaml:"interfaces,omitempty" json:"interfaces,omitempty"`
 	BindNS     Namespaces   `yaml:"bindNS,omitempty" json:"bindNS,omitempty"`
 	Namespace  *string      `yaml:"namespace,omitempty" json:"namespace,omitempty"`
 }
@@ -0,0 +1,104 @@
+package linuxkit
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+)
+
+// ConfigLabel is the label LinuxKit images carry the config they need in, which linuxkit build uses for whatever
+// the config of the image in the yaml doesn't set
+const ConfigLabel = "org.mobyproject.config"
+
+// ParseConfigLabel decodes the config in an image's label
+func ParseConfigLabel(label string) (ImageConfig, error) {
+	config := ImageConfig{}
+	err := json.Unmarshal([]byte(label), &config)
+	return config, err
+}
+
+// ConfigDifference is a field of the config set by both the image's label and the yaml, or only by the label
+type ConfigDifference struct {
+	// Field is the name of the field in the yaml
+	Field string
+	// Label and Config are what the image's label and the yaml set it to, Config is nil when it was taken from the label
+	Label  interface{}
+	Config interface{}
+	// Dropped is what the label had in a list, like binds or capabilities, which the yaml doesn't
+	Dropped []string
+}
+
+// MergeConfigLabel fills in the fields of a config which aren't set with those of an image's label, the way
+// linuxkit build does, and returns every field the two didn't agree on
+func MergeConfigLabel(config *ImageConfig, label ImageConfig) []ConfigDifference {
+	differences := []ConfigDifference{}
+
+	configValue := reflect.ValueOf(config).Elem()
+	labelValue := reflect.ValueOf(label)
+	for idx := 0; idx < configValue.NumField(); idx++ {
+		field := configValue.Type().Field(idx)
+		if field.PkgPath != "" {
+			continue
+		}
+		name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+
+		from := labelValue.Field(idx)
+		into := configValue.Field(idx)
+		if isUnset(from) {
+			continue
+		}
+
+		if isUnset(into) {
+			into.Set(from)
+			differences = append(differences, ConfigDifference{Field: name, Label: from.Interface()})
+			continue
+		}
+
+		if reflect.DeepEqual(from.Interface(), into.Interface()) {
+			continue
+		}
+
+		differences = append(differences, ConfigDifference{
+			Field:   name,
+			Label:   from.Interface(),
+			Config:  into.Interface(),
+			Dropped: droppedItems(from, into),
+		})
+	}
+
+	return differences
+}
+
+func isUnset(value reflect.Value) bool {
+	switch value.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		return value.IsNil()
+	case reflect.String:
+		return value.Len() == 0
+	}
+	return false
+}
+
+// droppedItems lists the items of a list in the label which aren't in the config's
+func droppedItems(from reflect.Value, into reflect.Value) []string {
+	labelList, ok := from.Interface().(*[]string)
+	if !ok {
+		return nil
+	}
+	configList := into.Interface().(*[]string)
+
+	dropped := []string{}
+	for _, item := range *labelList {
+		found := false
+		for _, other := range *configList {
+			if item == other {
+				found = true
+				break
+			}
+		}
+		if !found {
+			dropped = append(dropped, item)
+		}
+	}
+	return dropped
+}
@@ -0,0 +1,41 @@
+package linuxkit
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMergeConfigLabel(t *testing.T) {
+	label, err := ParseConfigLabel(`{"binds":["/etc/resolv.conf:/etc/resolv.conf","/var/run:/var/run"],"capabilities":["CAP_NET_ADMIN"],"net":"host","readonly":true}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	readonly := false
+	config := ImageConfig{
+		Binds:    &[]string{"/var/run:/var/run", "/data:/data"},
+		Net:      "host",
+		Readonly: &readonly,
+	}
+
+	differences := MergeConfigLabel(&config, label)
+
+	got := map[string]ConfigDifference{}
+	for _, difference := range differences {
+		got[difference.Field] = difference
+	}
+	if len(got) != 3 {
+		t.Errorf("expected differences in binds, capabilities and readonly, got %v", differences)
+	}
+
+	if binds := got["binds"]; strings.Join(binds.Dropped, " ") != "/etc/resolv.conf:/etc/resolv.conf" {
+		t.Errorf("dropped binds are %v", binds.Dropped)
+	}
+	if len(*config.Binds) != 2 || *config.Readonly {
+		t.Errorf("the label overrode the config")
+	}
+
+	if capabilities := got["capabilities"]; capabilities.Config != nil || config.Capabilities == nil || (*config.Capabilities)[0] != "CAP_NET_ADMIN" {
+		t.Errorf("capabilities weren't taken from the label")
+	}
+}
@@ -0,0 +1,167 @@
+package linuxkit
+
+import (
+	"fmt"
+	"github.com/containerd/containerd/reference"
+	"gopkg.in/yaml.v2"
+	"reflect"
+	"strings"
+)
+
+// adapted from NewConfig and AppendConfig in
+// https://raw.githubusercontent.com/linuxkit/linuxkit/v0.6/src/cmd/linuxkit/moby/config.go
+
+// NewConfig parses a config file
+func NewConfig(config []byte) (Moby, error) {
+	m := Moby{}
+
+	if err := yaml.UnmarshalStrict(config, &m); err != nil {
+		return m, err
+	}
+
+	return m, nil
+}
+
+// AppendConfig appends two configs the way linuxkit build does when it is given several files: the kernel fields
+// set in m1 override those of m0, and everything else is appended. Where linuxkit only refuses duplicate service
+// names, every image name or file path that both configs have is reported as a conflict.
+//
+// An image or file of m1 which is identical to one of m0 is the exception: it is kept once rather than reported, so
+// files that overlap can still be layered, like a platform profile and base_image.yaml which both start dhcpcd, or a
+// base generated by podspec2linuxkit which already has the entrypoint shim a pod needs. Anything that differs, even
+// in a single field, is a conflict, since one of the two would silently be lost.
+func AppendConfig(m0, m1 Moby) (Moby, error) {
+	moby := m0
+	conflicts := []string{}
+
+	if m1.Kernel != nil {
+		kernel := KernelConfig{}
+		if m0.Kernel != nil {
+			kernel = *m0.Kernel
+		}
+		if m1.Kernel.Image != "" {
+			kernel.Image = m1.Kernel.Image
+		}
+		if m1.Kernel.Cmdline != "" {
+			kernel.Cmdline = m1.Kernel.Cmdline
+		}
+		if m1.Kernel.Binary != "" {
+			kernel.Binary = m1.Kernel.Binary
+		}
+		if m1.Kernel.Tar != nil {
+			kernel.Tar = m1.Kernel.Tar
+		}
+		if m1.Kernel.UCode != nil {
+			kernel.UCode = m1.Kernel.UCode
+		}
+		moby.Kernel = &kernel
+	}
+
+	moby.Init = appendStrings(m0.Init, m1.Init)
+	moby.Onboot = appendImages("onboot", m0.Onboot, m1.Onboot, &conflicts)
+	moby.Onshutdown = appendImages("onshutdown", m0.Onshutdown, m1.Onshutdown, &conflicts)
+	moby.Services = appendImages("services", m0.Services, m1.Services, &conflicts)
+	moby.Files = appendFiles(m0.Files, m1.Files, &conflicts)
+	moby.Trust.Image = *appendStrings(&m0.Trust.Image, &m1.Trust.Image)
+	moby.Trust.Org = *appendStrings(&m0.Trust.Org, &m1.Trust.Org)
+	moby.initRefs = append(append([]*reference.Spec{}, m0.initRefs...), m1.initRefs...)
+
+	if len(conflicts) > 0 {
+		return moby, fmt.Errorf("both configs have %s", strings.Join(conflicts, ", "))
+	}
+
+	return moby, nil
+}
+
+// appendStrings appends the strings of b which a doesn't already have
+func appendStrings(a, b *[]string) *[]string {
+	if a == nil && b == nil {
+		return nil
+	}
+
+	result := []string{}
+	seen := map[string]bool{}
+	for _, list := range []*[]string{a, b} {
+		if list == nil {
+			continue
+		}
+		for _, s := range *list {
+			if !seen[s] {
+				seen[s] = true
+				result = append(result, s)
+			}
+		}
+	}
+
+	return &result
+}
+
+// appendImages appends the images of b to a, keeping those identical to one of a only once
+func appendImages(section string, a, b *[]*Image, conflicts *[]string) *[]*Image {
+	if a == nil && b == nil {
+		return nil
+	}
+
+	result := []*Image{}
+	if a != nil {
+		result = append(result, *a...)
+	}
+
+	if b == nil {
+		return &result
+	}
+
+	for _, image := range *b {
+		duplicate := false
+		for _, existing := range result {
+			if existing.Name == image.Name {
+				duplicate = true
+				if !reflect.DeepEqual(existing, image) {
+					*conflicts = append(*conflicts, fmt.Sprintf("%s %s", section, image.Name))
+				}
+				break
+			}
+		}
+
+		if !duplicate {
+			result = append(result, image)
+		}
+	}
+
+	return &result
+}
+
+// appendFiles appends the files of b to a, keeping those identical to one of a only once
+func appendFiles(a, b *[]File, conflicts *[]string) *[]File {
+	if a == nil && b == nil {
+		return nil
+	}
+
+	result := []File{}
+	if a != nil {
+		result = append(result, *a...)
+	}
+
+	if b == nil {
+		return &result
+	}
+
+	for _, file := range *b {
+		duplicate := false
+		for _, existing := range result {
+			if strings.Trim(existing.Path, "/") == strings.Trim(file.Path, "/") {
+				duplicate = true
+				if !reflect.DeepEqual(existing, file) {
+					*conflicts = append(*conflicts, fmt.Sprintf("file %s", file.Path))
+				}
+				break
+			}
+		}
+
+		if !duplicate {
+			result = append(result, file)
+		}
+	}
+
+	return &result
+}
@@ -0,0 +1,121 @@
+package linuxkit
+
+import (
+	"strings"
+	"testing"
+)
+
+// base_image.yaml, trimmed down
+const testBase = `kernel:
+  image: linuxkit/kernel:4.19.8
+  cmdline: console=tty0
+init:
+- linuxkit/init:v0.6
+onboot:
+- name: dhcpcd
+  image: linuxkit/dhcpcd:v0.6
+  command: ["/sbin/dhcpcd", "--nobackground", "-f", "/dhcpcd.conf", "-1"]
+services:
+- name: dhcpcd
+  image: linuxkit/dhcpcd:v0.6
+- name: getty
+  image: linuxkit/getty:v0.6
+files:
+- path: etc/motd
+  contents: hello
+`
+
+func TestAppendConfig(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		other string
+		// the names of the merged onboot images, services and files, or the conflicts
+		merged string
+		err    string
+	}{
+		{
+			name: "a platform profile which starts the same dhcpcd, and its own agent",
+			other: `kernel:
+  cmdline: console=ttyS0
+init:
+- linuxkit/init:v0.6
+services:
+- name: dhcpcd
+  image: linuxkit/dhcpcd:v0.6
+- name: open-vm-tools
+  image: linuxkit/open-vm-tools:v0.6
+`,
+			merged: "dhcpcd | dhcpcd getty open-vm-tools | etc/motd",
+		},
+		{
+			name: "another dhcpcd service",
+			other: `services:
+- name: dhcpcd
+  image: linuxkit/dhcpcd:v0.5
+`,
+			err: "both configs have services dhcpcd",
+		},
+		{
+			name: "the same dhcpcd with other options, in each section",
+			other: `onboot:
+- name: dhcpcd
+  image: linuxkit/dhcpcd:v0.6
+  command: ["/sbin/dhcpcd", "--nobackground", "-f", "/dhcpcd.conf", "-1", "-4"]
+services:
+- name: dhcpcd
+  image: linuxkit/dhcpcd:v0.6
+  net: host
+`,
+			err: "both configs have onboot dhcpcd, services dhcpcd",
+		},
+		{
+			name: "a file at the same path, with a leading /",
+			other: `files:
+- path: /etc/motd
+  contents: goodbye
+`,
+			err: "both configs have file /etc/motd",
+		},
+	} {
+		base, err := NewConfig([]byte(testBase))
+		if err != nil {
+			t.Fatal(err)
+		}
+		other, err := NewConfig([]byte(test.other))
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+
+		merged, err := AppendConfig(base, other)
+		if test.err != "" {
+			if err == nil || err.Error() != test.err {
+				t.Errorf("%s: got %v, want %s", test.name, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+
+		names := func(images *[]*Image) string {
+			result := []string{}
+			for _, image := range *images {
+				result = append(result, image.Name)
+			}
+			return strings.Join(result, " ")
+		}
+		files := []string{}
+		for _, file := range *merged.Files {
+			files = append(files, file.Path)
+		}
+		if got := names(merged.Onboot) + " | " + names(merged.Services) + " | " + strings.Join(files, " "); got != test.merged {
+			t.Errorf("%s: got %s, want %s", test.name, got, test.merged)
+		}
+
+		// the kernel fields set by the other config win, init is only listed once
+		if merged.Kernel.Image != "linuxkit/kernel:4.19.8" || merged.Kernel.Cmdline != "console=ttyS0" || len(*merged.Init) != 1 {
+			t.Errorf("%s: got kernel %+v and init %v", test.name, *merged.Kernel, *merged.Init)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package linuxkit
+
+import (
+	"fmt"
+	"github.com/containerd/containerd/reference"
+	"regexp"
+	"strings"
+)
+
+// adapted from ReferenceExpand in
+// https://raw.githubusercontent.com/linuxkit/linuxkit/v0.6/src/cmd/linuxkit/util/reference.go and updateImages in
+// https://raw.githubusercontent.com/linuxkit/linuxkit/v0.6/src/cmd/linuxkit/moby/config.go
+
+// ReferenceExpand expands "redis" to "docker.io/library/redis:latest" and "foo/bar" to "docker.io/foo/bar:latest",
+// leaving references which start with a registry, and already have a tag or digest, as they are
+func ReferenceExpand(ref string) string {
+	parts := strings.Split(ref, "/")
+	// like docker, the first component is a registry when it looks like a host name
+	if len(parts) == 1 || !strings.ContainsAny(parts[0], ".:") && parts[0] != "localhost" {
+		if len(parts) == 1 {
+			ref = "library/" + ref
+		}
+		ref = "docker.io/" + ref
+	}
+
+	name := ref[strings.LastIndex(ref, "/")+1:]
+	if !strings.ContainsAny(name, ":@") {
+		ref += ":latest"
+	}
+
+	return ref
+}
+
+// the grammar of docker references, which linuxkit build parses them with, and is stricter than containerd's
+var (
+	domainRegexp    = regexp.MustCompile(`^(?:[a-zA-Z0-9]|[a-zA-Z0-9][a-zA-Z0-9-]*[a-zA-Z0-9])(?:\.(?:[a-zA-Z0-9]|[a-zA-Z0-9][a-zA-Z0-9-]*[a-zA-Z0-9]))*(?::[0-9]+)?$`)
+	componentRegexp = regexp.MustCompile(`^[a-z0-9]+(?:(?:[._]|__|[-]*)[a-z0-9]+)*$`)
+	tagRegexp       = regexp.MustCompile(`^[\w][\w.-]{0,127}$`)
+)
+
+// ParseReference expands and parses an image reference
+func ParseReference(image string) (reference.Spec, error) {
+	ref, err := reference.Parse(ReferenceExpand(image))
+	if err != nil {
+		return ref, fmt.Errorf("invalid image reference %s: %v", image, err)
+	}
+
+	components := strings.Split(ref.Locator, "/")
+	if !domainRegexp.MatchString(components[0]) {
+		return ref, fmt.Errorf("invalid image reference %s: invalid registry %s", image, components[0])
+	}
+	for _, component := range components[1:] {
+		if !componentRegexp.MatchString(component) {
+			return ref, fmt.Errorf("invalid image reference %s: invalid name %s", image, strings.Join(components[1:], "/"))
+		}
+	}
+	if tag := ReferenceTag(ref); tag != "" && !tagRegexp.MatchString(tag) {
+		return ref, fmt.Errorf("invalid image reference %s: invalid tag %s", image, tag)
+	}
+	if strings.Contains(ref.Object, "@") {
+		if err := ref.Digest().Validate(); err != nil {
+			return ref, fmt.Errorf("invalid image reference %s: %v", image, err)
+		}
+	}
+
+	return ref, nil
+}
+
+// ReferenceTag returns the tag of a reference, if it has one
+func ReferenceTag(ref reference.Spec) string {
+	tag, _ := reference.SplitObject(ref.Object)
+	return strings.TrimSuffix(tag, "@")
+}
+
+// UpdateImages parses every image reference in the config, the kernel, init and those of each section, and writes
+// them back normalized after update, if given, has had a chance to change them. update is also given the image the
+// reference belongs to, or nil for the kernel and init.
+func (m *Moby) UpdateImages(update func(ref *reference.Spec, image *Image) error) error {
+	updateImage := func(s *string, image *Image) (*reference.Spec, error) {
+		ref, err := ParseReference(*s)
+		if err != nil {
+			return nil, err
+		}
+
+		if update != nil {
+			if err := update(&ref, image); err != nil {
+				return nil, err
+			}
+		}
+
+		*s = ref.String()
+		return &ref, nil
+	}
+
+	if m.Kernel != nil && m.Kernel.Image != "" {
+		ref, err := updateImage(&m.Kernel.Image, nil)
+		if err != nil {
+			return err
+		}
+		m.Kernel.ref = ref
+	}
+
+	m.initRefs = nil
+	if m.Init != nil {
+		for idx := range *m.Init {
+			ref, err := updateImage(&(*m.Init)[idx], nil)
+			if err != nil {
+				return err
+			}
+			m.initRefs = append(m.initRefs, ref)
+		}
+	}
+
+	for _, section := range []*[]*Image{m.Onboot, m.Onshutdown, m.Services} {
+		if section == nil {
+			continue
+		}
+		for _, image := range *section {
+			ref, err := updateImage(&image.Image, image)
+			if err != nil {
+				return fmt.Errorf("%s: %v", image.Name, err)
+			}
+			image.ref = ref
+		}
+	}
+
+	return nil
+}
+
+// ImageReferences lists the images the config uses once each, in the order linuxkit build pulls them: the kernel,
+// init and those of each section
+func (m *Moby) ImageReferences() []string {
+	references := []string{}
+	seen := map[string]bool{}
+	add := func(image string) {
+		if image != "" && !seen[image] {
+			seen[image] = true
+			references = append(references, image)
+		}
+	}
+
+	if m.Kernel != nil {
+		add(m.Kernel.Image)
+	}
+	if m.Init != nil {
+		for _, image := range *m.Init {
+			add(image)
+		}
+	}
+	for _, section := range []*[]*Image{m.Onboot, m.Onshutdown, m.Services} {
+		if section == nil {
+			continue
+		}
+		for _, image := range *section {
+			add(image.Image)
+		}
+	}
+
+	return references
+}
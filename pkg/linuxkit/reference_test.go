@@ -0,0 +1,100 @@
+package linuxkit
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestReferenceExpand(t *testing.T) {
+	for ref, want := range map[string]string{
+		"busybox":                          "docker.io/library/busybox:latest",
+		"busybox:1.29":                     "docker.io/library/busybox:1.29",
+		"linuxkit/kernel:4.19.8":           "docker.io/linuxkit/kernel:4.19.8",
+		"gcr.io/project/app":               "gcr.io/project/app:latest",
+		"localhost/app:1":                  "localhost/app:1",
+		"registry:5000/team/app":           "registry:5000/team/app:latest",
+		"alpine@sha256:0123456789abcdef":   "docker.io/library/alpine@sha256:0123456789abcdef",
+		"quay.io/team/app:1.0@sha256:0123": "quay.io/team/app:1.0@sha256:0123",
+	} {
+		if got := ReferenceExpand(ref); got != want {
+			t.Errorf("%s: got %s, want %s", ref, got, want)
+		}
+	}
+}
+
+func TestUpdateImages(t *testing.T) {
+	m, err := NewConfig([]byte(`kernel:
+  image: linuxkit/kernel:4.19.8
+init:
+- linuxkit/init:v0.6
+onboot:
+- name: sysctl
+  image: linuxkit/sysctl:v0.6
+services:
+- name: web
+  image: nginx
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.UpdateImages(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	for got, want := range map[string]string{
+		m.Kernel.Image:         "docker.io/linuxkit/kernel:4.19.8",
+		(*m.Init)[0]:           "docker.io/linuxkit/init:v0.6",
+		(*m.Onboot)[0].Image:   "docker.io/linuxkit/sysctl:v0.6",
+		(*m.Services)[0].Image: "docker.io/library/nginx:latest",
+	} {
+		if got != want {
+			t.Errorf("got %s, want %s", got, want)
+		}
+	}
+
+	if m.Kernel.ref == nil || len(m.initRefs) != 1 || (*m.Services)[0].ref.Locator != "docker.io/library/nginx" {
+		t.Errorf("references weren't recorded")
+	}
+}
+
+func TestImageReferences(t *testing.T) {
+	m, err := NewConfig([]byte(`kernel:
+  image: linuxkit/kernel:4.19.8
+init:
+- linuxkit/init:v0.6
+onboot:
+- name: sysctl
+  image: linuxkit/sysctl:v0.6
+onshutdown:
+- name: sysctl
+  image: linuxkit/sysctl:v0.6
+services:
+- name: web
+  image: nginx
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := strings.Join(m.ImageReferences(), " ")
+	if want := "linuxkit/kernel:4.19.8 linuxkit/init:v0.6 linuxkit/sysctl:v0.6 nginx"; got != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+}
+
+func TestParseReference(t *testing.T) {
+	for ref, valid := range map[string]bool{
+		"busybox":                                true,
+		"registry:5000/team/app_server:1.0-rc.1": true,
+		"quay.io/team/app:1.0@sha256:" + strings.Repeat("a", 64): true,
+		"Team/App":                  false,
+		"busybox:-latest":           false,
+		"reg!stry.io/app":           false,
+		"busybox@sha256:0123456789": false,
+	} {
+		if _, err := ParseReference(ref); (err == nil) != valid {
+			t.Errorf("%s: expected valid %v, got %v", ref, valid, err)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package linuxkit
+
+import (
+	"strings"
+)
+
+// adapted from enforceContentTrust in
+// https://raw.githubusercontent.com/linuxkit/linuxkit/v0.6/src/cmd/linuxkit/moby/build.go
+
+// ImageOrg returns the org of an image, the part of its name after the registry, library for official images
+func ImageOrg(image string) string {
+	splitName := strings.Split(image, "/")
+	switch len(splitName) {
+	case 1:
+		// for single names like nginx, use library
+		return "library"
+	case 2:
+		// for names that assume docker hub, like linuxkit/alpine, take the first split
+		return splitName[0]
+	}
+	// for names that include the registry, the second piece of the name is the org
+	return splitName[1]
+}
+
+// Enforced says whether linuxkit build checks the signature of an image
+func (t TrustConfig) Enforced(image string) bool {
+	for _, img := range t.Image {
+		// first check for an exact name match
+		if img == image {
+			return true
+		}
+		// also check for an image name only match by removing a possible tag, with possibly added digest
+		imgAndTag := strings.Split(image, ":")
+		if len(imgAndTag) >= 2 && img == imgAndTag[0] {
+			return true
+		}
+		// and by removing a possible digest
+		imgAndDigest := strings.Split(image, "@sha256:")
+		if len(imgAndDigest) >= 2 && img == imgAndDigest[0] {
+			return true
+		}
+	}
+
+	for _, org := range t.Org {
+		if ImageOrg(image) == org {
+			return true
+		}
+	}
+
+	return false
+}
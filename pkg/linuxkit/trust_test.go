@@ -0,0 +1,27 @@
+package linuxkit
+
+import (
+	"testing"
+)
+
+func TestEnforced(t *testing.T) {
+	trust := TrustConfig{
+		Image: []string{"docker.io/library/nginx", "docker.io/library/redis:5"},
+		Org:   []string{"linuxkit"},
+	}
+
+	for image, want := range map[string]bool{
+		"docker.io/linuxkit/kernel:4.19.8":            true,
+		"linuxkit/init:v0.6":                          true,
+		"docker.io/library/nginx:1.15":                true,
+		"docker.io/library/nginx@sha256:0123":         true,
+		"docker.io/library/redis:5":                   true,
+		"docker.io/library/redis:4":                   false,
+		"docker.io/library/busybox:latest":            false,
+		"mirror.internal/linuxkit-mirror/kernel:4.19": false,
+	} {
+		if got := trust.Enforced(image); got != want {
+			t.Errorf("%s: got %v, want %v", image, got, want)
+		}
+	}
+}
@@ -0,0 +1,280 @@
+package linuxkit
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// adapted from the checks of NewConfig, ConfigInspectToOCI and filesystem.go in
+// https://raw.githubusercontent.com/linuxkit/linuxkit/v0.6/src/cmd/linuxkit/moby/
+
+// the capabilities linuxkit build knows, all of them being what "all" stands for
+var allCaps = []string{
+	"CAP_AUDIT_CONTROL",
+	"CAP_AUDIT_READ",
+	"CAP_AUDIT_WRITE",
+	"CAP_BLOCK_SUSPEND",
+	"CAP_CHOWN",
+	"CAP_DAC_OVERRIDE",
+	"CAP_DAC_READ_SEARCH",
+	"CAP_FOWNER",
+	"CAP_FSETID",
+	"CAP_IPC_LOCK",
+	"CAP_IPC_OWNER",
+	"CAP_KILL",
+	"CAP_LEASE",
+	"CAP_LINUX_IMMUTABLE",
+	"CAP_MAC_ADMIN",
+	"CAP_MAC_OVERRIDE",
+	"CAP_MKNOD",
+	"CAP_NET_ADMIN",
+	"CAP_NET_BIND_SERVICE",
+	"CAP_NET_BROADCAST",
+	"CAP_NET_RAW",
+	"CAP_SETFCAP",
+	"CAP_SETGID",
+	"CAP_SETPCAP",
+	"CAP_SETUID",
+	"CAP_SYSLOG",
+	"CAP_SYS_ADMIN",
+	"CAP_SYS_BOOT",
+	"CAP_SYS_CHROOT",
+	"CAP_SYS_MODULE",
+	"CAP_SYS_NICE",
+	"CAP_SYS_PACCT",
+	"CAP_SYS_PTRACE",
+	"CAP_SYS_RAWIO",
+	"CAP_SYS_RESOURCE",
+	"CAP_SYS_TIME",
+	"CAP_SYS_TTY_CONFIG",
+	"CAP_WAKE_ALARM",
+}
+
+// ValidationError lists everything wrong with a config
+type ValidationError []string
+
+func (e ValidationError) Error() string {
+	return strings.Join(e, "\n")
+}
+
+func validCapability(capability string) bool {
+	if strings.ToLower(capability) == "all" {
+		return true
+	}
+	capability = strings.ToUpper(capability)
+	if !strings.HasPrefix(capability, "CAP_") {
+		capability = "CAP_" + capability
+	}
+	for _, known := range allCaps {
+		if capability == known {
+			return true
+		}
+	}
+	return false
+}
+
+// validNamespace says whether a namespace is shared with the host, new, or the one bound to a path
+func validNamespace(ns string) bool {
+	return ns == "" || ns == "host" || ns == "new" || path.IsAbs(ns)
+}
+
+// Validate checks a config the way linuxkit build would when building it, so what it would refuse is found before
+// then: images need a name, unique among services, and a valid reference, capabilities have to be known, binds
+// and mounts need absolute paths, namespaces are host, new or a path, and files say what they are
+func (m *Moby) Validate() error {
+	problems := ValidationError{}
+	problem := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	// a config without a kernel image, like one only changing the cmdline, is built without a kernel
+	if m.Kernel != nil && m.Kernel.Image != "" {
+		if _, err := ParseReference(m.Kernel.Image); err != nil {
+			problem("kernel: %v", err)
+		}
+	}
+
+	if m.Init != nil {
+		for _, image := range *m.Init {
+			if _, err := ParseReference(image); err != nil {
+				problem("init: %v", err)
+			}
+		}
+	}
+
+	for _, section := range []struct {
+		name   string
+		images *[]*Image
+	}{
+		{"onboot", m.Onboot},
+		{"onshutdown", m.Onshutdown},
+		{"services", m.Services},
+	} {
+		if section.images == nil {
+			continue
+		}
+
+		// services run side by side, so theirs have to be unique
+		names := map[string]bool{}
+		for idx, image := range *section.images {
+			prefix := fmt.Sprintf("%s[%d] %s", section.name, idx, image.Name)
+			if image.Name == "" {
+				problem("%s: no name", prefix)
+			}
+			if section.name == "services" && image.Name != "" {
+				if names[image.Name] {
+					problem("%s: duplicate service name", prefix)
+				}
+				names[image.Name] = true
+			}
+
+			if image.Image == "" {
+				problem("%s: no image", prefix)
+			} else if _, err := ParseReference(image.Image); err != nil {
+				problem("%s: %v", prefix, err)
+			}
+
+			for _, err := range image.ImageConfig.validate() {
+				problem("%s: %s", prefix, err)
+			}
+		}
+	}
+
+	if m.Files != nil {
+		for idx, file := range *m.Files {
+			for _, err := range file.validate() {
+				problem("files[%d] %s: %s", idx, file.Path, err)
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return problems
+	}
+	return nil
+}
+
+func (c *ImageConfig) validate() []string {
+	problems := []string{}
+	problem := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	for _, capabilities := range []*[]string{c.Capabilities, c.Ambient} {
+		if capabilities == nil {
+			continue
+		}
+		for _, capability := range *capabilities {
+			if !validCapability(capability) {
+				problem("unknown capability %s", capability)
+			}
+		}
+	}
+
+	if c.Binds != nil {
+		for _, bind := range *c.Binds {
+			parts := strings.Split(bind, ":")
+			switch {
+			case len(parts) < 2:
+				problem("bind %s is missing a ':'", bind)
+			case len(parts) > 3:
+				problem("bind %s has too many ':'", bind)
+			case !path.IsAbs(parts[0]) || !path.IsAbs(parts[1]):
+				problem("bind %s needs absolute paths", bind)
+			}
+		}
+	}
+
+	if c.Mounts != nil {
+		for _, mount := range *c.Mounts {
+			if !path.IsAbs(mount.Destination) {
+				problem("mount destination %s isn't an absolute path", mount.Destination)
+			}
+		}
+	}
+
+	if c.Tmpfs != nil {
+		for _, tmpfs := range *c.Tmpfs {
+			if !path.IsAbs(strings.SplitN(tmpfs, ":", 2)[0]) {
+				problem("tmpfs %s isn't an absolute path", tmpfs)
+			}
+		}
+	}
+
+	if c.Cwd != "" && !path.IsAbs(c.Cwd) {
+		problem("cwd %s isn't an absolute path", c.Cwd)
+	}
+
+	for _, ns := range []struct{ name, value string }{
+		{"net", c.Net},
+		{"pid", c.Pid},
+		{"ipc", c.Ipc},
+		{"uts", c.Uts},
+		{"userns", c.Userns},
+	} {
+		if !validNamespace(ns.value) {
+			problem("%s %s isn't host, new or the path of a namespace", ns.name, ns.value)
+		}
+	}
+
+	if c.Runtime != nil {
+		if c.Runtime.Mkdir != nil {
+			for _, dir := range *c.Runtime.Mkdir {
+				if !path.IsAbs(dir) {
+					problem("runtime mkdir %s isn't an absolute path", dir)
+				}
+			}
+		}
+		if c.Runtime.Mounts != nil {
+			for _, mount := range *c.Runtime.Mounts {
+				if !path.IsAbs(mount.Destination) {
+					problem("runtime mount destination %s isn't an absolute path", mount.Destination)
+				}
+			}
+		}
+		for _, bind := range []*string{
+			c.Runtime.BindNS.Cgroup, c.Runtime.BindNS.Ipc, c.Runtime.BindNS.Mnt, c.Runtime.BindNS.Net,
+			c.Runtime.BindNS.Pid, c.Runtime.BindNS.User, c.Runtime.BindNS.Uts,
+		} {
+			if bind != nil && !path.IsAbs(*bind) {
+				problem("runtime bindNS %s isn't an absolute path", *bind)
+			}
+		}
+	}
+
+	return problems
+}
+
+func (f *File) validate() []string {
+	problems := []string{}
+
+	if f.Path == "" {
+		problems = append(problems, "no path")
+	}
+
+	sources := 0
+	for _, set := range []bool{f.Contents != nil, f.Source != "", f.Symlink != "", f.Metadata != ""} {
+		if set {
+			sources++
+		}
+	}
+	if sources > 1 {
+		problems = append(problems, "only one of contents, source, symlink and metadata can be given")
+	}
+	if f.Directory && sources > 0 {
+		problems = append(problems, "a directory can't have contents, a source, a symlink or metadata")
+	}
+	if f.Metadata != "" && f.Metadata != "json" && f.Metadata != "yaml" {
+		problems = append(problems, fmt.Sprintf("metadata %s isn't json or yaml", f.Metadata))
+	}
+
+	if f.Mode != "" {
+		if _, err := strconv.ParseUint(f.Mode, 8, 32); err != nil {
+			problems = append(problems, fmt.Sprintf("mode %s isn't octal", f.Mode))
+		}
+	}
+
+	return problems
+}
@@ -0,0 +1,92 @@
+package linuxkit
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	valid, err := NewConfig([]byte(`kernel:
+  image: linuxkit/kernel:4.19.8
+init:
+- linuxkit/init:v0.6
+onboot:
+- name: mount
+  image: linuxkit/mount:v0.6
+  binds:
+  - /dev:/dev
+  capabilities:
+  - all
+- name: mount
+  image: linuxkit/mount:v0.6
+services:
+- name: web
+  image: nginx
+  capabilities:
+  - CAP_NET_BIND_SERVICE
+  - NET_ADMIN
+  pid: /run/pidns/shared
+  runtime:
+    interfaces:
+    - name: eth1
+      add: veth
+      peer: veth1
+    bindNS:
+      uts: /run/uts/web
+files:
+- path: etc/motd
+  contents: hello
+  mode: "0644"
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := valid.Validate(); err != nil {
+		t.Errorf("expected a valid config, got %v", err)
+	}
+
+	invalid, err := NewConfig([]byte(`services:
+- name: web
+  image: nginx
+  capabilities:
+  - CAP_MAKE_COFFEE
+  binds:
+  - /var/www
+  - data:/data
+  cwd: srv
+  net: bridge
+- name: web
+  image: "Not A Reference"
+files:
+- path: etc/motd
+  contents: hello
+  source: motd
+  mode: rw
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = invalid.Validate()
+	if err == nil {
+		t.Fatalf("expected the config to be invalid")
+	}
+	for _, want := range []string{
+		"unknown capability CAP_MAKE_COFFEE",
+		"bind /var/www is missing a ':'",
+		"bind data:/data needs absolute paths",
+		"cwd srv isn't an absolute path",
+		"net bridge isn't host",
+		"services[1] web: duplicate service name",
+		"invalid image reference Not A Reference",
+		"only one of contents, source",
+		"mode rw isn't octal",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("expected %q in:\n%v", want, err)
+		}
+	}
+	if problems := err.(ValidationError); len(problems) != 9 {
+		t.Errorf("expected 9 problems, got %d", len(problems))
+	}
+}
@@ -0,0 +1,91 @@
+package resolver
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/containerd/containerd/reference"
+	"io/ioutil"
+	"strings"
+)
+
+// ImageConfig has the parts of an OCI image config we need
+type ImageConfig struct {
+	Architecture string `json:"architecture,omitempty"`
+	OS           string `json:"os,omitempty"`
+	Config       struct {
+		User       string            `json:"User,omitempty"`
+		Env        []string          `json:"Env,omitempty"`
+		Entrypoint []string          `json:"Entrypoint,omitempty"`
+		Cmd        []string          `json:"Cmd,omitempty"`
+		WorkingDir string            `json:"WorkingDir,omitempty"`
+		Labels     map[string]string `json:"Labels,omitempty"`
+	} `json:"config"`
+}
+
+// selectPlatform finds the manifest for a platform, like linux/amd64, in an index
+func selectPlatform(index *Manifest, platform string) (Descriptor, error) {
+	for _, desc := range index.Manifests {
+		if desc.Platform != nil && desc.Platform.Matches(platform) {
+			return desc, nil
+		}
+	}
+
+	return Descriptor{}, fmt.Errorf("no image for %s", platform)
+}
+
+// FetchConfig fetches the config of an image, that of the given platform for multi-platform images
+func FetchConfig(fetcher Fetcher, ref reference.Spec, platform string) (*ImageConfig, error) {
+	_, manifest, err := Describe(fetcher, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	// an index can point at another, though only in theory
+	for depth := 0; manifest.IsIndex(); depth++ {
+		if depth > 2 {
+			return nil, fmt.Errorf("%s: indexes nested too deep", ref.String())
+		}
+
+		desc, err := selectPlatform(manifest, platform)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", ref.String(), err)
+		}
+
+		data, mediaType, err := fetcher.FetchManifest(ref, desc.Digest)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", ref.String(), err)
+		}
+		if manifest, _, err = ParseManifest(data, mediaType, desc.Digest); err != nil {
+			return nil, fmt.Errorf("%s: %v", ref.String(), err)
+		}
+	}
+
+	if manifest.Config == nil {
+		return nil, fmt.Errorf("%s: manifest without a config", ref.String())
+	}
+
+	content, err := fetcher.FetchBlob(ref, manifest.Config.Digest)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", ref.String(), err)
+	}
+	defer content.Close()
+
+	data, err := ioutil.ReadAll(content)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", ref.String(), err)
+	}
+	if err := manifest.Config.Digest.Validate(); err != nil || manifest.Config.Digest.Algorithm().FromBytes(data) != manifest.Config.Digest {
+		return nil, fmt.Errorf("%s: config doesn't match its digest %s", ref.String(), manifest.Config.Digest)
+	}
+
+	config := &ImageConfig{}
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("%s: config: %v", ref.String(), err)
+	}
+
+	if config.OS != "" && !strings.HasPrefix(platform, config.OS+"/") {
+		return nil, fmt.Errorf("%s is a %s image", ref.String(), config.OS)
+	}
+
+	return config, nil
+}
@@ -0,0 +1,62 @@
+package resolver
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/containerd/containerd/reference"
+	digest "github.com/opencontainers/go-digest"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+// memoryFetcher serves every reference from the same content, and nothing once it has none
+type memoryFetcher struct {
+	root    digest.Digest
+	content map[digest.Digest][]byte
+	fetches int
+}
+
+func (f *memoryFetcher) Resolve(ref reference.Spec) (digest.Digest, error) {
+	if f.content == nil {
+		return "", fmt.Errorf("offline")
+	}
+	return f.root, nil
+}
+
+func (f *memoryFetcher) FetchManifest(ref reference.Spec, dgst digest.Digest) ([]byte, string, error) {
+	f.fetches++
+	data, ok := f.content[dgst]
+	if !ok {
+		return nil, "", fmt.Errorf("%s not found", dgst)
+	}
+	return data, "", nil
+}
+
+func (f *memoryFetcher) FetchBlob(ref reference.Spec, dgst digest.Digest) (io.ReadCloser, error) {
+	data, _, err := f.FetchManifest(ref, dgst)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.NopCloser(bytes.NewReader(data)), nil
+}
+
+func TestFetchConfig(t *testing.T) {
+	root, content := testImage(t)
+	fetcher := &memoryFetcher{root: root, content: content}
+	ref := mustParse(t, "docker.io/team/app:1.0")
+
+	for _, arch := range []string{"amd64", "arm64"} {
+		config, err := FetchConfig(fetcher, ref, "linux/"+arch)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if config.Architecture != arch {
+			t.Errorf("got the config of %s, want %s", config.Architecture, arch)
+		}
+	}
+
+	if _, err := FetchConfig(fetcher, ref, "linux/s390x"); err == nil {
+		t.Errorf("expected no config for a platform the image doesn't have")
+	}
+}
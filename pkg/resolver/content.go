@@ -0,0 +1,103 @@
+package resolver
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/containerd/containerd/reference"
+	digest "github.com/opencontainers/go-digest"
+	"io"
+)
+
+// Descriptor points at a manifest or blob, as in an OCI image index or manifest
+type Descriptor struct {
+	MediaType   string            `json:"mediaType,omitempty"`
+	Digest      digest.Digest     `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Platform    *Platform         `json:"platform,omitempty"`
+}
+
+// Platform is what a manifest of a multi-platform image runs on
+type Platform struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+// Matches says whether this is a platform like linux/amd64, or linux/arm/v7 with its variant
+func (p *Platform) Matches(platform string) bool {
+	name := p.OS + "/" + p.Architecture
+	return platform == name || p.Variant != "" && platform == name+"/"+p.Variant
+}
+
+// Manifest has the parts of an image manifest, or of an index of them, we need
+type Manifest struct {
+	SchemaVersion int          `json:"schemaVersion"`
+	MediaType     string       `json:"mediaType,omitempty"`
+	Config        *Descriptor  `json:"config,omitempty"`
+	Layers        []Descriptor `json:"layers,omitempty"`
+	Manifests     []Descriptor `json:"manifests,omitempty"`
+}
+
+// IsIndex says whether the manifest is a list of the manifests of each platform of a multi-platform image
+func (m *Manifest) IsIndex() bool {
+	return m.Config == nil && m.Manifests != nil
+}
+
+// Fetcher downloads the content of images, from the repository a reference names, by digest
+type Fetcher interface {
+	Resolver
+	// FetchManifest returns a manifest, or index, and its media type
+	FetchManifest(ref reference.Spec, dgst digest.Digest) ([]byte, string, error)
+	// FetchBlob returns a config or layer
+	FetchBlob(ref reference.Spec, dgst digest.Digest) (io.ReadCloser, error)
+}
+
+// ParseManifest checks that a manifest is what its digest says and decodes it, taking its media type from the
+// manifest itself when there was none
+func ParseManifest(data []byte, mediaType string, dgst digest.Digest) (*Manifest, string, error) {
+	if dgst != "" && digest.FromBytes(data) != dgst {
+		return nil, "", fmt.Errorf("manifest %s has digest %s", dgst, digest.FromBytes(data))
+	}
+
+	manifest := &Manifest{}
+	if err := json.Unmarshal(data, manifest); err != nil {
+		return nil, "", fmt.Errorf("manifest %s: %v", dgst, err)
+	}
+
+	switch {
+	case manifest.MediaType != "":
+		mediaType = manifest.MediaType
+	case mediaType != "" && mediaType != "application/json" && mediaType != "text/plain":
+		// what the registry said it is
+	case manifest.IsIndex():
+		mediaType = "application/vnd.oci.image.index.v1+json"
+	default:
+		mediaType = "application/vnd.oci.image.manifest.v1+json"
+	}
+
+	return manifest, mediaType, nil
+}
+
+// Describe fetches the descriptor of the manifest a reference points at, by its digest if it has one
+func Describe(fetcher Fetcher, ref reference.Spec) (Descriptor, *Manifest, error) {
+	dgst := ref.Digest()
+	if dgst == "" {
+		var err error
+		if dgst, err = fetcher.Resolve(ref); err != nil {
+			return Descriptor{}, nil, fmt.Errorf("resolving %s: %v", ref.String(), err)
+		}
+	}
+
+	data, mediaType, err := fetcher.FetchManifest(ref, dgst)
+	if err != nil {
+		return Descriptor{}, nil, fmt.Errorf("%s: %v", ref.String(), err)
+	}
+
+	manifest, mediaType, err := ParseManifest(data, mediaType, dgst)
+	if err != nil {
+		return Descriptor{}, nil, fmt.Errorf("%s: %v", ref.String(), err)
+	}
+
+	return Descriptor{MediaType: mediaType, Digest: dgst, Size: int64(len(data))}, manifest, nil
+}
@@ -0,0 +1,193 @@
+package resolver
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/containerd/containerd/reference"
+	digest "github.com/opencontainers/go-digest"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+)
+
+// LayoutWriter copies images into an OCI image layout, named by their full reference the way linuxkit's cache
+// names them, so it can be imported with linuxkit cache import or used with --pin oci:<dir>
+type LayoutWriter struct {
+	Dir string
+	// Platforms limits which manifests of multi-platform images are copied, like linux/amd64, all when empty.
+	// The index is kept as it is, the way linuxkit's cache keeps it.
+	Platforms []string
+
+	index Manifest
+}
+
+// NewLayoutWriter starts a layout in dir, adding to what is already there
+func NewLayoutWriter(dir string, platforms []string) (*LayoutWriter, error) {
+	w := &LayoutWriter{Dir: dir, Platforms: platforms, index: Manifest{
+		SchemaVersion: 2,
+		MediaType:     "application/vnd.oci.image.index.v1+json",
+		Manifests:     []Descriptor{},
+	}}
+
+	if existing, err := (&OCILayout{Dir: dir}).index(); err == nil {
+		w.index.Manifests = existing.Manifests
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	return w, os.MkdirAll(dir, 0755)
+}
+
+func (w *LayoutWriter) platform(p *Platform) bool {
+	if len(w.Platforms) == 0 || p == nil {
+		return true
+	}
+
+	for _, platform := range w.Platforms {
+		if p.Matches(platform) {
+			return true
+		}
+	}
+	return false
+}
+
+// writeBlob copies a blob into the layout, unless it is already there, checking it is what its digest says
+func (w *LayoutWriter) writeBlob(dgst digest.Digest, content io.Reader) error {
+	file := blobPath(w.Dir, dgst)
+	if _, err := os.Stat(file); err == nil {
+		return nil
+	}
+
+	if err := os.MkdirAll(path.Dir(file), 0755); err != nil {
+		return err
+	}
+
+	// written under another name first, so an interrupted copy doesn't look complete
+	out, err := ioutil.TempFile(path.Dir(file), ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(out.Name())
+
+	verifier := dgst.Verifier()
+	_, err = io.Copy(io.MultiWriter(out, verifier), content)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if !verifier.Verified() {
+		return fmt.Errorf("blob %s doesn't match its digest", dgst)
+	}
+
+	if err := os.Chmod(out.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(out.Name(), file)
+}
+
+func (w *LayoutWriter) copyBlob(fetcher Fetcher, ref reference.Spec, dgst digest.Digest) error {
+	if _, err := os.Stat(blobPath(w.Dir, dgst)); err == nil {
+		return nil
+	}
+
+	content, err := fetcher.FetchBlob(ref, dgst)
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+
+	return w.writeBlob(dgst, content)
+}
+
+// copyManifest copies a manifest and everything it points at
+func (w *LayoutWriter) copyManifest(fetcher Fetcher, ref reference.Spec, dgst digest.Digest) error {
+	data, mediaType, err := fetcher.FetchManifest(ref, dgst)
+	if err != nil {
+		return err
+	}
+
+	manifest, _, err := ParseManifest(data, mediaType, dgst)
+	if err != nil {
+		return err
+	}
+
+	if err := w.copyContent(fetcher, ref, manifest); err != nil {
+		return err
+	}
+
+	// the manifest goes last, so everything it points at is there when it is
+	return w.writeBlob(dgst, bytes.NewReader(data))
+}
+
+func (w *LayoutWriter) copyContent(fetcher Fetcher, ref reference.Spec, manifest *Manifest) error {
+	if manifest.IsIndex() {
+		for _, child := range manifest.Manifests {
+			if !w.platform(child.Platform) {
+				continue
+			}
+			if err := w.copyManifest(fetcher, ref, child.Digest); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if manifest.Config != nil {
+		if err := w.copyBlob(fetcher, ref, manifest.Config.Digest); err != nil {
+			return err
+		}
+	}
+	for _, layer := range manifest.Layers {
+		if err := w.copyBlob(fetcher, ref, layer.Digest); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Copy copies an image into the layout, and names it with the reference
+func (w *LayoutWriter) Copy(fetcher Fetcher, ref reference.Spec) error {
+	desc, _, err := Describe(fetcher, ref)
+	if err != nil {
+		return err
+	}
+
+	if err := w.copyManifest(fetcher, ref, desc.Digest); err != nil {
+		return fmt.Errorf("%s: %v", ref.String(), err)
+	}
+
+	desc.Annotations = map[string]string{refNameAnnotation: ref.String()}
+	for idx, existing := range w.index.Manifests {
+		if existing.Annotations[refNameAnnotation] == ref.String() {
+			w.index.Manifests[idx] = desc
+			return nil
+		}
+	}
+	w.index.Manifests = append(w.index.Manifests, desc)
+
+	return nil
+}
+
+// Close writes the index of the layout, which is only valid from then on
+func (w *LayoutWriter) Close() error {
+	layout, err := json.Marshal(struct {
+		ImageLayoutVersion string `json:"imageLayoutVersion"`
+	}{"1.0.0"})
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path.Join(w.Dir, "oci-layout"), layout, 0644); err != nil {
+		return err
+	}
+
+	index, err := json.MarshalIndent(w.index, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path.Join(w.Dir, "index.json"), index, 0644)
+}
@@ -0,0 +1,132 @@
+package resolver
+
+import (
+	"encoding/json"
+	"fmt"
+	digest "github.com/opencontainers/go-digest"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+// testImage is a multi-platform image, by digest, with an image for amd64 and one for arm64
+func testImage(t *testing.T) (digest.Digest, map[digest.Digest][]byte) {
+	content := map[digest.Digest][]byte{}
+	add := func(data []byte) Descriptor {
+		dgst := digest.FromBytes(data)
+		content[dgst] = data
+		return Descriptor{Digest: dgst, Size: int64(len(data))}
+	}
+	addJSON := func(v interface{}) Descriptor {
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return add(data)
+	}
+
+	index := &Manifest{SchemaVersion: 2, MediaType: "application/vnd.oci.image.index.v1+json"}
+	for _, arch := range []string{"amd64", "arm64"} {
+		desc := addJSON(&Manifest{
+			SchemaVersion: 2,
+			MediaType:     "application/vnd.oci.image.manifest.v1+json",
+			Config:        &Descriptor{Digest: add([]byte(`{"architecture":"` + arch + `"}`)).Digest},
+			Layers:        []Descriptor{add([]byte("layer for " + arch))},
+		})
+		desc.Platform = &Platform{OS: "linux", Architecture: arch}
+		index.Manifests = append(index.Manifests, desc)
+	}
+
+	return addJSON(index).Digest, content
+}
+
+func TestLayoutWriter(t *testing.T) {
+	root, content := testImage(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/v2/team/app/"), "/", 2)
+		if len(parts) != 2 {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		object := parts[1]
+		if parts[0] == "manifests" && object == "1.0" {
+			object = root.String()
+		}
+
+		data, ok := content[digest.Digest(object)]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Docker-Content-Digest", object)
+		if r.Method == http.MethodGet {
+			w.Write(data)
+		}
+	}))
+	defer server.Close()
+
+	host := strings.TrimPrefix(server.URL, "http://")
+	registry := &Registry{Client: server.Client(), PlainHTTP: map[string]bool{host: true}}
+
+	dir, err := ioutil.TempDir("", "export")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writer, err := NewLayoutWriter(dir+"/registry", []string{"linux/amd64"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref := mustParse(t, host+"/team/app:1.0")
+	if err := writer.Copy(registry, ref); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	copied := map[string]bool{}
+	for dgst, data := range content {
+		if _, err := os.Stat(blobPath(dir+"/registry", dgst)); err == nil {
+			copied[string(data)] = true
+		}
+	}
+	if !copied["layer for amd64"] || !copied[`{"architecture":"amd64"}`] {
+		t.Errorf("the amd64 image wasn't copied")
+	}
+	if copied["layer for arm64"] || len(copied) != 4 {
+		t.Errorf("copied %d blobs, expected only the index and the amd64 image", len(copied))
+	}
+
+	// the layout is a source of its own
+	layout := &OCILayout{Dir: dir + "/registry"}
+	if got, err := layout.Resolve(ref); err != nil || got != root {
+		t.Errorf("exported image resolved to %s (%v), want %s", got, err, root)
+	}
+
+	writer, err = NewLayoutWriter(dir+"/layout", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Copy(layout, ref); err == nil {
+		t.Errorf("copying every platform from a layout without the arm64 image should have failed")
+	}
+
+	writer.Platforms = []string{"linux/amd64"}
+	pinned := mustParse(t, fmt.Sprintf("%s/team/app:1.0@%s", host, root))
+	if err := writer.Copy(layout, pinned); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := (&OCILayout{Dir: dir + "/layout"}).Resolve(ref); err != nil || got != root {
+		t.Errorf("image exported pinned resolved to %s (%v), want %s", got, err, root)
+	}
+}
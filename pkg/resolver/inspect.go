@@ -0,0 +1,117 @@
+package resolver
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/containerd/containerd/reference"
+	digest "github.com/opencontainers/go-digest"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+)
+
+// Inspector reads the configs of images from a source, keeping them on disk by the digest of the image, so each is
+// only fetched once and images which were inspected before can still be without access to the source
+type Inspector struct {
+	// Source is where images are read from
+	Source Fetcher
+	// Resolver resolves tags, like a lock file so they resolve to what they are pinned to, the source when nil
+	Resolver Resolver
+	// CacheDir is where configs are kept, none are when empty
+	CacheDir string
+	// Platform is the one inspected of multi-platform images, like linux/amd64
+	Platform string
+}
+
+// configPath is where the config of the image with a digest is kept, for the platform of the inspector
+func (i *Inspector) configPath(dgst digest.Digest) string {
+	return path.Join(i.CacheDir, "configs", strings.Replace(i.Platform, "/", "-", -1), dgst.Algorithm().String(), dgst.Hex()+".json")
+}
+
+// tagPath is where the digest a reference's tag last resolved to is kept
+func (i *Inspector) tagPath(ref reference.Spec) string {
+	return path.Join(i.CacheDir, "tags", digest.FromString(ref.String()).Hex())
+}
+
+func writeCacheFile(file string, data []byte) error {
+	if err := os.MkdirAll(path.Dir(file), 0755); err != nil {
+		return err
+	}
+
+	// written under another name first, so a file in the cache is always complete
+	tmp := fmt.Sprintf("%s.%d.tmp", file, os.Getpid())
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
+// resolve finds the digest of a reference, falling back to the one it last had when it can't be resolved
+func (i *Inspector) resolve(ref reference.Spec) (digest.Digest, error) {
+	if dgst := ref.Digest(); dgst != "" {
+		return dgst, nil
+	}
+
+	resolver := i.Resolver
+	if resolver == nil {
+		resolver = i.Source
+	}
+
+	dgst, err := resolver.Resolve(ref)
+	if err != nil {
+		if i.CacheDir == "" {
+			return "", err
+		}
+
+		cached, readErr := ioutil.ReadFile(i.tagPath(ref))
+		if readErr != nil {
+			return "", err
+		}
+		return digest.Parse(strings.TrimSpace(string(cached)))
+	}
+
+	if i.CacheDir != "" {
+		if err := writeCacheFile(i.tagPath(ref), []byte(dgst.String()+"\n")); err != nil {
+			return "", err
+		}
+	}
+
+	return dgst, nil
+}
+
+// Inspect returns the config of an image
+func (i *Inspector) Inspect(ref reference.Spec) (*ImageConfig, error) {
+	dgst, err := i.resolve(ref)
+	if err != nil {
+		return nil, fmt.Errorf("resolving %s: %v", ref.String(), err)
+	}
+	if err := dgst.Validate(); err != nil {
+		return nil, fmt.Errorf("resolving %s: %v", ref.String(), err)
+	}
+
+	config := &ImageConfig{}
+	if i.CacheDir != "" {
+		if data, err := ioutil.ReadFile(i.configPath(dgst)); err == nil && json.Unmarshal(data, config) == nil {
+			return config, nil
+		}
+	}
+
+	pinned := ref
+	pinned.Object = strings.SplitN(ref.Object, "@", 2)[0] + "@" + dgst.String()
+	if config, err = FetchConfig(i.Source, pinned, i.Platform); err != nil {
+		return nil, err
+	}
+
+	if i.CacheDir != "" {
+		data, err := json.Marshal(config)
+		if err != nil {
+			return nil, err
+		}
+		if err := writeCacheFile(i.configPath(dgst), data); err != nil {
+			return nil, err
+		}
+	}
+
+	return config, nil
+}
@@ -0,0 +1,42 @@
+package resolver
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestInspector(t *testing.T) {
+	dir, err := ioutil.TempDir("", "inspect")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	root, content := testImage(t)
+	fetcher := &memoryFetcher{root: root, content: content}
+	inspector := &Inspector{Source: fetcher, CacheDir: dir, Platform: "linux/arm64"}
+	ref := mustParse(t, "docker.io/team/app:1.0")
+
+	for i := 0; i < 2; i++ {
+		config, err := inspector.Inspect(ref)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if config.Architecture != "arm64" {
+			t.Errorf("got the config of %s", config.Architecture)
+		}
+	}
+	if fetcher.fetches != 3 {
+		t.Errorf("fetched %d times, the second inspection should have been from the cache", fetcher.fetches)
+	}
+
+	// without access to the source, the tag resolves to what it last did
+	fetcher.content = nil
+	if config, err := inspector.Inspect(ref); err != nil || config.Architecture != "arm64" {
+		t.Errorf("inspecting from the cache failed: %v", err)
+	}
+	if _, err := inspector.Inspect(mustParse(t, "docker.io/team/other:1.0")); err == nil {
+		t.Errorf("expected an image which was never inspected to fail")
+	}
+}
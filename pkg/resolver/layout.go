@@ -0,0 +1,90 @@
+package resolver
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/containerd/containerd/reference"
+	digest "github.com/opencontainers/go-digest"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+)
+
+// the annotation an OCI image layout's index names its images with
+const refNameAnnotation = "org.opencontainers.image.ref.name"
+
+// OCILayout resolves references from the index of an OCI image layout, like one written by skopeo or buildkit, whose
+// images are named either by their full reference or just their tag
+type OCILayout struct {
+	Dir string
+}
+
+func (l *OCILayout) index() (*Manifest, error) {
+	data, err := ioutil.ReadFile(path.Join(l.Dir, "index.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	index := &Manifest{}
+	if err := json.Unmarshal(data, index); err != nil {
+		return nil, fmt.Errorf("%s: %v", path.Join(l.Dir, "index.json"), err)
+	}
+
+	return index, nil
+}
+
+// blobPath is where the layout keeps the blob with a digest
+func blobPath(dir string, dgst digest.Digest) string {
+	return path.Join(dir, "blobs", dgst.Algorithm().String(), dgst.Hex())
+}
+
+// Resolve finds the manifest the layout's index has for the reference
+func (l *OCILayout) Resolve(ref reference.Spec) (digest.Digest, error) {
+	tag, err := tag(ref)
+	if err != nil {
+		return "", err
+	}
+
+	index, err := l.index()
+	if err != nil {
+		return "", err
+	}
+
+	// a full reference is more specific than a tag any image could have
+	for _, name := range []string{ref.String(), tag} {
+		for _, manifest := range index.Manifests {
+			// images exported pinned are named with their digest too
+			if strings.SplitN(manifest.Annotations[refNameAnnotation], "@", 2)[0] == name {
+				return manifest.Digest, nil
+			}
+		}
+	}
+
+	return "", fmt.Errorf("not in the image layout at %s", l.Dir)
+}
+
+// FetchManifest reads a manifest, or index, from the layout, whichever repository the reference names
+func (l *OCILayout) FetchManifest(ref reference.Spec, dgst digest.Digest) ([]byte, string, error) {
+	if err := dgst.Validate(); err != nil {
+		return nil, "", err
+	}
+
+	data, err := ioutil.ReadFile(blobPath(l.Dir, dgst))
+	if err != nil {
+		return nil, "", err
+	}
+
+	// the media type is in the manifest, or the index entry of the image
+	return data, "", nil
+}
+
+// FetchBlob reads a config or layer from the layout
+func (l *OCILayout) FetchBlob(ref reference.Spec, dgst digest.Digest) (io.ReadCloser, error) {
+	if err := dgst.Validate(); err != nil {
+		return nil, err
+	}
+
+	return os.Open(blobPath(l.Dir, dgst))
+}
@@ -0,0 +1,113 @@
+package resolver
+
+import (
+	"fmt"
+	"github.com/containerd/containerd/reference"
+	digest "github.com/opencontainers/go-digest"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+)
+
+// LockFile resolves references from the digests recorded for them, keyed by their normalized reference
+type LockFile struct {
+	Images map[string]digest.Digest `yaml:"images"`
+}
+
+// LoadLockFile reads a lock file
+func LoadLockFile(file string) (*LockFile, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	lock := &LockFile{}
+	if err := yaml.UnmarshalStrict(data, lock); err != nil {
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+
+	return lock, nil
+}
+
+// Resolve returns the digest recorded for the reference
+func (l *LockFile) Resolve(ref reference.Spec) (digest.Digest, error) {
+	if dgst, ok := l.Images[ref.String()]; ok {
+		return dgst, nil
+	}
+
+	return "", fmt.Errorf("not in the lock file")
+}
+
+// lockFileHeader is written at the top of every lock file
+const lockFileHeader = "# the digests images were pinned to by podspec2linuxkit, change them with --update-lock\n"
+
+// Save writes the lock file, with its images in order so it only changes where a digest does
+func (l *LockFile) Save(file string) error {
+	data, err := yaml.Marshal(l)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(file, append([]byte(lockFileHeader), data...), 0644)
+}
+
+// Locked resolves references from a lock file, which is authoritative: a reference it doesn't have is an error.
+// When updating, every reference is resolved through another resolver instead, and recorded in the lock file, which
+// Prune then leaves with just those.
+type Locked struct {
+	Lock     *LockFile
+	Resolver Resolver
+	// Update ignores what is in the lock file
+	Update bool
+	// Changed is set once the lock file differs from what was loaded
+	Changed bool
+
+	// what has been resolved while updating, which doesn't need to be again
+	updated map[string]bool
+}
+
+// Resolve returns the digest recorded for the reference, or when updating resolves and records it
+func (l *Locked) Resolve(ref reference.Spec) (digest.Digest, error) {
+	if l.updated[ref.String()] {
+		return l.Lock.Resolve(ref)
+	}
+	if !l.Update {
+		if dgst, err := l.Lock.Resolve(ref); err == nil {
+			return dgst, nil
+		}
+		return "", fmt.Errorf("not in the lock file, add it with --update-lock")
+	}
+
+	dgst, err := l.Resolver.Resolve(ref)
+	if err != nil {
+		return "", err
+	}
+
+	if l.Lock.Images == nil {
+		l.Lock.Images = map[string]digest.Digest{}
+	}
+	if l.updated == nil {
+		l.updated = map[string]bool{}
+	}
+	l.updated[ref.String()] = true
+	if l.Lock.Images[ref.String()] != dgst {
+		l.Lock.Images[ref.String()] = dgst
+		l.Changed = true
+	}
+
+	return dgst, nil
+}
+
+// Prune drops what the lock file has for references which weren't resolved while updating, so it only keeps those
+// still in use. It does nothing otherwise, since the references given to Resolve may not be all of those in use.
+func (l *Locked) Prune() {
+	if !l.Update {
+		return
+	}
+
+	for ref := range l.Lock.Images {
+		if !l.updated[ref] {
+			delete(l.Lock.Images, ref)
+			l.Changed = true
+		}
+	}
+}
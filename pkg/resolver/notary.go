@@ -0,0 +1,134 @@
+package resolver
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/containerd/containerd/reference"
+	digest "github.com/opencontainers/go-digest"
+	"net/http"
+	"strings"
+)
+
+// the Notary server of images on Docker Hub
+const dockerHubNotary = "https://notary.docker.io"
+
+// the TUF roles whose targets are signed tags, docker trust signs into targets/releases
+var notaryRoles = []string{"targets/releases", "targets"}
+
+// the parts of a TUF targets role we need
+type tufTargets struct {
+	Signed struct {
+		Targets map[string]struct {
+			Hashes map[string]string `json:"hashes"`
+		} `json:"targets"`
+	} `json:"signed"`
+}
+
+// Notary resolves references from the tags signed on a Notary server, the same trust data linuxkit build checks,
+// so a tag that hasn't been signed doesn't resolve. The server is trusted to serve the targets it has, their
+// signatures are only verified by linuxkit build.
+type Notary struct {
+	// Client is used for every request, http.DefaultClient when nil
+	Client *http.Client
+	// Servers maps registry host names to the URL of their Notary server, notary.docker.io is used for docker.io
+	Servers map[string]string
+}
+
+func (n *Notary) client() *http.Client {
+	if n.Client != nil {
+		return n.Client
+	}
+	return http.DefaultClient
+}
+
+func (n *Notary) server(ref reference.Spec) (string, error) {
+	if server, ok := n.Servers[ref.Hostname()]; ok {
+		return strings.TrimSuffix(server, "/"), nil
+	}
+	if ref.Hostname() == "docker.io" {
+		return dockerHubNotary, nil
+	}
+	return "", fmt.Errorf("no Notary server for %s", ref.Hostname())
+}
+
+// targets fetches a role's targets, nil when the repository has no such role
+func (n *Notary) targets(url string) (*tufTargets, error) {
+	bearer := ""
+	for attempt := 0; attempt < 2; attempt++ {
+		req, err := http.NewRequest(http.MethodGet, url, nil)
+		if err != nil {
+			return nil, err
+		}
+		if bearer != "" {
+			req.Header.Set("Authorization", "Bearer "+bearer)
+		}
+
+		resp, err := n.client().Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+
+		switch resp.StatusCode {
+		case http.StatusOK:
+			targets := &tufTargets{}
+			if err := json.NewDecoder(resp.Body).Decode(targets); err != nil {
+				return nil, fmt.Errorf("%s: %v", url, err)
+			}
+			return targets, nil
+		case http.StatusNotFound:
+			return nil, nil
+		case http.StatusUnauthorized:
+			if bearer != "" {
+				break
+			}
+			if bearer, err = token(n.client(), resp.Header.Get("WWW-Authenticate"), "", ""); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		return nil, fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+
+	return nil, fmt.Errorf("GET %s: unauthorized", url)
+}
+
+// Resolve returns the digest the reference's tag was signed with
+func (n *Notary) Resolve(ref reference.Spec) (digest.Digest, error) {
+	tag, err := tag(ref)
+	if err != nil {
+		return "", err
+	}
+
+	server, err := n.server(ref)
+	if err != nil {
+		return "", err
+	}
+
+	for _, role := range notaryRoles {
+		targets, err := n.targets(fmt.Sprintf("%s/v2/%s/_trust/tuf/%s.json", server, ref.Locator, role))
+		if err != nil {
+			return "", err
+		}
+		if targets == nil {
+			continue
+		}
+
+		target, ok := targets.Signed.Targets[tag]
+		if !ok {
+			continue
+		}
+
+		sum, err := base64.StdEncoding.DecodeString(target.Hashes["sha256"])
+		if err != nil || len(sum) == 0 {
+			return "", fmt.Errorf("tag %s was signed without a sha256 hash", tag)
+		}
+
+		return digest.NewDigestFromHex("sha256", hex.EncodeToString(sum)), nil
+	}
+
+	return "", fmt.Errorf("%s is not signed", ref.String())
+}
@@ -0,0 +1,235 @@
+package resolver
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/containerd/containerd/reference"
+	digest "github.com/opencontainers/go-digest"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Registry resolves references by asking their registry, through the Docker Registry HTTP API V2
+type Registry struct {
+	// Client is used for every request, http.DefaultClient when nil
+	Client *http.Client
+	// PlainHTTP registries are talked to without TLS, by host name
+	PlainHTTP map[string]bool
+	// Credentials returns the username and password for a registry host, if there are any
+	Credentials func(host string) (string, string)
+
+	// the Authorization header each repository was last accepted with
+	authorizations map[string]string
+}
+
+// registryHost is where the API of the registry a reference names can be found
+func registryHost(ref reference.Spec) string {
+	if host := ref.Hostname(); host != "docker.io" {
+		return host
+	}
+	return "registry-1.docker.io"
+}
+
+// repository is the name of a reference within its registry
+func repository(ref reference.Spec) string {
+	return strings.TrimPrefix(ref.Locator, ref.Hostname()+"/")
+}
+
+func (r *Registry) client() *http.Client {
+	if r.Client != nil {
+		return r.Client
+	}
+	return http.DefaultClient
+}
+
+// apiURL is where the manifests or blobs of a reference's repository are
+func (r *Registry) apiURL(ref reference.Spec, kind string, object string) string {
+	scheme := "https"
+	if r.PlainHTTP[ref.Hostname()] {
+		scheme = "http"
+	}
+	return fmt.Sprintf("%s://%s/v2/%s/%s/%s", scheme, registryHost(ref), repository(ref), kind, object)
+}
+
+var challengeParam = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+// token gets a pull token from the realm a registry, or Notary server, sent us to in its Bearer challenge,
+// anonymously unless there is a username
+func token(client *http.Client, challenge string, username string, password string) (string, error) {
+	if !strings.HasPrefix(strings.ToLower(challenge), "bearer ") {
+		return "", fmt.Errorf("unsupported authentication challenge: %s", challenge)
+	}
+
+	params := url.Values{}
+	realm := ""
+	for _, match := range challengeParam.FindAllStringSubmatch(challenge, -1) {
+		if match[1] == "realm" {
+			realm = match[2]
+		} else {
+			params.Set(match[1], match[2])
+		}
+	}
+	if realm == "" {
+		return "", fmt.Errorf("authentication challenge without a realm: %s", challenge)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, realm+"?"+params.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+	if username != "" {
+		req.SetBasicAuth(username, password)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("getting a token from %s: %s", realm, resp.Status)
+	}
+
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", err
+	}
+
+	if token.Token != "" {
+		return token.Token, nil
+	}
+	return token.AccessToken, nil
+}
+
+// authorize answers a registry's challenge, with the credentials for it if there are any
+func (r *Registry) authorize(ref reference.Spec, challenge string) (string, error) {
+	username, password := "", ""
+	if r.Credentials != nil {
+		username, password = r.Credentials(ref.Hostname())
+	}
+
+	if strings.HasPrefix(strings.ToLower(challenge), "basic ") {
+		if username == "" {
+			return "", fmt.Errorf("%s needs credentials", ref.Hostname())
+		}
+		req := &http.Request{Header: http.Header{}}
+		req.SetBasicAuth(username, password)
+		return req.Header.Get("Authorization"), nil
+	}
+
+	bearer, err := token(r.client(), challenge, username, password)
+	if err != nil {
+		return "", err
+	}
+	return "Bearer " + bearer, nil
+}
+
+// do makes a request of a reference's repository, authorizing it when the registry asks, and returns the response
+// when it was successful
+func (r *Registry) do(ref reference.Spec, method string, url string, accept []string) (*http.Response, error) {
+	if r.authorizations == nil {
+		r.authorizations = map[string]string{}
+	}
+
+	for attempt := 0; attempt < 2; attempt++ {
+		req, err := http.NewRequest(method, url, nil)
+		if err != nil {
+			return nil, err
+		}
+		if len(accept) > 0 {
+			req.Header.Set("Accept", strings.Join(accept, ", "))
+		}
+		if authorization := r.authorizations[ref.Locator]; authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+
+		resp, err := r.client().Do(req)
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode == http.StatusUnauthorized && attempt == 0 {
+			resp.Body.Close()
+			if r.authorizations[ref.Locator], err = r.authorize(ref, resp.Header.Get("WWW-Authenticate")); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("%s %s: %s", method, url, resp.Status)
+		}
+
+		return resp, nil
+	}
+
+	return nil, fmt.Errorf("%s %s: unauthorized", method, url)
+}
+
+// Resolve asks the registry for the digest of the reference's manifest, without downloading it when the registry
+// says what it is
+func (r *Registry) Resolve(ref reference.Spec) (digest.Digest, error) {
+	tag, err := tag(ref)
+	if err != nil {
+		return "", err
+	}
+
+	manifest := r.apiURL(ref, "manifests", tag)
+	resp, err := r.do(ref, http.MethodHead, manifest, manifestMediaTypes)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+
+	if dgst := resp.Header.Get("Docker-Content-Digest"); dgst != "" {
+		return digest.Parse(dgst)
+	}
+
+	// without the header, the manifest has to be downloaded to know its digest
+	if resp, err = r.do(ref, http.MethodGet, manifest, manifestMediaTypes); err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+
+	return digest.FromBytes(body), nil
+}
+
+// FetchManifest downloads a manifest, or index, of the reference's repository by digest
+func (r *Registry) FetchManifest(ref reference.Spec, dgst digest.Digest) ([]byte, string, error) {
+	resp, err := r.do(ref, http.MethodGet, r.apiURL(ref, "manifests", dgst.String()), manifestMediaTypes)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return data, resp.Header.Get("Content-Type"), nil
+}
+
+// FetchBlob downloads a config or layer of the reference's repository by digest
+func (r *Registry) FetchBlob(ref reference.Spec, dgst digest.Digest) (io.ReadCloser, error) {
+	resp, err := r.do(ref, http.MethodGet, r.apiURL(ref, "blobs", dgst.String()), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Body, nil
+}
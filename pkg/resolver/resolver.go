@@ -0,0 +1,55 @@
+// Package resolver finds the digest an image reference's tag points at, from a registry, a local OCI image layout or
+// a lock file, so references can be pinned to it, and fetches the content of images from a registry or layout.
+package resolver
+
+import (
+	"fmt"
+	"github.com/containerd/containerd/reference"
+	digest "github.com/opencontainers/go-digest"
+	"strings"
+)
+
+// Resolver finds the manifest digest a reference's tag points at
+type Resolver interface {
+	Resolve(ref reference.Spec) (digest.Digest, error)
+}
+
+// the media types of manifests, most preferred first, so multi-platform images resolve to their index
+var manifestMediaTypes = []string{
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+	"application/vnd.oci.image.manifest.v1+json",
+}
+
+// Pin adds the digest a reference resolves to, unless it already has one
+func Pin(resolver Resolver, ref *reference.Spec) error {
+	if ref.Digest() != "" {
+		return nil
+	}
+
+	dgst, err := resolver.Resolve(*ref)
+	if err != nil {
+		return fmt.Errorf("resolving %s: %v", ref.String(), err)
+	}
+
+	if err := dgst.Validate(); err != nil {
+		return fmt.Errorf("resolving %s: %v", ref.String(), err)
+	}
+
+	// keeping the tag says what was pinned, the digest is what is pulled
+	ref.Object = ref.Object + "@" + dgst.String()
+
+	return nil
+}
+
+// tag returns the tag of a reference, which resolvers need one to look up
+func tag(ref reference.Spec) (string, error) {
+	tag, _ := reference.SplitObject(ref.Object)
+	tag = strings.TrimSuffix(tag, "@")
+	if tag == "" {
+		return "", fmt.Errorf("%s has no tag", ref.String())
+	}
+
+	return tag, nil
+}
@@ -0,0 +1,231 @@
+package resolver
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"github.com/containerd/containerd/reference"
+	digest "github.com/opencontainers/go-digest"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func mustParse(t *testing.T, s string) reference.Spec {
+	ref, err := reference.Parse(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ref
+}
+
+func TestRegistry(t *testing.T) {
+	manifest := []byte(`{"schemaVersion":2}`)
+	want := digest.FromBytes(manifest)
+
+	for _, test := range []struct {
+		name string
+		// whether HEAD answers with Docker-Content-Digest
+		header bool
+	}{
+		{name: "digest header", header: true},
+		{name: "digest of the manifest", header: false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var server *httptest.Server
+			server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch {
+				case r.URL.Path == "/token":
+					if r.URL.Query().Get("scope") != "repository:team/app:pull" {
+						t.Errorf("unexpected scope %s", r.URL.Query().Get("scope"))
+					}
+					fmt.Fprint(w, `{"token":"secret"}`)
+				case r.Header.Get("Authorization") != "Bearer secret":
+					w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="test",scope="repository:team/app:pull"`, server.URL))
+					w.WriteHeader(http.StatusUnauthorized)
+				case r.URL.Path != "/v2/team/app/manifests/1.0":
+					w.WriteHeader(http.StatusNotFound)
+				case !strings.Contains(r.Header.Get("Accept"), "manifest.list.v2+json"):
+					t.Errorf("manifest lists aren't accepted: %s", r.Header.Get("Accept"))
+				case test.header:
+					w.Header().Set("Docker-Content-Digest", want.String())
+				case r.Method == http.MethodGet:
+					w.Write(manifest)
+				}
+			}))
+			defer server.Close()
+
+			host := strings.TrimPrefix(server.URL, "http://")
+			registry := &Registry{Client: server.Client(), PlainHTTP: map[string]bool{host: true}}
+
+			ref := mustParse(t, host+"/team/app:1.0")
+			if err := Pin(registry, &ref); err != nil {
+				t.Fatal(err)
+			}
+			if ref.String() != host+"/team/app:1.0@"+want.String() {
+				t.Errorf("pinned to %s", ref.String())
+			}
+
+			missing := mustParse(t, host+"/team/app:2.0")
+			if err := Pin(registry, &missing); err == nil {
+				t.Errorf("expected an unknown tag to fail")
+			}
+		})
+	}
+}
+
+func TestOCILayout(t *testing.T) {
+	dir, err := ioutil.TempDir("", "layout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	full := digest.FromString("full")
+	tagged := digest.FromString("tagged")
+	index := fmt.Sprintf(`{"schemaVersion":2,"manifests":[
+  {"digest":"%s","annotations":{"org.opencontainers.image.ref.name":"1.0"}},
+  {"digest":"%s","annotations":{"org.opencontainers.image.ref.name":"docker.io/library/busybox:1.0"}}
+]}`, tagged, full)
+	if err := ioutil.WriteFile(path.Join(dir, "index.json"), []byte(index), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	layout := &OCILayout{Dir: dir}
+	for ref, want := range map[string]digest.Digest{
+		"docker.io/library/busybox:1.0": full,
+		"docker.io/library/alpine:1.0":  tagged,
+		"docker.io/library/alpine:2.0":  "",
+	} {
+		got, err := layout.Resolve(mustParse(t, ref))
+		if want == "" && err == nil {
+			t.Errorf("%s: expected no image, got %s", ref, got)
+		} else if want != "" && got != want {
+			t.Errorf("%s: got %s (%v), want %s", ref, got, err, want)
+		}
+	}
+}
+
+func TestPinKeepsDigests(t *testing.T) {
+	lock := &LockFile{Images: map[string]digest.Digest{
+		"docker.io/library/busybox:latest": digest.FromString("busybox"),
+	}}
+
+	pinned := "docker.io/library/alpine:3.8@" + digest.FromString("alpine").String()
+	ref := mustParse(t, pinned)
+	if err := Pin(lock, &ref); err != nil || ref.String() != pinned {
+		t.Errorf("got %s (%v), want %s", ref.String(), err, pinned)
+	}
+
+	ref = mustParse(t, "docker.io/library/busybox:latest")
+	if err := Pin(lock, &ref); err != nil || ref.Digest() != digest.FromString("busybox") {
+		t.Errorf("got %s (%v)", ref.String(), err)
+	}
+}
+
+// countingResolver resolves everything to the same digest, counting how often it is asked
+type countingResolver struct {
+	digest digest.Digest
+	calls  int
+}
+
+func (r *countingResolver) Resolve(ref reference.Spec) (digest.Digest, error) {
+	r.calls++
+	return r.digest, nil
+}
+
+func TestLocked(t *testing.T) {
+	old := digest.FromString("old")
+	current := &countingResolver{digest: digest.FromString("current")}
+
+	locked := &Locked{
+		Lock:     &LockFile{Images: map[string]digest.Digest{"docker.io/library/busybox:latest": old}},
+		Resolver: current,
+	}
+
+	busybox := mustParse(t, "docker.io/library/busybox:latest")
+	alpine := mustParse(t, "docker.io/library/alpine:3.8")
+
+	if got, _ := locked.Resolve(busybox); got != old || locked.Changed {
+		t.Errorf("locked image resolved to %s", got)
+	}
+	// the lock file is authoritative, an image it doesn't have isn't resolved elsewhere
+	if got, err := locked.Resolve(alpine); err == nil || current.calls != 0 || locked.Changed {
+		t.Errorf("new image resolved to %s", got)
+	}
+	locked.Prune()
+	if len(locked.Lock.Images) != 1 || locked.Changed {
+		t.Errorf("pruned the lock file without updating it, it has %v", locked.Lock.Images)
+	}
+
+	locked.Lock.Images["docker.io/library/nginx:1.15"] = old
+	locked = &Locked{Lock: locked.Lock, Resolver: current, Update: true}
+	for i := 0; i < 2; i++ {
+		for _, ref := range []reference.Spec{busybox, alpine} {
+			if got, _ := locked.Resolve(ref); got != current.digest {
+				t.Errorf("updated image resolved to %s", got)
+			}
+		}
+	}
+	if current.calls != 2 || !locked.Changed {
+		t.Errorf("updating resolved %d times", current.calls)
+	}
+
+	// nginx isn't used anymore
+	locked.Prune()
+	if _, ok := locked.Lock.Images["docker.io/library/nginx:1.15"]; ok || len(locked.Lock.Images) != 2 {
+		t.Errorf("pruned lock file has %v", locked.Lock.Images)
+	}
+
+	dir, err := ioutil.TempDir("", "lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := path.Join(dir, "podspec2linuxkit.lock")
+	if err := locked.Lock.Save(file); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadLockFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded.Images) != 2 || loaded.Images["docker.io/library/busybox:latest"] != current.digest {
+		t.Errorf("saved lock file has %v", loaded.Images)
+	}
+}
+
+func TestNotary(t *testing.T) {
+	signed := digest.FromString("signed")
+	sum, _ := hex.DecodeString(signed.Hex())
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2/registry.internal/team/app/_trust/tuf/targets/releases.json":
+			fmt.Fprintf(w, `{"signed":{"targets":{"1.0":{"hashes":{"sha256":"%s"},"length":1}}}}`, base64.StdEncoding.EncodeToString(sum))
+		case "/v2/registry.internal/team/app/_trust/tuf/targets.json":
+			fmt.Fprint(w, `{"signed":{"targets":{}}}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	notary := &Notary{Client: server.Client(), Servers: map[string]string{"registry.internal": server.URL}}
+
+	got, err := notary.Resolve(mustParse(t, "registry.internal/team/app:1.0"))
+	if err != nil || got != signed {
+		t.Errorf("got %s (%v), want %s", got, err, signed)
+	}
+
+	for _, ref := range []string{"registry.internal/team/app:2.0", "registry.internal/team/other:1.0", "quay.io/team/app:1.0"} {
+		if got, err := notary.Resolve(mustParse(t, ref)); err == nil {
+			t.Errorf("%s: expected it not to be signed, got %s", ref, got)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+// +build ignore
+
+// generate writes cmd/templates.go, which has the templates the tool embeds for --platform. It is run by go generate
+// in cmd, so the profiles are always those of the files in this directory.
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"strings"
+)
+
+func main() {
+	files, err := filepath.Glob("../templates/base_*.yaml")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "// Code generated by templates/generate.go from templates/base_*.yaml. DO NOT EDIT.\n\n")
+	fmt.Fprintf(&out, "package main\n\n")
+	fmt.Fprintf(&out, "// embeddedTemplates are the files of templates/, by name\n")
+	fmt.Fprintf(&out, "var embeddedTemplates = map[string]string{\n")
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if strings.Contains(string(data), "`") {
+			log.Fatalf("%s: templates can't have backquotes", file)
+		}
+		fmt.Fprintf(&out, "%q: `%s`,\n", filepath.Base(file), data)
+	}
+	fmt.Fprintf(&out, "}\n")
+
+	source, err := format.Source(out.Bytes())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := ioutil.WriteFile("templates.go", source, 0644); err != nil {
+		log.Fatal(err)
+	}
+}